@@ -0,0 +1,74 @@
+// Command aliyundrive-http serves an aliyun drive as browsable HTTP directory listings.
+//
+//	aliyundrive-http -config .config -addr :8081
+//
+// With -redirect, files are not streamed through the server, clients are redirected
+// to the signed download urls, which require the "Referer: https://www.aliyundrive.com/" header.
+// With -share <url>, the files of a share link are served instead of the drive.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/config"
+	"github.com/K265/aliyundrive-go/internal/httpauth"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/proxy"
+)
+
+func main() {
+	configPath := flag.String("config", config.DefaultPath, "path of the config file")
+	addr := flag.String("addr", ":8081", "address to listen on")
+	redirect := flag.Bool("redirect", false, "redirect to signed download urls instead of streaming files")
+	user := flag.String("user", "", "basic auth user, empty disables authentication")
+	password := flag.String("password", os.Getenv("ALIYUNDRIVE_HTTP_PASSWORD"), "basic auth password")
+	cacheTTL := flag.Duration("cache-ttl", drive.DefaultCacheTTL, "how long file metadata is cached")
+	driveId := flag.String("drive", "", "serve this drive: a drive id, default, resource, backup or album")
+	share := flag.String("share", "", "serve the files of this share link (url or id)")
+	sharePassword := flag.String("share-password", "", "password of the -share link")
+	flag.Parse()
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	fs, err := drive.NewFs(context.Background(), conf)
+	if err != nil {
+		log.Fatalf("failed to log in: %+v", err)
+	}
+
+	d := fs.(*drive.Drive)
+	if *driveId != "" {
+		d, err = d.OpenDrive(context.Background(), *driveId)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		fs = d
+	}
+
+	if *share != "" {
+		fs, err = d.OpenShare(context.Background(), drive.ShareIdFromUrl(*share), *sharePassword)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	handler := proxy.NewHandler(fs, proxy.Options{
+		Redirect:       *redirect,
+		UseInternalUrl: conf.UseInternalUrl,
+		CacheTTL:       *cacheTTL,
+	})
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           httpauth.BasicAuth(handler, "aliyundrive", *user, *password),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	log.Printf("serving HTTP on %s", *addr)
+	log.Fatal(server.ListenAndServe())
+}
@@ -0,0 +1,54 @@
+// Command aliyundrive-s3 serves an aliyun drive over the S3 API, top level folders are the buckets.
+//
+//	ALIYUNDRIVE_S3_SECRET_KEY=secret aliyundrive-s3 -config .config -addr :9000 -access-key access
+//
+// Clients have to use path style requests, e.g.
+//
+//	aws --endpoint-url http://localhost:9000 s3 ls s3://bucket/
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/config"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/s3"
+)
+
+func main() {
+	configPath := flag.String("config", config.DefaultPath, "path of the config file")
+	addr := flag.String("addr", ":9000", "address to listen on")
+	accessKey := flag.String("access-key", "", "access key clients sign requests with, empty disables authentication")
+	secretKey := flag.String("secret-key", os.Getenv("ALIYUNDRIVE_S3_SECRET_KEY"), "secret key clients sign requests with")
+	cacheTTL := flag.Duration("cache-ttl", drive.DefaultCacheTTL, "how long file metadata is cached")
+	tempDir := flag.String("temp-dir", "", "folder where uploads are spooled, defaults to the system temporary folder")
+	flag.Parse()
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	fs, err := drive.NewFs(context.Background(), conf)
+	if err != nil {
+		log.Fatalf("failed to log in: %+v", err)
+	}
+
+	server := &http.Server{
+		Addr: *addr,
+		Handler: s3.NewGateway(fs, s3.Options{
+			AccessKey: *accessKey,
+			SecretKey: *secretKey,
+			CacheTTL:  *cacheTTL,
+			TempDir:   *tempDir,
+		}),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	log.Printf("serving S3 on %s", *addr)
+	log.Fatal(server.ListenAndServe())
+}
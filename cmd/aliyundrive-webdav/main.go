@@ -0,0 +1,84 @@
+// Command aliyundrive-webdav serves an aliyun drive over WebDAV.
+//
+//	aliyundrive-webdav -config .config -addr :8080 -user admin -password secret
+//
+// The password may also be given by the ALIYUNDRIVE_WEBDAV_PASSWORD environment variable.
+// With -crypt /folder, the files of the folder are served decrypted, they are encrypted with
+// the passphrase in the ALIYUNDRIVE_CRYPT_PASSWORD environment variable.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/config"
+	"github.com/K265/aliyundrive-go/internal/httpauth"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/crypt"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/webdav"
+)
+
+func main() {
+	configPath := flag.String("config", config.DefaultPath, "path of the config file")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	prefix := flag.String("prefix", "", "URL path prefix to strip, e.g. /dav")
+	user := flag.String("user", "", "basic auth user, empty disables authentication")
+	password := flag.String("password", os.Getenv("ALIYUNDRIVE_WEBDAV_PASSWORD"), "basic auth password")
+	cacheTTL := flag.Duration("cache-ttl", drive.DefaultCacheTTL, "how long file metadata is cached")
+	driveId := flag.String("drive", "", "serve this drive: a drive id, default, resource, backup or album")
+	cryptRoot := flag.String("crypt", "", "serve the files of this folder, encrypted with the passphrase in ALIYUNDRIVE_CRYPT_PASSWORD")
+	flag.Parse()
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	fs, err := drive.NewFs(context.Background(), conf)
+	if err != nil {
+		log.Fatalf("failed to log in: %+v", err)
+	}
+
+	d := fs.(*drive.Drive)
+	if *driveId != "" {
+		d, err = d.OpenDrive(context.Background(), *driveId)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		fs = d
+	}
+
+	if *cryptRoot != "" {
+		passphrase := os.Getenv("ALIYUNDRIVE_CRYPT_PASSWORD")
+		if passphrase == "" {
+			log.Fatalf("ALIYUNDRIVE_CRYPT_PASSWORD is required with -crypt")
+		}
+		fs, err = crypt.NewFs(context.Background(), fs, crypt.Options{Root: *cryptRoot, Passphrase: passphrase})
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	handler := webdav.NewHandler(webdav.NewFileSystem(fs, *cacheTTL), *prefix)
+	handler.Logger = func(r *http.Request, err error) {
+		if err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+
+	if *user == "" {
+		log.Printf("warning: serving without authentication")
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           httpauth.BasicAuth(handler, "aliyundrive", *user, *password),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	log.Printf("serving WebDAV on %s", *addr)
+	log.Fatal(server.ListenAndServe())
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+)
+
+func init() {
+	register("album", command{
+		usage: "[-description d] <name> | -list | -ls <id> | -add <id> <path>... | -remove <id> <path>... | -rename <id> [-description d] <name> | -delete <id>",
+		help:  "create, list or edit the albums of the album drive",
+		run:   album,
+	})
+}
+
+type albumInfo struct {
+	AlbumId     string `json:"album_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	FileCount   int64  `json:"file_count"`
+	Created     string `json:"created"`
+}
+
+func newAlbumInfo(a *drive.Album) albumInfo {
+	return albumInfo{
+		AlbumId:     a.AlbumId,
+		Name:        a.Name,
+		Description: a.Description,
+		FileCount:   a.FileCount,
+		Created:     a.Created.Local().Format(timeLayout),
+	}
+}
+
+func (c *cli) printAlbums(albums []drive.Album) error {
+	infos := make([]albumInfo, len(albums))
+	for i := range albums {
+		infos[i] = newAlbumInfo(&albums[i])
+	}
+	return c.print(infos, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, info := range infos {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", info.AlbumId, info.Name, info.FileCount, info.Created)
+		}
+		tw.Flush()
+	})
+}
+
+// albumNodes returns the nodes at the paths of args.
+func (c *cli) albumNodes(args []string) ([]drive.Node, error) {
+	nodes := make([]drive.Node, 0, len(args))
+	for _, arg := range args {
+		node, err := c.fs.Stat(c.ctx, remotePath(arg))
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, *node)
+	}
+	return nodes, nil
+}
+
+func album(c *cli, args []string) error {
+	flags := flag.NewFlagSet("album", flag.ContinueOnError)
+	description := flags.String("description", "", "description of the album")
+	list := flags.Bool("list", false, "list the albums")
+	ls := flags.String("ls", "", "list the files of the album with this id")
+	add := flags.String("add", "", "add files to the album with this id")
+	remove := flags.String("remove", "", "remove files from the album with this id, they are kept in the drive")
+	rename := flags.String("rename", "", "rename the album with this id")
+	del := flags.String("delete", "", "delete the album with this id, its files are kept in the drive")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fs, err := c.drive()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *list:
+		albums, err := fs.ListAlbums(c.ctx)
+		if err != nil {
+			return err
+		}
+		return c.printAlbums(albums)
+	case *ls != "":
+		nodes, err := fs.ListAlbumFiles(c.ctx, *ls)
+		if err != nil {
+			return err
+		}
+		return c.print(nodes, func(w io.Writer) {
+			for _, node := range nodes {
+				fmt.Fprintln(w, node.Name)
+			}
+		})
+	case *add != "" || *remove != "":
+		if flags.NArg() == 0 {
+			return usageError("album")
+		}
+		nodes, err := c.albumNodes(flags.Args())
+		if err != nil {
+			return err
+		}
+		if *add != "" {
+			return fs.AddAlbumFiles(c.ctx, *add, nodes)
+		}
+		return fs.RemoveAlbumFiles(c.ctx, *remove, nodes)
+	case *del != "":
+		return fs.DeleteAlbum(c.ctx, *del)
+	case flags.NArg() != 1:
+		return usageError("album")
+	}
+
+	var a *drive.Album
+	if *rename != "" {
+		a, err = fs.RenameAlbum(c.ctx, *rename, flags.Arg(0), *description)
+	} else {
+		a, err = fs.CreateAlbum(c.ctx, flags.Arg(0), *description)
+	}
+	if err != nil {
+		return err
+	}
+	return c.printAlbums([]drive.Album{*a})
+}
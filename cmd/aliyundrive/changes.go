@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+)
+
+func init() {
+	register("changes", command{
+		usage: "[-watch interval] [cursor]",
+		help:  "list the changes since a cursor, from now without one",
+		run:   changes,
+	})
+}
+
+type changeEntry struct {
+	Type   string `json:"type"`
+	NodeId string `json:"file_id"`
+	Name   string `json:"name,omitempty"`
+}
+
+type changeBatch struct {
+	Changes []changeEntry `json:"changes"`
+	Cursor  string        `json:"cursor"`
+}
+
+func changes(c *cli, args []string) error {
+	flags := flag.NewFlagSet("changes", flag.ContinueOnError)
+	watch := flags.Duration("watch", 0, "keep polling at this interval")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return usageError("changes")
+	}
+
+	feed := drive.NewChangeFeed(c.fs.Fs(), flags.Arg(0))
+	for {
+		batch := changeBatch{Changes: []changeEntry{}}
+		for more := true; more; more = feed.Next() {
+			changes, err := feed.Changes(c.ctx)
+			if err != nil {
+				return err
+			}
+			for _, change := range changes {
+				entry := changeEntry{Type: change.Type.String(), NodeId: change.NodeId}
+				if change.Node != nil {
+					entry.Name = displayName(change.Node)
+				}
+				batch.Changes = append(batch.Changes, entry)
+			}
+		}
+		batch.Cursor = feed.Cursor()
+
+		if err := c.print(batch, func(w io.Writer) {
+			for _, entry := range batch.Changes {
+				fmt.Fprintf(w, "%-7s %s %s\n", entry.Type, entry.NodeId, entry.Name)
+			}
+			fmt.Fprintf(w, "cursor: %s\n", batch.Cursor)
+		}); err != nil {
+			return err
+		}
+
+		if *watch <= 0 {
+			return nil
+		}
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(*watch):
+		}
+	}
+}
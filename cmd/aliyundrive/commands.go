@@ -0,0 +1,643 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/config"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+func init() {
+	register("login", command{usage: "<refresh token>", help: "save the refresh token to the config", run: login, noLogin: true})
+	register("about", command{help: "show the used and total space", run: about})
+	register("drives", command{help: "list the drives of the user", run: drives})
+	register("ls", command{usage: "[path]", help: "list a folder", run: ls})
+	register("tree", command{usage: "[path]", help: "list a folder recursively", run: tree})
+	register("stat", command{usage: "<path>", help: "show the metadata of a file or folder", run: stat})
+	register("mkdir", command{usage: "<path>...", help: "create folders along with missing parents", run: mkdir})
+	register("mv", command{usage: "<src> <dst>", help: "move or rename a file or folder", run: mv})
+	register("cp", command{usage: "<src> <dst>", help: "copy a file or folder on the server side", run: cp})
+	register("rm", command{usage: "<path>...", help: "move files or folders to the recycle bin", run: rm})
+	register("search", command{usage: "<name>", help: "search files by name", run: search})
+	register("share", command{usage: "[-password p] [-expires d] <path>... | -list [-sort s] [-desc] [-expired] [-all] [-file path] | -update <id> [-password p] [-expires d] [-description s] | -cancel <id> | -cleanup [-orphaned] [-dry-run]", help: "create, list, update or cancel share links", run: share})
+	register("save", command{usage: "[-password p] [-mode auto_rename|refuse|overwrite] <share url> <dst> [path]...", help: "copy the files of a share link to the drive", run: save})
+}
+
+func formatSize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
+
+func formatTime(node *drive.Node) string {
+	t := node.ModTime()
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(timeLayout)
+}
+
+func displayName(node *drive.Node) string {
+	if node.IsDirectory() {
+		return node.Name + "/"
+	}
+	return node.Name
+}
+
+func login(c *cli, args []string) error {
+	if len(args) != 1 {
+		return usageError("login")
+	}
+
+	conf, err := config.Load(c.configPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		conf = &drive.Config{}
+	}
+
+	if conf.DeviceId == "" {
+		if conf.DeviceId, err = config.NewDeviceId(); err != nil {
+			return err
+		}
+	}
+
+	// the refresh token is rotated by the first request, the given one can't be used again
+	refreshToken := args[0]
+	save := func() error {
+		return config.Update(c.configPath, map[string]interface{}{"refresh_token": refreshToken, "device_id": conf.DeviceId})
+	}
+	conf.RefreshToken = refreshToken
+	conf.HttpClient = c.httpClient
+	conf.OnRefreshToken = func(token string) {
+		refreshToken = token
+		if err := save(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save refresh token: %+v\n", err)
+		}
+	}
+
+	fs, err := drive.NewFs(c.ctx, conf)
+	if err != nil {
+		return errors.Wrap(err, "failed to log in")
+	}
+	if err := save(); err != nil {
+		return err
+	}
+
+	c.fs = drive.NewPathFs(fs, 0)
+	return about(c, nil)
+}
+
+func about(c *cli, args []string) error {
+	if len(args) != 0 {
+		return usageError("about")
+	}
+
+	info, err := c.fs.Fs().About(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.print(info, func(w io.Writer) {
+		fmt.Fprintf(w, "used %s of %s\n", formatSize(info.Used), formatSize(info.Total))
+	})
+}
+
+func drives(c *cli, args []string) error {
+	if len(args) != 0 {
+		return usageError("drives")
+	}
+
+	d, err := c.drive()
+	if err != nil {
+		return err
+	}
+
+	items, err := d.ListDrives(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.print(items, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, d := range items {
+			fmt.Fprintf(tw, "%s\t%s\t%s\tused %s of %s\n", d.DriveId, d.Name, d.Category, formatSize(d.UsedSize), formatSize(d.TotalSize))
+		}
+		tw.Flush()
+	})
+}
+
+func ls(c *cli, args []string) error {
+	if len(args) > 1 {
+		return usageError("ls")
+	}
+
+	p := "/"
+	if len(args) == 1 {
+		p = remotePath(args[0])
+	}
+
+	node, err := c.fs.Stat(c.ctx, p)
+	if err != nil {
+		return err
+	}
+
+	nodes := []drive.Node{*node}
+	if node.IsDirectory() {
+		if nodes, err = c.fs.ReadDir(c.ctx, p); err != nil {
+			return err
+		}
+	}
+
+	return c.print(nodes, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for i := range nodes {
+			node := &nodes[i]
+			size := "-"
+			if !node.IsDirectory() {
+				size = formatSize(node.Size)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t %s\n", size, formatTime(node), displayName(node))
+		}
+		tw.Flush()
+	})
+}
+
+type treeNode struct {
+	drive.Node
+	Children []treeNode `json:"children,omitempty"`
+}
+
+func (c *cli) walk(p string, node *drive.Node) (treeNode, error) {
+	t := treeNode{Node: *node}
+	if !node.IsDirectory() {
+		return t, nil
+	}
+
+	nodes, err := c.fs.ReadDir(c.ctx, p)
+	if err != nil {
+		return t, err
+	}
+
+	t.Children = make([]treeNode, 0, len(nodes))
+	for i := range nodes {
+		child, err := c.walk(path.Join(p, nodes[i].Name), &nodes[i])
+		if err != nil {
+			return t, err
+		}
+		t.Children = append(t.Children, child)
+	}
+	return t, nil
+}
+
+func printTree(w io.Writer, children []treeNode, indent string) {
+	for i := range children {
+		branch, next := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Fprintln(w, indent+branch+displayName(&children[i].Node))
+		printTree(w, children[i].Children, indent+next)
+	}
+}
+
+func tree(c *cli, args []string) error {
+	if len(args) > 1 {
+		return usageError("tree")
+	}
+
+	p := "/"
+	if len(args) == 1 {
+		p = remotePath(args[0])
+	}
+
+	node, err := c.fs.Stat(c.ctx, p)
+	if err != nil {
+		return err
+	}
+
+	t, err := c.walk(p, node)
+	if err != nil {
+		return err
+	}
+
+	return c.print(t, func(w io.Writer) {
+		fmt.Fprintln(w, p)
+		printTree(w, t.Children, "")
+	})
+}
+
+func stat(c *cli, args []string) error {
+	if len(args) != 1 {
+		return usageError("stat")
+	}
+
+	node, err := c.fs.Stat(c.ctx, remotePath(args[0]))
+	if err != nil {
+		return err
+	}
+
+	return c.print(node, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(tw, "name:\t%s\n", node.Name)
+		fmt.Fprintf(tw, "type:\t%s\n", node.Type)
+		fmt.Fprintf(tw, "id:\t%s\n", node.NodeId)
+		fmt.Fprintf(tw, "parent id:\t%s\n", node.ParentId)
+		if !node.IsDirectory() {
+			fmt.Fprintf(tw, "size:\t%d (%s)\n", node.Size, formatSize(node.Size))
+			fmt.Fprintf(tw, "sha1:\t%s\n", node.Hash)
+			fmt.Fprintf(tw, "mime type:\t%s (%s)\n", node.MimeType, node.Category)
+		}
+		if !node.Created.IsZero() {
+			fmt.Fprintf(tw, "created:\t%s\n", node.Created.Local().Format(timeLayout))
+		}
+		if updated, err := node.GetTime(); err == nil {
+			fmt.Fprintf(tw, "updated:\t%s\n", updated.Local().Format(timeLayout))
+		}
+		if !node.LocalModified.IsZero() {
+			fmt.Fprintf(tw, "local modified:\t%s\n", node.LocalModified.Local().Format(timeLayout))
+		}
+		if node.Starred {
+			fmt.Fprintf(tw, "starred:\ttrue\n")
+		}
+		if node.Hidden {
+			fmt.Fprintf(tw, "hidden:\ttrue\n")
+		}
+		if node.Description != "" {
+			fmt.Fprintf(tw, "description:\t%s\n", node.Description)
+		}
+		if len(node.Labels) > 0 {
+			fmt.Fprintf(tw, "labels:\t%s\n", strings.Join(node.Labels, ", "))
+		}
+		for _, media := range []*drive.MediaMetadata{node.ImageMedia, node.VideoMedia} {
+			if media == nil {
+				continue
+			}
+			fmt.Fprintf(tw, "dimensions:\t%dx%d\n", media.Width, media.Height)
+			if media.Duration > 0 {
+				fmt.Fprintf(tw, "duration:\t%s\n", media.Duration)
+			}
+			if !media.Taken.IsZero() {
+				fmt.Fprintf(tw, "taken:\t%s\n", media.Taken.Format(timeLayout))
+			}
+			if media.Location != nil {
+				fmt.Fprintf(tw, "location:\t%g,%g\n", media.Location.Latitude, media.Location.Longitude)
+			}
+		}
+		tw.Flush()
+	})
+}
+
+func mkdir(c *cli, args []string) error {
+	if len(args) == 0 {
+		return usageError("mkdir")
+	}
+
+	var nodes []drive.Node
+	for _, arg := range args {
+		node, err := c.fs.MkdirAll(c.ctx, remotePath(arg))
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, *node)
+	}
+
+	return c.print(nodes, func(io.Writer) {})
+}
+
+// destination returns dst, or dst/<name of src> if dst is an existing folder, like mv and cp do.
+func (c *cli) destination(src string, dst string) (string, error) {
+	node, err := c.fs.Stat(c.ctx, dst)
+	switch {
+	case err == nil && node.IsDirectory():
+		return path.Join(dst, path.Base(src)), nil
+	case err == nil:
+		return "", errors.Wrapf(drive.ErrorAlreadyExisted, `"%s"`, dst)
+	case errors.Is(err, drive.ErrorNotFound):
+		return dst, nil
+	}
+	return "", err
+}
+
+func mv(c *cli, args []string) error {
+	if len(args) != 2 {
+		return usageError("mv")
+	}
+
+	src := remotePath(args[0])
+	dst, err := c.destination(src, remotePath(args[1]))
+	if err != nil {
+		return err
+	}
+
+	if err := c.fs.Rename(c.ctx, src, dst); err != nil {
+		return err
+	}
+
+	node, err := c.fs.Stat(c.ctx, dst)
+	if err != nil {
+		return err
+	}
+	return c.print(node, func(io.Writer) {})
+}
+
+func cp(c *cli, args []string) error {
+	if len(args) != 2 {
+		return usageError("cp")
+	}
+
+	src := remotePath(args[0])
+	dst, err := c.destination(src, remotePath(args[1]))
+	if err != nil {
+		return err
+	}
+
+	node, err := c.fs.Copy(c.ctx, src, dst)
+	if err != nil {
+		return err
+	}
+	return c.print(node, func(io.Writer) {})
+}
+
+func rm(c *cli, args []string) error {
+	if len(args) == 0 {
+		return usageError("rm")
+	}
+
+	for _, arg := range args {
+		if remotePath(arg) == "/" {
+			return errors.New("refusing to remove the root folder")
+		}
+
+		if err := c.fs.Remove(c.ctx, remotePath(arg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func search(c *cli, args []string) error {
+	if len(args) != 1 {
+		return usageError("search")
+	}
+
+	nodes, err := c.fs.Fs().Search(c.ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(nodes, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for i := range nodes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", nodes[i].NodeId, formatTime(&nodes[i]), displayName(&nodes[i]))
+		}
+		tw.Flush()
+	})
+}
+
+type shareLink struct {
+	ShareId    string `json:"share_id"`
+	Url        string `json:"url"`
+	Password   string `json:"password,omitempty"`
+	Expiration string `json:"expiration"`
+}
+
+type cleanupResult struct {
+	ShareId string `json:"share_id"`
+	Name    string `json:"name"`
+	Reason  string `json:"reason"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newShareLink(link *drive.ShareLink) shareLink {
+	expiration := "never"
+	if !link.Expiration.IsZero() {
+		expiration = link.Expiration.Local().Format(time.RFC3339)
+	}
+	return shareLink{ShareId: link.ShareId, Url: link.Url, Password: link.Password, Expiration: expiration}
+}
+
+func share(c *cli, args []string) error {
+	flags := flag.NewFlagSet("share", flag.ContinueOnError)
+	password := flags.String("password", "", "password of the share link")
+	expires := flags.Duration("expires", 7*24*time.Hour, "how long the share link is valid, 0 for ever")
+	description := flags.String("description", "", "description of the share link, with -update")
+	list := flags.Bool("list", false, "list the share links")
+	sortBy := flags.String("sort", "share_name", "sort the list by share_name, created_at, updated_at or expiration")
+	desc := flags.Bool("desc", false, "sort the list in descending order")
+	expired := flags.Bool("expired", false, "only list the expired share links")
+	all := flags.Bool("all", false, "list the cancelled share links too")
+	file := flags.String("file", "", "only list the share links of this path")
+	update := flags.String("update", "", "update the share link with this id")
+	cancel := flags.String("cancel", "", "cancel the share link with this id")
+	cleanup := flags.Bool("cleanup", false, "cancel the expired share links")
+	orphaned := flags.Bool("orphaned", false, "cancel the share links whose files were deleted too, with -cleanup")
+	dryRun := flags.Bool("dry-run", false, "only print the share links -cleanup would cancel")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fs, err := c.drive()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *list:
+		opts := drive.ShareListOptions{OrderBy: *sortBy, Descending: *desc, OnlyExpired: *expired, IncludeCancelled: *all}
+		if *file != "" {
+			node, err := c.fs.Stat(c.ctx, remotePath(*file))
+			if err != nil {
+				return err
+			}
+			opts.FileId = node.NodeId
+		}
+		items, err := fs.ListShareLinks(c.ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		links := make([]shareLink, len(items))
+		for i := range items {
+			links[i] = newShareLink(&items[i])
+		}
+		return c.print(links, func(w io.Writer) {
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			for _, link := range links {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", link.ShareId, link.Url, link.Password, link.Expiration)
+			}
+			tw.Flush()
+		})
+	case *update != "":
+		// only the flags given on the command line are changed
+		var changes drive.ShareLinkUpdate
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "password":
+				changes.Password = password
+			case "description":
+				changes.Description = description
+			case "expires":
+				var expiration time.Time
+				if *expires != 0 {
+					expiration = time.Now().Add(*expires)
+				}
+				changes.Expiration = &expiration
+			}
+		})
+		updated, err := fs.UpdateShareLink(c.ctx, *update, changes)
+		if err != nil {
+			return err
+		}
+		link := newShareLink(updated)
+		return c.print(link, func(w io.Writer) {
+			fmt.Fprintln(w, link.Url)
+			fmt.Fprintln(w, "expires:", link.Expiration)
+		})
+	case *cancel != "":
+		return fs.CancelShareLink(c.ctx, *cancel)
+	case *cleanup:
+		results, err := fs.CleanupShareLinks(c.ctx, drive.ShareCleanupOptions{Expired: true, Orphaned: *orphaned, DryRun: *dryRun})
+		if err != nil {
+			return err
+		}
+
+		cancelled := make([]cleanupResult, len(results))
+		for i, res := range results {
+			cancelled[i] = cleanupResult{ShareId: res.Link.ShareId, Name: res.Link.Name, Reason: res.Reason}
+			if res.Err != nil {
+				cancelled[i].Error = res.Err.Error()
+			}
+		}
+		return c.print(cancelled, func(w io.Writer) {
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			for _, res := range cancelled {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.ShareId, res.Name, res.Reason, res.Error)
+			}
+			tw.Flush()
+		})
+	case flags.NArg() == 0:
+		return usageError("share")
+	}
+
+	var nodes []drive.Node
+	for _, arg := range flags.Args() {
+		node, err := c.fs.Stat(c.ctx, remotePath(arg))
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, *node)
+	}
+
+	created, err := fs.CreateShareLink(c.ctx, nodes, *password, int64(expires.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	link := newShareLink(created)
+	return c.print(link, func(w io.Writer) {
+		fmt.Fprintln(w, link.Url)
+		if link.Password != "" {
+			fmt.Fprintln(w, "password:", link.Password)
+		}
+		fmt.Fprintln(w, "expires:", link.Expiration)
+	})
+}
+
+type saveResult struct {
+	Name   string `json:"name"`
+	NodeId string `json:"file_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func save(c *cli, args []string) error {
+	flags := flag.NewFlagSet("save", flag.ContinueOnError)
+	password := flags.String("password", "", "password of the share link")
+	mode := flags.String("mode", drive.CheckNameAutoRename, "what to do with existing files: auto_rename, refuse or overwrite")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return usageError("save")
+	}
+
+	d, err := c.drive()
+	if err != nil {
+		return err
+	}
+
+	share, err := d.OpenShare(c.ctx, drive.ShareIdFromUrl(flags.Arg(0)), *password)
+	if err != nil {
+		return err
+	}
+	dst, err := c.fs.MkdirAll(c.ctx, remotePath(flags.Arg(1)))
+	if err != nil {
+		return err
+	}
+
+	// the whole share is saved without paths
+	var nodes []drive.Node
+	if flags.NArg() == 2 {
+		root, err := share.GetByPath(c.ctx, "/", drive.FolderKind)
+		if err != nil {
+			return err
+		}
+		if nodes, err = share.ListAll(c.ctx, root.NodeId); err != nil {
+			return err
+		}
+	}
+	for _, arg := range flags.Args()[2:] {
+		node, err := share.GetByPath(c.ctx, remotePath(arg), drive.AnyKind)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, *node)
+	}
+
+	results, err := share.Save(c.ctx, nodes, dst.NodeId, drive.SaveOptions{CheckNameMode: *mode})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	saved := make([]saveResult, len(results))
+	for i, result := range results {
+		saved[i] = saveResult{Name: result.Node.Name, NodeId: result.NodeId}
+		if result.Err != nil {
+			saved[i].Error = result.Err.Error()
+			failed++
+		}
+	}
+	err = c.print(saved, func(w io.Writer) {
+		for _, result := range saved {
+			if result.Error != "" {
+				fmt.Fprintf(w, "failed %s: %s\n", result.Name, result.Error)
+			} else {
+				fmt.Fprintf(w, "saved %s\n", result.Name)
+			}
+		}
+	})
+	if err == nil && failed > 0 {
+		err = errors.Errorf("%d of %d files not saved", failed, len(results))
+	}
+	return err
+}
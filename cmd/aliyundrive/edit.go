@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	register("edit", command{
+		usage: "[-starred[=false]] [-hidden[=false]] [-description s] [-labels l1,l2] [-meta s] <path>...",
+		help:  "star, describe, label or hide files and folders, only the given fields are changed",
+		run:   edit,
+	})
+	register("starred", command{help: "list the starred files and folders", run: starred})
+}
+
+func edit(c *cli, args []string) error {
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	isStarred := flags.Bool("starred", false, "star the files, -starred=false to unstar them")
+	hidden := flags.Bool("hidden", false, "hide the files from the official clients, -hidden=false to show them")
+	description := flags.String("description", "", "description of the files, empty to remove it")
+	labels := flags.String("labels", "", "comma separated labels replacing the ones of the files, empty to remove them")
+	meta := flags.String("meta", "", "meta of the files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 || flags.NFlag() == 0 {
+		return usageError("edit")
+	}
+	updater, ok := c.fs.Fs().(drive.NodeUpdater)
+	if !ok {
+		return errors.Wrap(drive.ErrorNotSupported, "files can't be edited with -share")
+	}
+
+	// only the flags given on the command line are changed
+	var update drive.NodeUpdate
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "starred":
+			update.Starred = isStarred
+		case "hidden":
+			update.Hidden = hidden
+		case "description":
+			update.Description = description
+		case "labels":
+			list := []string{}
+			for _, label := range strings.Split(*labels, ",") {
+				if label = strings.TrimSpace(label); label != "" {
+					list = append(list, label)
+				}
+			}
+			update.Labels = &list
+		case "meta":
+			update.Meta = meta
+		}
+	})
+
+	var nodes []drive.Node
+	for _, arg := range flags.Args() {
+		p := remotePath(arg)
+		node, err := c.fs.Stat(c.ctx, p)
+		if err != nil {
+			return err
+		}
+		updated, err := updater.UpdateNode(c.ctx, node.NodeId, update)
+		if err != nil {
+			return err
+		}
+		c.fs.Invalidate(p)
+		nodes = append(nodes, *updated)
+	}
+	return c.print(nodes, func(w io.Writer) {
+		for i := range nodes {
+			fmt.Fprintln(w, displayName(&nodes[i]))
+		}
+	})
+}
+
+func starred(c *cli, args []string) error {
+	if len(args) != 0 {
+		return usageError("starred")
+	}
+
+	lister, ok := c.fs.Fs().(drive.StarredLister)
+	if !ok {
+		return errors.Wrap(drive.ErrorNotSupported, "no starred files with -share")
+	}
+
+	nodes, err := lister.ListStarred(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.print(nodes, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for i := range nodes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", nodes[i].NodeId, formatTime(&nodes[i]), displayName(&nodes[i]), nodes[i].Description)
+		}
+		tw.Flush()
+	})
+}
@@ -0,0 +1,205 @@
+// Command aliyundrive manages an aliyun drive from the command line.
+//
+//	aliyundrive login <refresh token>
+//	aliyundrive ls /videos
+//	aliyundrive upload ./photos /backup
+//	aliyundrive -json stat /backup/photos
+//
+// The config is read from .config (see .config_default), rotated refresh tokens are saved back to it.
+// With -json, results are printed as JSON for scripting. With -crypt /folder, the files of
+// the folder are encrypted on the client with the passphrase in ALIYUNDRIVE_CRYPT_PASSWORD.
+// With -share <url>, the commands read the files of someone else's share link. With -drive resource,
+// the commands work on another drive of the user, see the drives command.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/K265/aliyundrive-go/internal/config"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/crypt"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+type command struct {
+	usage string
+	help  string
+	run   func(c *cli, args []string) error
+	// noLogin commands run without a Fs
+	noLogin bool
+}
+
+var commands = map[string]command{}
+
+func register(name string, cmd command) {
+	commands[name] = cmd
+}
+
+type cli struct {
+	ctx        context.Context
+	configPath string
+	json       bool
+	// cryptRoot is the folder encrypted with the passphrase in ALIYUNDRIVE_CRYPT_PASSWORD
+	cryptRoot string
+	// driveId is the drive id or name of the drive to work on, empty for the default drive
+	driveId string
+	// share and sharePassword select a share link to work on instead of the drive
+	share         string
+	sharePassword string
+	out           io.Writer
+	httpClient    *http.Client
+	fs            *drive.PathFs
+}
+
+func (c *cli) login() error {
+	conf, err := config.Load(c.configPath)
+	if err != nil {
+		return errors.Wrap(err, `run "aliyundrive login <refresh token>" first`)
+	}
+
+	conf.HttpClient = c.httpClient
+	fs, err := drive.NewFs(c.ctx, conf)
+	if err != nil {
+		return errors.Wrap(err, "failed to log in")
+	}
+
+	d := fs.(*drive.Drive)
+	if c.driveId != "" {
+		if d, err = d.OpenDrive(c.ctx, c.driveId); err != nil {
+			return err
+		}
+		fs = d
+	}
+
+	if c.share != "" {
+		fs, err = d.OpenShare(c.ctx, drive.ShareIdFromUrl(c.share), c.sharePassword)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.cryptRoot != "" {
+		fs, err = cryptFs(c.ctx, fs, c.cryptRoot)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.fs = drive.NewPathFs(fs, 0)
+	return nil
+}
+
+// drive returns the drive of the commands on albums, share links and drives, which don't work
+// on the files of a share link or encrypted files.
+func (c *cli) drive() (*drive.Drive, error) {
+	d, ok := c.fs.Fs().(*drive.Drive)
+	if !ok {
+		return nil, errors.Wrap(drive.ErrorNotSupported, "not available with -share or -crypt")
+	}
+	return d, nil
+}
+
+// cryptFs encrypts the files under root, the passphrase is read from the environment.
+func cryptFs(ctx context.Context, fs drive.Fs, root string) (drive.Fs, error) {
+	passphrase := os.Getenv("ALIYUNDRIVE_CRYPT_PASSWORD")
+	if passphrase == "" {
+		return nil, errors.New("ALIYUNDRIVE_CRYPT_PASSWORD is required with -crypt")
+	}
+	return crypt.NewFs(ctx, fs, crypt.Options{Root: root, Passphrase: passphrase})
+}
+
+// print writes v as JSON with -json, else calls text.
+func (c *cli) print(v interface{}, text func(w io.Writer)) error {
+	if c.json {
+		e := json.NewEncoder(c.out)
+		e.SetIndent("", "  ")
+		return errors.WithStack(e.Encode(v))
+	}
+
+	text(c.out)
+	return nil
+}
+
+func usage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: aliyundrive [flags] <command> [arguments]")
+	fmt.Fprintln(w, "\nflags:")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+	fmt.Fprintln(w, "\ncommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-40s %s\n", name+" "+commands[name].usage, commands[name].help)
+	}
+}
+
+// run executes the command line args (without the program name).
+func run(ctx context.Context, args []string, out io.Writer, httpClient *http.Client) error {
+	c := &cli{ctx: ctx, out: out, httpClient: httpClient}
+	flags := flag.NewFlagSet("aliyundrive", flag.ContinueOnError)
+	flags.StringVar(&c.configPath, "config", config.DefaultPath, "path of the config file")
+	flags.BoolVar(&c.json, "json", false, "print results as JSON")
+	flags.StringVar(&c.driveId, "drive", "", "work on this drive: a drive id, default, resource, backup or album")
+	flags.StringVar(&c.share, "share", "", "work on the files of this share link (url or id), read-only")
+	flags.StringVar(&c.sharePassword, "share-password", "", "password of the -share link")
+	flags.StringVar(&c.cryptRoot, "crypt", "", "work on the files of this folder, encrypted with the passphrase in ALIYUNDRIVE_CRYPT_PASSWORD")
+	flags.Usage = func() { usage(os.Stderr, flags) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		flags.Usage()
+		return errors.Errorf("unknown command %q", name)
+	}
+
+	if !cmd.noLogin {
+		if err := c.login(); err != nil {
+			return err
+		}
+	}
+
+	return cmd.run(c, flags.Args()[1:])
+}
+
+// usageError reports wrong arguments of a command.
+func usageError(name string) error {
+	return errors.Errorf("usage: aliyundrive %s %s", name, commands[name].usage)
+}
+
+// remotePath makes p absolute, remote paths are relative to the root of the drive.
+func remotePath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdout, nil)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "aliyundrive: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCli runs commands against a fake server, logged in with a config in a temporary folder.
+type testCli struct {
+	t          *testing.T
+	srv        *drivetest.Server
+	dir        string
+	configPath string
+}
+
+func newTestCli(t *testing.T) *testCli {
+	srv := drivetest.NewServer()
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	c := &testCli{t: t, srv: srv, dir: dir, configPath: filepath.Join(dir, ".config")}
+	c.do("login", "token")
+	return c
+}
+
+func (c *testCli) run(args ...string) (string, error) {
+	var out bytes.Buffer
+	err := c.runContext(context.Background(), &out, args...)
+	return out.String(), err
+}
+
+// runContext runs a command until it returns or ctx is done, for the commands which keep running.
+func (c *testCli) runContext(ctx context.Context, out io.Writer, args ...string) error {
+	return run(ctx, append([]string{"-config", c.configPath}, args...), out, c.srv.Client())
+}
+
+// writerFunc is the output of a command which keeps running.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// do runs a command which must succeed and returns its output.
+func (c *testCli) do(args ...string) string {
+	out, err := c.run(args...)
+	require.NoError(c.t, err, args)
+	return out
+}
+
+// doJSON runs a command with -json and decodes its output into v.
+func (c *testCli) doJSON(v interface{}, args ...string) {
+	require.NoError(c.t, json.Unmarshal([]byte(c.do(append([]string{"-json"}, args...)...)), v), args)
+}
+
+// writeFile writes a local file under the temporary folder and returns its path.
+func (c *testCli) writeFile(name string, data string) string {
+	p := filepath.Join(c.dir, name)
+	require.NoError(c.t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(c.t, ioutil.WriteFile(p, []byte(data), 0644))
+	return p
+}
+
+func (c *testCli) readFile(name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+	require.NoError(c.t, err)
+	return string(b)
+}
+
+func TestLogin(t *testing.T) {
+	c := newTestCli(t)
+	var conf map[string]string
+	b, err := ioutil.ReadFile(c.configPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &conf))
+	assert.NotEqual(t, "token", conf["refresh_token"], "the rotated token is saved")
+	assert.Len(t, conf["device_id"], 64)
+}
+
+func TestFiles(t *testing.T) {
+	c := newTestCli(t)
+	c.writeFile("local/a.txt", "hello")
+	c.writeFile("local/sub/b.txt", "world")
+
+	c.do("mkdir", "/backup")
+	c.do("upload", filepath.Join(c.dir, "local"), "/backup")
+	assert.Equal(t, []byte("hello"), c.srv.ReadAll("/backup/local/a.txt"))
+	assert.Equal(t, []byte("world"), c.srv.ReadAll("/backup/local/sub/b.txt"))
+
+	var nodes []drive.Node
+	c.doJSON(&nodes, "ls", "/backup/local")
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "a.txt", nodes[0].Name)
+	assert.Equal(t, "/backup\n└── local/\n    ├── a.txt\n    └── sub/\n        └── b.txt\n", c.do("tree", "backup"))
+
+	c.do("cp", "/backup/local", "/copy")
+	c.do("mv", "/copy/a.txt", "/backup")
+	assert.Equal(t, []byte("hello"), c.srv.ReadAll("/backup/a.txt"))
+	assert.Nil(t, c.srv.Lookup("/copy/a.txt"))
+
+	var node drive.Node
+	c.doJSON(&node, "stat", "/backup/a.txt")
+	assert.Equal(t, int64(5), node.Size)
+
+	c.do("download", "/copy", c.dir)
+	assert.Equal(t, "world", c.readFile("copy/sub/b.txt"))
+
+	c.do("rm", "/copy")
+	assert.Nil(t, c.srv.Lookup("/copy"))
+	_, err := c.run("rm", "/")
+	assert.Error(t, err)
+}
+
+func TestShare(t *testing.T) {
+	c := newTestCli(t)
+	c.srv.Put(c.srv.Mkdir(c.srv.Mkdir(drivetest.RootId, "copy"), "sub"), "b.txt", []byte("world"))
+
+	// the files of a share link are read like the drive
+	var link shareLink
+	c.doJSON(&link, "share", "-password", "1234", "/copy")
+	assert.Equal(t, "/\n└── copy/\n    └── sub/\n        └── b.txt\n", c.do("-share", link.Url, "-share-password", "1234", "tree", "/"))
+	shared := filepath.Join(c.dir, "shared")
+	require.NoError(t, os.MkdirAll(shared, 0755))
+	c.do("-share", link.ShareId, "-share-password", "1234", "download", "/copy/sub/b.txt", shared)
+	assert.Equal(t, "world", c.readFile("shared/b.txt"))
+	_, err := c.run("-share", link.ShareId, "-share-password", "1234", "album", "-list")
+	assert.ErrorIs(t, err, drive.ErrorNotSupported)
+
+	assert.Equal(t, "saved copy\n", c.do("save", "-password", "1234", link.Url, "/saved"))
+	assert.Equal(t, []byte("world"), c.srv.ReadAll("/saved/copy/sub/b.txt"))
+	assert.Equal(t, "saved b.txt\n", c.do("save", "-password", "1234", link.Url, "/saved", "/copy/sub/b.txt"))
+	assert.Equal(t, []byte("world"), c.srv.ReadAll("/saved/b.txt"))
+
+	c.doJSON(&link, "share", "-update", link.ShareId, "-expires", "-1h")
+	var links []shareLink
+	c.doJSON(&links, "share", "-list", "-expired", "-file", "/copy")
+	require.Len(t, links, 1)
+	assert.Equal(t, link.ShareId, links[0].ShareId)
+	assert.Equal(t, link.ShareId+"  copy  expired  \n", c.do("share", "-cleanup"))
+	assert.True(t, c.srv.Share(link.ShareId).Cancelled)
+}
+
+func TestDrives(t *testing.T) {
+	c := newTestCli(t)
+	var driveList []drive.DriveInfo
+	c.doJSON(&driveList, "drives")
+	assert.Len(t, driveList, 3)
+	c.do("-drive", "resource", "mkdir", "/movies")
+	assert.Equal(t, "/\n└── movies/\n", c.do("-drive", drivetest.ResourceDriveId, "tree", "/"))
+	assert.Nil(t, c.srv.Lookup("/movies"))
+}
+
+func TestAlbum(t *testing.T) {
+	c := newTestCli(t)
+	c.srv.Put(c.srv.Mkdir(drivetest.RootId, "backup"), "a.txt", []byte("hello"))
+	c.srv.Put(c.srv.Mkdir(drivetest.RootId, "saved"), "b.txt", []byte("world"))
+
+	var albums []albumInfo
+	c.doJSON(&albums, "album", "-description", "backups", "photos")
+	require.Len(t, albums, 1)
+	albumId := albums[0].AlbumId
+	c.do("album", "-add", albumId, "/backup/a.txt", "/saved/b.txt")
+	assert.Equal(t, "b.txt\na.txt\n", c.do("album", "-ls", albumId))
+	c.do("album", "-remove", albumId, "/saved/b.txt")
+	c.doJSON(&albums, "album", "-list")
+	require.Len(t, albums, 1)
+	assert.Equal(t, "photos", albums[0].Name)
+	assert.Equal(t, int64(1), albums[0].FileCount)
+	c.do("album", "-delete", albumId)
+	assert.Nil(t, c.srv.Album(albumId))
+}
+
+func TestEdit(t *testing.T) {
+	c := newTestCli(t)
+	c.srv.Put(c.srv.Mkdir(drivetest.RootId, "backup"), "a.txt", []byte("hello"))
+
+	c.do("edit", "-starred", "-description", "keep forever", "-labels", "config, backup", "/backup/a.txt")
+	a := c.srv.Lookup("/backup/a.txt")
+	assert.True(t, a.Starred)
+	assert.Equal(t, "keep forever", a.Description)
+	assert.Equal(t, []string{"config", "backup"}, a.Labels)
+	assert.Contains(t, c.do("starred"), "a.txt")
+	c.do("edit", "-starred=false", "/backup/a.txt")
+	assert.False(t, c.srv.Lookup("/backup/a.txt").Starred)
+	assert.Equal(t, "keep forever", c.srv.Lookup("/backup/a.txt").Description, "only the given fields are changed")
+	assert.Empty(t, c.do("starred"))
+}
+
+func TestRevisions(t *testing.T) {
+	c := newTestCli(t)
+	c.do("mkdir", "/backup")
+	local := c.writeFile("a.txt", "hello")
+	c.do("upload", local, "/backup")
+
+	// uploading again keeps the old content as a revision
+	c.writeFile("a.txt", "hello again")
+	c.do("upload", local, "/backup")
+	assert.Equal(t, []byte("hello again"), c.srv.ReadAll("/backup/a.txt"))
+	var revs []revisionInfo
+	c.doJSON(&revs, "revisions", "/backup/a.txt")
+	require.Len(t, revs, 2)
+	assert.True(t, revs[0].Latest)
+	c.do("revisions", "-get", revs[1].RevisionId, "/backup/a.txt", filepath.Join(c.dir, "old.txt"))
+	assert.Equal(t, "hello", c.readFile("old.txt"))
+	c.do("revisions", "-restore", revs[1].RevisionId, "/backup/a.txt")
+	assert.Equal(t, []byte("hello"), c.srv.ReadAll("/backup/a.txt"))
+	c.do("revisions", "-delete", revs[0].RevisionId, "/backup/a.txt")
+	assert.Len(t, c.srv.Lookup("/backup/a.txt").Revisions, 0)
+}
+
+func TestRapid(t *testing.T) {
+	c := newTestCli(t)
+	dir := c.srv.Mkdir(drivetest.RootId, "src")
+	c.srv.Put(dir, "a.txt", []byte("hello"))
+	c.srv.Put(c.srv.Mkdir(dir, "sub"), "b.txt", []byte("world"))
+
+	list := c.do("rapid-export", "/src")
+	assert.Equal(t, "a.txt|"+c.srv.Lookup("/src/a.txt").Hash()+"|5\nsub/b.txt|"+c.srv.Lookup("/src/sub/b.txt").Hash()+"|5\n", list)
+	listPath := filepath.Join(c.dir, "list.txt")
+	c.do("rapid-export", "src", listPath)
+	assert.Equal(t, list, c.readFile("list.txt"))
+
+	var results []rapidResult
+	c.doJSON(&results, "rapid-import", "-from", "/src", listPath, "/copy")
+	require.Len(t, results, 2)
+	assert.Equal(t, "sub/b.txt", results[1].Path)
+	assert.Equal(t, c.srv.Lookup("/copy/sub/b.txt").FileId, results[1].NodeId)
+	assert.Empty(t, results[1].Error)
+	assert.Equal(t, []byte("hello"), c.srv.ReadAll("/copy/a.txt"))
+
+	// the failed entries are reported before the error
+	c.writeFile("local/a.txt", "hello")
+	out, err := c.run("-json", "rapid-import", "-local", filepath.Join(c.dir, "local"), listPath, "/local")
+	assert.EqualError(t, err, "1 of 2 entries were not rapid uploaded")
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 2)
+	assert.Empty(t, results[0].Error)
+	assert.NotEmpty(t, results[1].Error)
+	assert.Equal(t, []byte("hello"), c.srv.ReadAll("/local/a.txt"))
+	assert.Nil(t, c.srv.Lookup("/local/sub/b.txt"))
+	out, err = c.run("rapid-import", "-local", filepath.Join(c.dir, "local"), listPath, "/local")
+	assert.Error(t, err)
+	assert.Contains(t, out, "a.txt      "+drive.ErrorAlreadyExisted.Error())
+
+	_, err = c.run("rapid-import", listPath)
+	assert.EqualError(t, err, usageError("rapid-import").Error())
+	_, err = c.run("rapid-export")
+	assert.EqualError(t, err, usageError("rapid-export").Error())
+}
+
+func TestSync(t *testing.T) {
+	c := newTestCli(t)
+	c.writeFile("local/a.txt", "hello")
+	c.writeFile("local/sub/b.txt", "world")
+	c.writeFile("local/skip.log", "log")
+	local := filepath.Join(c.dir, "local")
+
+	var ops []syncOp
+	c.doJSON(&ops, "sync", "-dry-run", "-exclude", "*.log", local, "/backup")
+	assert.Equal(t, []syncOp{
+		{Action: "mkdir", Path: ""},
+		{Action: "upload", Path: "a.txt", Size: 5},
+		{Action: "mkdir", Path: "sub"},
+		{Action: "upload", Path: "sub/b.txt", Size: 5},
+	}, ops)
+	assert.Nil(t, c.srv.Lookup("/backup"))
+
+	assert.Equal(t, "mkdir \nupload a.txt\nmkdir sub\nupload sub/b.txt\n", c.do("sync", "-exclude", "*.log", local, "backup"))
+	assert.Equal(t, []byte("world"), c.srv.ReadAll("/backup/sub/b.txt"))
+	assert.Nil(t, c.srv.Lookup("/backup/skip.log"))
+
+	// remote files missing locally are only deleted with -delete
+	c.srv.Put(c.srv.Lookup("/backup").FileId, "old.txt", []byte("old"))
+	c.writeFile("local/a.txt", "hello again")
+	c.doJSON(&ops, "sync", "-include", "*.txt", "-include", "sub", local, "/backup")
+	assert.Equal(t, []syncOp{{Action: "update", Path: "a.txt", Size: 11, Reason: "size changed"}}, ops)
+	assert.Equal(t, []byte("hello again"), c.srv.ReadAll("/backup/a.txt"))
+	assert.Equal(t, "delete old.txt (not found locally)\n", c.do("sync", "-mtime", "-delete", "-exclude", "*.log", local, "/backup"))
+	assert.Nil(t, c.srv.Lookup("/backup/old.txt"))
+	assert.Empty(t, c.do("sync", "-exclude", "*.log", local, "/backup"))
+
+	_, err := c.run("sync", local)
+	assert.EqualError(t, err, usageError("sync").Error())
+}
+
+func TestBisync(t *testing.T) {
+	c := newTestCli(t)
+	c.writeFile("local/a.txt", "hello")
+	c.writeFile("local/b.txt", "world")
+	c.srv.Put(c.srv.Mkdir(drivetest.RootId, "docs"), "r.txt", []byte("remote"))
+	local := filepath.Join(c.dir, "local")
+	state := filepath.Join(c.dir, "state.json")
+
+	c.do("bisync", "-dry-run", "-state", state, local, "/docs")
+	_, err := os.Stat(state)
+	assert.True(t, os.IsNotExist(err), "a dry run doesn't save the state")
+	out := c.do("bisync", "-state", state, local, "/docs")
+	assert.Contains(t, out, "upload a.txt (new locally)\n")
+	assert.Contains(t, out, "download r.txt (new remotely)\n")
+	assert.Equal(t, []byte("world"), c.srv.ReadAll("/docs/b.txt"))
+	assert.Equal(t, "remote", c.readFile("local/r.txt"))
+
+	// deleting most of the files needs -max-delete
+	require.NoError(t, os.Remove(filepath.Join(local, "b.txt")))
+	require.NoError(t, os.Remove(filepath.Join(local, "r.txt")))
+	_, err = c.run("bisync", "-state", state, local, "/docs")
+	assert.ErrorIs(t, err, syncer.ErrorTooManyDeletes)
+	assert.NotNil(t, c.srv.Lookup("/docs/b.txt"))
+	var ops []syncOp
+	c.doJSON(&ops, "bisync", "-state", state, "-max-delete", "100", local, "/docs")
+	assert.Equal(t, []syncOp{
+		{Action: "delete", Path: "b.txt", Size: 5, Reason: "deleted locally"},
+		{Action: "delete", Path: "r.txt", Size: 6, Reason: "deleted locally"},
+	}, ops)
+	assert.Nil(t, c.srv.Lookup("/docs/b.txt"))
+
+	// files changed on both sides are left alone with the manual policy
+	c.writeFile("local/a.txt", "local change")
+	c.do("upload", c.writeFile("other/a.txt", "remote change"), "/docs")
+	var conflicts []syncOp
+	c.doJSON(&conflicts, "bisync", "-state", state, "-policy", "manual", local, "/docs")
+	assert.Equal(t, []syncOp{{Action: "conflict", Path: "a.txt", Reason: "changed on both sides"}}, conflicts)
+	assert.Equal(t, "local change", c.readFile("local/a.txt"))
+	assert.Equal(t, []byte("remote change"), c.srv.ReadAll("/docs/a.txt"))
+
+	_, err = c.run("bisync", "-policy", "oldest", local, "/docs")
+	assert.EqualError(t, err, `unknown conflict policy "oldest"`)
+	_, err = c.run("bisync", local)
+	assert.EqualError(t, err, usageError("bisync").Error())
+}
+
+func TestChanges(t *testing.T) {
+	c := newTestCli(t)
+	var batch changeBatch
+	c.doJSON(&batch, "changes")
+	assert.Empty(t, batch.Changes, "changes start from now without a cursor")
+	require.NotEmpty(t, batch.Cursor)
+	cursor := batch.Cursor
+
+	c.do("mkdir", "/docs")
+	file := c.srv.Put(c.srv.Lookup("/docs").FileId, "a.txt", []byte("hello"))
+	batch = changeBatch{}
+	c.doJSON(&batch, "changes", cursor)
+	assert.Equal(t, []changeEntry{
+		{Type: "create", NodeId: c.srv.Lookup("/docs").FileId, Name: "docs/"},
+		{Type: "create", NodeId: file, Name: "a.txt"},
+	}, batch.Changes)
+	assert.NotEqual(t, cursor, batch.Cursor)
+
+	c.do("rm", "/docs/a.txt")
+	assert.Equal(t, "delete  "+file+" \ncursor: ", c.do("changes", batch.Cursor)[:len(file)+18])
+
+	// with -watch, the changes are printed until the command is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var out bytes.Buffer
+	require.NoError(t, c.runContext(ctx, writerFunc(func(p []byte) (int, error) {
+		cancel()
+		return out.Write(p)
+	}), "-json", "changes", "-watch", "1h", cursor))
+	require.NoError(t, json.Unmarshal(out.Bytes(), &batch))
+	assert.Len(t, batch.Changes, 3)
+
+	_, err := c.run("changes", cursor, cursor)
+	assert.EqualError(t, err, usageError("changes").Error())
+}
+
+func TestWatch(t *testing.T) {
+	c := newTestCli(t)
+	c.writeFile("local/a.txt", "hello")
+	local := filepath.Join(c.dir, "local")
+
+	// the results are printed as JSON lines while the command runs, it is stopped after the second upload
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var results []watchResult
+	err := c.runContext(ctx, writerFunc(func(p []byte) (int, error) {
+		var r watchResult
+		require.NoError(t, json.Unmarshal(p, &r))
+		results = append(results, r)
+		if r.Path == "a.txt" {
+			c.writeFile("local/skip.tmp", "temp")
+			c.writeFile("local/sub/b.txt", "world")
+		} else {
+			cancel()
+		}
+		return len(p), nil
+	}), "-json", "watch", "-poll", "-interval", "10ms", "-debounce", "10ms", "-exclude", "*.tmp", local, "/drop")
+	require.NoError(t, err)
+	require.Equal(t, context.Canceled, ctx.Err(), "stopped after the second upload, not the timeout")
+	require.Len(t, results, 2)
+	assert.Equal(t, watchResult{Path: "a.txt", NodeId: c.srv.Lookup("/drop/a.txt").FileId, Attempt: 1}, results[0])
+	assert.Equal(t, watchResult{Path: "sub/b.txt", NodeId: c.srv.Lookup("/drop/sub/b.txt").FileId, Attempt: 1}, results[1])
+	assert.Nil(t, c.srv.Lookup("/drop/skip.tmp"))
+
+	// a restarted watch only uploads the files changed meanwhile
+	c.writeFile("local/c.txt", "new")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var out bytes.Buffer
+	require.NoError(t, c.runContext(ctx, writerFunc(func(p []byte) (int, error) {
+		cancel()
+		return out.Write(p)
+	}), "watch", "-poll", "-interval", "10ms", "-debounce", "10ms", "-exclude", "*.tmp", local, "/drop"))
+	assert.Equal(t, "uploaded c.txt\n", out.String())
+	assert.Equal(t, []byte("new"), c.srv.ReadAll("/drop/c.txt"))
+
+	_, err = c.run("watch", local)
+	assert.EqualError(t, err, usageError("watch").Error())
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	register("rapid-export", command{usage: "<path> [file]", help: "export a folder as path|sha1|size lines", run: rapidExport})
+	register("rapid-import", command{usage: "[-local dir | -from path] <file> <path>", help: "recreate exported files with rapid upload", run: rapidImport})
+}
+
+func rapidExport(c *cli, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("rapid-export")
+	}
+
+	entries, err := drive.ExportRapidList(c.ctx, c.fs.Fs(), remotePath(args[0]))
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		return drive.WriteRapidList(c.out, entries)
+	}
+
+	f, err := os.Create(args[1])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := drive.WriteRapidList(f, entries); err != nil {
+		f.Close()
+		return err
+	}
+	return errors.WithStack(f.Close())
+}
+
+type rapidResult struct {
+	Path   string `json:"path"`
+	NodeId string `json:"file_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func rapidImport(c *cli, args []string) error {
+	flags := flag.NewFlagSet("rapid-import", flag.ContinueOnError)
+	local := flags.String("local", "", "local folder holding the files, used to compute proof codes")
+	from := flags.String("from", "", "drive folder holding the files, used to compute proof codes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("rapid-import")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	entries, err := drive.ReadRapidList(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	var source drive.ProofSource
+	switch {
+	case *local != "":
+		source = drive.LocalProofSource(*local)
+	case *from != "":
+		source = drive.FsProofSource(c.fs.Fs(), remotePath(*from))
+	}
+
+	results, err := drive.ImportRapidList(c.ctx, c.fs.Fs(), remotePath(flags.Arg(1)), entries, source)
+	report := make([]rapidResult, len(results))
+	failed := 0
+	for i, result := range results {
+		report[i] = rapidResult{Path: result.Entry.Path, NodeId: result.NodeId}
+		if result.Err != nil {
+			report[i].Error = result.Err.Error()
+			failed++
+		}
+	}
+
+	if printErr := c.print(report, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, r := range report {
+			status := "ok"
+			if r.Error != "" {
+				status = r.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", r.Path, status)
+		}
+		tw.Flush()
+	}); printErr != nil {
+		return printErr
+	}
+
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d entries were not rapid uploaded", failed, len(entries))
+	}
+	return nil
+}
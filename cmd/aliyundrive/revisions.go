@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	register("revisions", command{
+		usage: "<path> | -get <id> <path> [local] | -restore <id> <path> | -delete <id> <path>",
+		help:  "list, download, restore or delete the old versions of a file",
+		run:   revisions,
+	})
+}
+
+type revisionInfo struct {
+	RevisionId string `json:"revision_id"`
+	Size       int64  `json:"size"`
+	Created    string `json:"created"`
+	Latest     bool   `json:"latest,omitempty"`
+}
+
+func revisions(c *cli, args []string) error {
+	flags := flag.NewFlagSet("revisions", flag.ContinueOnError)
+	get := flags.String("get", "", "download the revision with this id")
+	restore := flags.String("restore", "", "make the revision with this id the current content")
+	del := flags.String("delete", "", "delete the revision with this id")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 || (flags.NArg() == 2 && *get == "") {
+		return usageError("revisions")
+	}
+
+	p := remotePath(flags.Arg(0))
+	node, err := c.fs.Stat(c.ctx, p)
+	if err != nil {
+		return err
+	}
+	if node.IsDirectory() {
+		return errors.Errorf(`"%s" is a folder`, p)
+	}
+
+	fs, ok := c.fs.Fs().(drive.RevisionFs)
+	if !ok {
+		return errors.Wrap(drive.ErrorNotSupported, "no revisions with -share")
+	}
+
+	switch {
+	case *restore != "":
+		if err := fs.RestoreRevision(c.ctx, node.NodeId, *restore); err != nil {
+			return err
+		}
+		c.fs.Invalidate(p)
+		return nil
+	case *del != "":
+		return fs.DeleteRevision(c.ctx, node.NodeId, *del)
+	}
+
+	list, err := fs.ListRevisions(c.ctx, node.NodeId)
+	if err != nil {
+		return err
+	}
+
+	if *get != "" {
+		var rev *drive.Revision
+		for i := range list {
+			if list[i].RevisionId == *get {
+				rev = &list[i]
+			}
+		}
+		if rev == nil {
+			return errors.Wrapf(drive.ErrorNotFound, `no revision "%s" of "%s"`, *get, p)
+		}
+
+		local := node.Name
+		if flags.NArg() == 2 {
+			local = flags.Arg(1)
+		}
+		if fi, err := os.Stat(local); err == nil && fi.IsDir() {
+			local = filepath.Join(local, node.Name)
+		}
+		if err := c.downloadFile(rev.Node(*node), local); err != nil {
+			return err
+		}
+		t := transfer{Local: local, Remote: p, Size: rev.Size, NodeId: node.NodeId}
+		return c.print(t, func(w io.Writer) {
+			fmt.Fprintf(w, "%s@%s -> %s\n", p, rev.RevisionId, local)
+		})
+	}
+
+	infos := make([]revisionInfo, len(list))
+	for i, rev := range list {
+		infos[i] = revisionInfo{RevisionId: rev.RevisionId, Size: rev.Size, Created: rev.Created.Local().Format(timeLayout), Latest: rev.Latest}
+	}
+	return c.print(infos, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, info := range infos {
+			latest := ""
+			if info.Latest {
+				latest = "latest"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", info.RevisionId, info.Created, formatSize(info.Size), latest)
+		}
+		tw.Flush()
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/syncer"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	register("sync", command{
+		usage: "[-delete] [-dry-run] [-mtime] [-include glob]... [-exclude glob]... <local> <remote>",
+		help:  "upload the missing and changed files of a local folder",
+		run:   syncFolder,
+	})
+	register("bisync", command{
+		usage: "[-policy newest|keep-both|manual] [-state file] [-dry-run] [-max-delete percent] [-include glob]... [-exclude glob]... <local> <remote>",
+		help:  "sync the changes of a local folder and a remote folder both ways",
+		run:   bisyncFolders,
+	})
+}
+
+// stringList is a flag which may be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+type syncOp struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	From   string `json:"from,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func syncFolder(c *cli, args []string) error {
+	var opts syncer.Options
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.BoolVar(&opts.Delete, "delete", false, "move remote files missing locally to the recycle bin")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "only print the plan")
+	mtime := flags.Bool("mtime", false, "compare sizes and modification times instead of sha1")
+	flags.Var((*stringList)(&opts.Include), "include", "only sync the files matching this glob")
+	flags.Var((*stringList)(&opts.Exclude), "exclude", "skip the files and folders matching this glob")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("sync")
+	}
+	if *mtime {
+		opts.Compare = syncer.CompareModTime
+	}
+
+	plan, err := syncer.Push(c.ctx, c.fs.Fs(), flags.Arg(0), remotePath(flags.Arg(1)), opts)
+	return c.printPlan(plan, err)
+}
+
+func bisyncFolders(c *cli, args []string) error {
+	var opts syncer.BisyncOptions
+	flags := flag.NewFlagSet("bisync", flag.ContinueOnError)
+	policy := flags.String("policy", "newest", "resolution of files changed on both sides: newest, keep-both or manual")
+	flags.StringVar(&opts.StatePath, "state", "", "state file, "+syncer.DefaultStateName+" in the local folder by default")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "only print the plan")
+	flags.IntVar(&opts.MaxDelete, "max-delete", syncer.DefaultMaxDelete, "refuse to delete more than this percentage of the synced files on a side, 100 to disable")
+	flags.Var((*stringList)(&opts.Include), "include", "only sync the files matching this glob")
+	flags.Var((*stringList)(&opts.Exclude), "exclude", "skip the files and folders matching this glob")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("bisync")
+	}
+
+	switch *policy {
+	case "newest":
+		opts.Policy = syncer.NewestWins
+	case "keep-both":
+		opts.Policy = syncer.KeepBoth
+	case "manual":
+		opts.Policy = syncer.Manual
+	default:
+		return errors.Errorf(`unknown conflict policy "%s"`, *policy)
+	}
+
+	plan, err := syncer.Bisync(c.ctx, c.fs.Fs(), flags.Arg(0), remotePath(flags.Arg(1)), opts)
+	return c.printPlan(plan, err)
+}
+
+// printPlan prints the operations of a sync and returns its error.
+func (c *cli) printPlan(plan *syncer.Plan, err error) error {
+	if plan == nil {
+		return err
+	}
+
+	ops := make([]syncOp, len(plan.Ops))
+	for i, op := range plan.Ops {
+		ops[i] = syncOp{Action: op.Action.String(), Path: op.Path, From: op.From, Size: op.Size, Reason: op.Reason}
+		if op.Err != nil {
+			ops[i].Error = op.Err.Error()
+		}
+	}
+	if printErr := c.print(ops, func(w io.Writer) {
+		fmt.Fprint(w, plan)
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
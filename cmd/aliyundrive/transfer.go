@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	register("upload", command{usage: "<local>... <remote>", help: "upload files or folders, with rapid upload", run: upload})
+	register("download", command{usage: "<remote> [local]", help: "download a file or folder", run: download})
+}
+
+// transfer is a file copied by upload or download.
+type transfer struct {
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	Size   int64  `json:"size"`
+	NodeId string `json:"file_id"`
+}
+
+func upload(c *cli, args []string) error {
+	if len(args) < 2 {
+		return usageError("upload")
+	}
+
+	srcs, dst := args[:len(args)-1], remotePath(args[len(args)-1])
+	node, err := c.fs.Stat(c.ctx, dst)
+	dstIsDir := err == nil && node.IsDirectory()
+	if err != nil && !errors.Is(err, drive.ErrorNotFound) {
+		return err
+	}
+	if len(srcs) > 1 && !dstIsDir {
+		return errors.Errorf(`"%s" is not a folder`, dst)
+	}
+
+	var transfers []transfer
+	for _, src := range srcs {
+		target := dst
+		if dstIsDir {
+			target = path.Join(dst, filepath.Base(src))
+		}
+
+		if err := c.uploadPath(src, target, &transfers); err != nil {
+			return err
+		}
+	}
+
+	return c.print(transfers, func(io.Writer) {})
+}
+
+func (c *cli) uploadPath(local string, remote string, transfers *[]transfer) error {
+	fi, err := os.Stat(local)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if fi.IsDir() {
+		if _, err := c.fs.MkdirAll(c.ctx, remote); err != nil {
+			return err
+		}
+
+		entries, err := ioutil.ReadDir(local)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, entry := range entries {
+			if err := c.uploadPath(filepath.Join(local, entry.Name()), path.Join(remote, entry.Name()), transfers); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	// an *os.File lets CreateFile compute the sha1 and proof for rapid upload
+	node, err := c.fs.Create(c.ctx, remote, f, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	*transfers = append(*transfers, transfer{Local: local, Remote: remote, Size: node.Size, NodeId: node.NodeId})
+	if !c.json {
+		fmt.Fprintf(c.out, "%s -> %s\n", local, remote)
+	}
+	return nil
+}
+
+func download(c *cli, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("download")
+	}
+
+	src := remotePath(args[0])
+	node, err := c.fs.Stat(c.ctx, src)
+	if err != nil {
+		return err
+	}
+
+	dst := "."
+	if len(args) == 2 {
+		dst = args[1]
+	}
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		dst = filepath.Join(dst, node.Name)
+	}
+
+	var transfers []transfer
+	if err := c.downloadPath(src, node, dst, &transfers); err != nil {
+		return err
+	}
+
+	return c.print(transfers, func(io.Writer) {})
+}
+
+func (c *cli) downloadPath(remote string, node *drive.Node, local string, transfers *[]transfer) error {
+	if node.IsDirectory() {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			return errors.WithStack(err)
+		}
+
+		nodes, err := c.fs.ReadDir(c.ctx, remote)
+		if err != nil {
+			return err
+		}
+		for i := range nodes {
+			if err := c.downloadPath(path.Join(remote, nodes[i].Name), &nodes[i], filepath.Join(local, nodes[i].Name), transfers); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := c.downloadFile(node, local); err != nil {
+		return err
+	}
+
+	*transfers = append(*transfers, transfer{Local: local, Remote: remote, Size: node.Size, NodeId: node.NodeId})
+	if !c.json {
+		fmt.Fprintf(c.out, "%s -> %s\n", remote, local)
+	}
+	return nil
+}
+
+// downloadFile writes to a temporary file renamed on success, an interrupted download leaves no partial file behind.
+func (c *cli) downloadFile(node *drive.Node, local string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(local), "."+filepath.Base(local)+".")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if node.Size > 0 {
+		rd, err := c.fs.Fs().Open(c.ctx, node, nil)
+		if err != nil {
+			return err
+		}
+		defer rd.Close()
+
+		if _, err := io.Copy(tmp, rd); err != nil {
+			return errors.Wrapf(err, `failed to download "%s"`, node.Name)
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	if t := node.ModTime(); !t.IsZero() {
+		_ = os.Chtimes(tmp.Name(), t, t)
+	}
+	return errors.WithStack(os.Rename(tmp.Name(), local))
+}
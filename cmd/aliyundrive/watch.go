@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/syncer"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	register("watch", command{
+		usage: "[-poll] [-debounce d] [-interval d] [-include glob]... [-exclude glob]... <local> <remote>",
+		help:  "keep uploading the new and modified files of a local folder",
+		run:   watch,
+	})
+}
+
+type watchResult struct {
+	Path    string `json:"path"`
+	NodeId  string `json:"file_id,omitempty"`
+	Attempt int    `json:"attempt"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func watch(c *cli, args []string) error {
+	var opts syncer.WatchOptions
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	flags.BoolVar(&opts.Poll, "poll", false, "scan the folder periodically instead of using inotify")
+	flags.DurationVar(&opts.Debounce, "debounce", 0, "how long files must stay unchanged before upload (default 2s)")
+	flags.DurationVar(&opts.PollInterval, "interval", 0, "scan interval when polling (default 10s)")
+	flags.Var((*stringList)(&opts.Include), "include", "only upload the files matching this glob")
+	flags.Var((*stringList)(&opts.Exclude), "exclude", "skip the files and folders matching this glob")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError("watch")
+	}
+
+	// each upload is printed as it happens, one JSON object per line with -json
+	opts.OnUpload = func(result syncer.UploadResult) {
+		r := watchResult{Path: result.Path, Attempt: result.Attempt, Skipped: result.Skipped}
+		if result.Node != nil {
+			r.NodeId = result.Node.NodeId
+		}
+		if result.Err != nil {
+			r.Error = result.Err.Error()
+		}
+
+		if c.json {
+			_ = json.NewEncoder(c.out).Encode(r)
+			return
+		}
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(c.out, "failed %s (attempt %d): %s\n", r.Path, r.Attempt, r.Error)
+		case r.Skipped:
+			fmt.Fprintf(c.out, "unchanged %s\n", r.Path)
+		default:
+			fmt.Fprintf(c.out, "uploaded %s\n", r.Path)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(c.ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := syncer.Watch(ctx, c.fs.Fs(), flags.Arg(0), remotePath(flags.Arg(1)), opts)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
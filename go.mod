@@ -1,9 +1,11 @@
 module github.com/K265/aliyundrive-go
 
-go 1.14
+go 1.16
 
 require (
 	github.com/dustinxie/ecc v0.0.0-20210511000915-959544187564
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
+	golang.org/x/net v0.0.0-20210428140749-89ef3d95e781
 )
@@ -0,0 +1,86 @@
+// Package config loads the drive.Config shared by the commands of this repository.
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+// DefaultPath is the config file used when none is given, see .config_default for its format.
+const DefaultPath = ".config"
+
+// Load reads a drive.Config from the JSON file at path.
+//
+// Rotated refresh tokens are written back to the file through OnRefreshToken,
+// so that the next run can still log in.
+func Load(path string) (*drive.Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to read config "%s"`, path)
+	}
+
+	var config drive.Config
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, errors.Wrapf(err, `failed to parse config "%s"`, path)
+	}
+
+	var mutex sync.Mutex
+	config.OnRefreshToken = func(refreshToken string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err := Save(path, refreshToken); err != nil {
+			log.Printf("failed to save refresh token: %+v", err)
+		}
+	}
+	return &config, nil
+}
+
+// Save updates the refresh token of the config file at path, keeping its other fields.
+func Save(path string, refreshToken string) error {
+	return Update(path, map[string]interface{}{"refresh_token": refreshToken})
+}
+
+// NewDeviceId returns a random device id, in the format of .config_default.
+func NewDeviceId() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Update sets fields of the config file at path, creating it if it doesn't exist.
+func Update(path string, updates map[string]interface{}) error {
+	fields := map[string]interface{}{}
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return errors.Wrapf(err, `failed to parse config "%s"`, path)
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	for k, v := range updates {
+		fields[k] = v
+	}
+	b, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// write then rename, so that a crash never leaves a truncated config behind
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, path))
+}
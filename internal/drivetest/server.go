@@ -0,0 +1,1745 @@
+// Package drivetest provides an in-memory fake of the aliyun drive API for offline tests.
+//
+// Only the endpoints used by package drive are implemented, with just enough
+// behaviour to exercise the client:
+//
+//	srv := drivetest.NewServer()
+//	defer srv.Close()
+//	fs, err := drive.NewFs(ctx, &drive.Config{RefreshToken: "token", HttpClient: srv.Client()})
+package drivetest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DriveId         = "1"
+	AlbumDriveId    = "2"
+	ResourceDriveId = "3"
+	BackupDriveId   = "4"
+	UserId          = "user"
+	RootId          = "root"
+
+	timeLayout = "2006-01-02T15:04:05.000Z"
+)
+
+// File is a file or folder stored by Server.
+type File struct {
+	DriveId      string
+	FileId       string
+	ParentFileId string
+	Name         string
+	Type         string
+	Meta         string
+	Data         []byte
+	Created      time.Time
+	Updated      time.Time
+	Trashed      bool
+	Starred      bool
+	Hidden       bool
+	Description  string
+	Labels       []string
+	// LocalCreated and LocalModified are the local_created_at and local_modified_at of the upload
+	// or of the last update, zero if not given
+	LocalCreated  time.Time
+	LocalModified time.Time
+	// ImageMedia and VideoMedia are served as image_media_metadata and video_media_metadata
+	ImageMedia map[string]interface{}
+	VideoMedia map[string]interface{}
+	// RevisionId is the revision of Data, Revisions are the older ones, the oldest first
+	RevisionId string
+	Revisions  []Revision
+}
+
+// Revision is an old content of a file, kept when the file is overwritten.
+type Revision struct {
+	RevisionId string
+	Data       []byte
+	Created    time.Time
+}
+
+func (r *Revision) json(fileId string, latest bool) map[string]interface{} {
+	return map[string]interface{}{
+		"revision_id":       r.RevisionId,
+		"file_id":           clientId(fileId),
+		"size":              len(r.Data),
+		"content_hash":      fmt.Sprintf("%X", sha1.Sum(r.Data)),
+		"is_latest_version": latest,
+		"created_at":        r.Created.UTC().Format(timeLayout),
+	}
+}
+
+// revision returns the revision revisionId of f, the current one included, or nil.
+func (f *File) revision(revisionId string) *Revision {
+	if revisionId == f.RevisionId {
+		return &Revision{RevisionId: f.RevisionId, Data: f.Data, Created: f.Updated}
+	}
+	for i := range f.Revisions {
+		if f.Revisions[i].RevisionId == revisionId {
+			return &f.Revisions[i]
+		}
+	}
+	return nil
+}
+
+// mimeTypes are the mime types by extension, the system ones depend on the platform.
+var mimeTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".heic": "image/heic",
+	".mp4":  "video/mp4",
+	".mov":  "video/quicktime",
+	".txt":  "text/plain",
+	".pdf":  "application/pdf",
+}
+
+// category returns the category of the file, from its extension as the server does.
+func (f *File) category() string {
+	switch strings.ToLower(path.Ext(f.Name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".heic":
+		return "image"
+	case ".mp4", ".mov", ".mkv":
+		return "video"
+	case ".mp3", ".flac":
+		return "audio"
+	case ".txt", ".pdf", ".doc", ".md":
+		return "doc"
+	case ".zip", ".rar", ".7z":
+		return "zip"
+	}
+	return "others"
+}
+
+// RootOf returns the id of the root folder of the drive driveId on the server, which clients
+// know as RootId. The album drive shares the root of the default drive.
+func RootOf(driveId string) string {
+	if driveId == ResourceDriveId || driveId == BackupDriveId {
+		return RootId + "-" + driveId
+	}
+	return RootId
+}
+
+// clientId returns the file id known by clients for fileId.
+func clientId(fileId string) string {
+	if strings.HasPrefix(fileId, RootId+"-") {
+		return RootId
+	}
+	return fileId
+}
+
+// sameDrive reports whether the drive ids a and b are the same drive.
+func sameDrive(a string, b string) bool {
+	return RootOf(a) == RootOf(b)
+}
+
+// Hash returns the upper case hex sha1 of the content, as the content_hash field.
+func (f *File) Hash() string {
+	return fmt.Sprintf("%X", sha1.Sum(f.Data))
+}
+
+func (f *File) json() map[string]interface{} {
+	m := map[string]interface{}{
+		"drive_id":       f.DriveId,
+		"file_id":        clientId(f.FileId),
+		"parent_file_id": clientId(f.ParentFileId),
+		"name":           f.Name,
+		"type":           f.Type,
+		"meta":           f.Meta,
+		"created_at":     f.Created.UTC().Format(timeLayout),
+		"updated_at":     f.Updated.UTC().Format(timeLayout),
+	}
+	if f.Type == "file" {
+		m["size"] = len(f.Data)
+		m["content_hash"] = f.Hash()
+		m["content_hash_name"] = "sha1"
+		m["file_extension"] = strings.TrimPrefix(path.Ext(f.Name), ".")
+		m["mime_type"] = "application/octet-stream"
+		if t, ok := mimeTypes[strings.ToLower(path.Ext(f.Name))]; ok {
+			m["mime_type"] = t
+		}
+		m["category"] = f.category()
+		if c := m["category"]; c == "image" || c == "video" {
+			m["thumbnail"] = "https://download.test/thumbnail/" + f.FileId
+		}
+	}
+	if !f.LocalCreated.IsZero() {
+		m["local_created_at"] = f.LocalCreated.UTC().Format(timeLayout)
+	}
+	if !f.LocalModified.IsZero() {
+		m["local_modified_at"] = f.LocalModified.UTC().Format(timeLayout)
+	}
+	if f.Starred {
+		m["starred"] = true
+	}
+	if f.Hidden {
+		m["hidden"] = true
+	}
+	if f.Description != "" {
+		m["description"] = f.Description
+	}
+	if len(f.Labels) > 0 {
+		m["labels"] = f.Labels
+	}
+	if f.ImageMedia != nil {
+		m["image_media_metadata"] = f.ImageMedia
+	}
+	if f.VideoMedia != nil {
+		m["video_media_metadata"] = f.VideoMedia
+	}
+	return m
+}
+
+// Share is a share link created on Server.
+type Share struct {
+	ShareId  string
+	Password string
+	FileIds  []string
+	// Expiration is zero for share links which never expire
+	Expiration    time.Time
+	Description   string
+	Created       time.Time
+	Updated       time.Time
+	Cancelled     bool
+	PreviewCount  int
+	DownloadCount int
+	SaveCount     int
+}
+
+func (sh *Share) expired() bool {
+	return !sh.Expiration.IsZero() && time.Now().After(sh.Expiration)
+}
+
+// Album is an album of the fake server.
+type Album struct {
+	AlbumId     string
+	Name        string
+	Description string
+	// FileIds are in the order the files were added
+	FileIds []string
+	Created time.Time
+	Updated time.Time
+}
+
+func (s *Server) albumJSON(a *Album) map[string]interface{} {
+	var images, videos int
+	for _, fileId := range a.FileIds {
+		f := s.get(fileId)
+		if f == nil {
+			continue
+		}
+		switch f.category() {
+		case "image":
+			images++
+		case "video":
+			videos++
+		}
+	}
+	return map[string]interface{}{
+		"album_id":    a.AlbumId,
+		"name":        a.Name,
+		"description": a.Description,
+		"file_count":  len(a.FileIds),
+		"image_count": images,
+		"video_count": videos,
+		"owner":       UserId,
+		"created_at":  a.Created.UnixNano() / int64(time.Millisecond),
+		"updated_at":  a.Updated.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func (s *Server) shareJSON(sh *Share) map[string]interface{} {
+	status := "enabled"
+	if sh.Cancelled {
+		status = "cancelled"
+	}
+	expiration := ""
+	if !sh.Expiration.IsZero() {
+		expiration = sh.Expiration.UTC().Format(timeLayout)
+	}
+	name := ""
+	if len(sh.FileIds) > 0 && s.files[sh.FileIds[0]] != nil {
+		name = s.files[sh.FileIds[0]].Name
+	}
+	return map[string]interface{}{
+		"share_id":       sh.ShareId,
+		"share_name":     name,
+		"description":    sh.Description,
+		"share_url":      "https://www.aliyundrive.com/s/" + sh.ShareId,
+		"share_pwd":      sh.Password,
+		"expiration":     expiration,
+		"expired":        sh.expired(),
+		"status":         status,
+		"drive_id":       DriveId,
+		"file_id_list":   sh.FileIds,
+		"creator":        UserId,
+		"preview_count":  sh.PreviewCount,
+		"download_count": sh.DownloadCount,
+		"save_count":     sh.SaveCount,
+		"created_at":     sh.Created.UTC().Format(timeLayout),
+		"updated_at":     sh.Updated.UTC().Format(timeLayout),
+	}
+}
+
+// shareOf returns the share link of the share token of r, which must match the share_id of req.
+//
+// must be called with s.mutex held
+func (s *Server) shareOf(r *http.Request, req request) (*Share, *apiError) {
+	shareId, ok := s.shareTokens[r.Header.Get("X-Share-Token")]
+	if !ok || shareId != req.string("share_id") {
+		return nil, &apiError{status: 401, code: "ShareLinkTokenInvalid", message: "ShareToken is invalid."}
+	}
+	sh := s.shares[shareId]
+	if sh.Cancelled {
+		return nil, &apiError{status: 400, code: "ShareLink.Cancelled", message: "The resource share_link has been cancelled."}
+	}
+	return sh, nil
+}
+
+// sharedFile returns the file with the given id if it is shared by sh, or nil.
+//
+// must be called with s.mutex held
+func (s *Server) sharedFile(sh *Share, fileId string) *File {
+	f := s.get(fileId)
+	for p := f; p != nil; p = s.get(p.ParentFileId) {
+		for _, id := range sh.FileIds {
+			if p.FileId == id {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// copyShared copies a shared file to the drive for the batch endpoint, it returns the copy,
+// the status and the body of the response.
+//
+// must be called with s.mutex held
+func (s *Server) copyShared(r *http.Request, req request) (*File, int, interface{}) {
+	sh, apiErr := s.shareOf(r, req)
+	if apiErr != nil {
+		return nil, apiErr.status, map[string]string{"code": apiErr.code, "message": apiErr.message}
+	}
+	f := s.sharedFile(sh, req.string("file_id"))
+	if f == nil {
+		apiErr = notFound("File")
+		return nil, apiErr.status, map[string]string{"code": apiErr.code, "message": apiErr.message}
+	}
+	parent := s.get(req.string("to_parent_file_id"))
+	if parent == nil || parent.Type != "folder" {
+		apiErr = notFound("ParentFileId")
+		return nil, apiErr.status, map[string]string{"code": apiErr.code, "message": apiErr.message}
+	}
+
+	name := f.Name
+	if s.child(parent.FileId, name) != nil {
+		if req["auto_rename"] != true {
+			return nil, 409, map[string]string{"code": "AlreadyExist.File", "message": "The resource file has already exists."}
+		}
+		name = s.uniqueName(parent.FileId, name)
+	}
+	c := s.copyTree(f, parent.FileId, name)
+	sh.SaveCount++
+	return c, 201, map[string]string{"file_id": c.FileId, "drive_id": c.DriveId}
+}
+
+type upload struct {
+	file  *File
+	parts map[int][]byte
+	mode  string
+}
+
+// Server is a fake aliyun drive, it is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mutex       sync.Mutex
+	files       map[string]*File
+	uploads     map[string]*upload
+	nextId      int
+	accessToken string
+	requests    map[string]int
+	failures    map[string][]failure
+	// changes is the log served by the delta endpoint, cursors are indexes in it
+	changes []change
+	shares  map[string]*Share
+	// shareTokens are the share ids by share token
+	shareTokens map[string]string
+	// tasks are the polls left before the asynchronous tasks succeed
+	tasks  map[string]int
+	albums map[string]*Album
+}
+
+type change struct {
+	op     string
+	fileId string
+}
+
+type failure struct {
+	status int
+	code   string
+}
+
+// NewServer starts a Server with an empty drive, the caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		files:       make(map[string]*File),
+		uploads:     make(map[string]*upload),
+		requests:    make(map[string]int),
+		failures:    make(map[string][]failure),
+		shares:      make(map[string]*Share),
+		shareTokens: make(map[string]string),
+		tasks:       make(map[string]int),
+		albums:      make(map[string]*Album),
+		accessToken: "access-token",
+	}
+	now := time.Now()
+	for _, driveId := range []string{DriveId, ResourceDriveId, BackupDriveId} {
+		s.files[RootOf(driveId)] = &File{DriveId: driveId, FileId: RootOf(driveId), Name: "root", Type: "folder", Created: now, Updated: now}
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns an http.Client sending every request, whatever its host, to s.
+func (s *Server) Client() *http.Client {
+	return &http.Client{Transport: &rewriteTransport{target: s.Server.URL, base: s.Server.Client().Transport}}
+}
+
+type rewriteTransport struct {
+	target string
+	base   http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	target := t.target + req.URL.Path
+	if req.URL.RawQuery != "" {
+		target += "?" + req.URL.RawQuery
+	}
+	u, err := req.URL.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	req.Host = u.Host
+	return t.base.RoundTrip(req)
+}
+
+// AccessToken returns the access token handed out by the fake token endpoint.
+func (s *Server) AccessToken() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.accessToken
+}
+
+// Requests returns how many times the endpoint with the given path has been called.
+func (s *Server) Requests(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[path]
+}
+
+// Fail makes the next call to the endpoint with the given path fail with status and error code.
+func (s *Server) Fail(path string, status int, code string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures[path] = append(s.failures[path], failure{status: status, code: code})
+}
+
+// Put stores a file under parentId and returns its id.
+func (s *Server) Put(parentId string, name string, data []byte) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.create(s.files[parentId].DriveId, parentId, name, "file", data).FileId
+}
+
+// Mkdir creates a folder under parentId and returns its id.
+func (s *Server) Mkdir(parentId string, name string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.create(s.files[parentId].DriveId, parentId, name, "folder", nil).FileId
+}
+
+// File returns a copy of the file with the given id, or nil.
+func (s *Server) File(fileId string) *File {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, ok := s.files[fileId]
+	if !ok || f.Trashed {
+		return nil
+	}
+
+	c := *f
+	return &c
+}
+
+// Modify calls fn with the file fileId, to change the fields the API can't set.
+func (s *Server) Modify(fileId string, fn func(f *File)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fn(s.files[fileId])
+}
+
+// ModifyShare calls fn with the share link shareId, e.g. to change its files.
+func (s *Server) ModifyShare(shareId string, fn func(sh *Share)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fn(s.shares[shareId])
+}
+
+// Share returns a copy of the share link with the given id, or nil.
+func (s *Server) Share(shareId string) *Share {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sh, ok := s.shares[shareId]
+	if !ok {
+		return nil
+	}
+
+	c := *sh
+	return &c
+}
+
+// Album returns a copy of the album with the given id, or nil.
+func (s *Server) Album(albumId string) *Album {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a, ok := s.albums[albumId]
+	if !ok {
+		return nil
+	}
+
+	c := *a
+	c.FileIds = append([]string(nil), a.FileIds...)
+	return &c
+}
+
+// Lookup returns a copy of the file at the slash separated path, or nil.
+func (s *Server) Lookup(path string) *File {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f := s.lookup(DriveId, path)
+	if f == nil {
+		return nil
+	}
+
+	c := *f
+	return &c
+}
+
+// must be called with s.mutex held
+func (s *Server) create(driveId string, parentId string, name string, kind string, data []byte) *File {
+	s.nextId++
+	now := time.Now()
+	f := &File{
+		DriveId:      driveId,
+		FileId:       fmt.Sprintf("%s%04d", kind[:2], s.nextId),
+		ParentFileId: parentId,
+		Name:         name,
+		Type:         kind,
+		Data:         data,
+		Created:      now,
+		Updated:      now,
+	}
+	if kind == "file" {
+		f.RevisionId = s.newRevisionId()
+	}
+	s.files[f.FileId] = f
+	s.changes = append(s.changes, change{op: "create", fileId: f.FileId})
+	return f
+}
+
+// must be called with s.mutex held
+func (s *Server) newRevisionId() string {
+	s.nextId++
+	return fmt.Sprintf("rev%04d", s.nextId)
+}
+
+// overwrite replaces the content of f, the old content is kept as a revision.
+//
+// must be called with s.mutex held
+func (s *Server) overwrite(f *File, data []byte) {
+	f.Revisions = append(f.Revisions, Revision{RevisionId: f.RevisionId, Data: f.Data, Created: f.Updated})
+	f.Data = data
+	f.RevisionId = s.newRevisionId()
+	f.Updated = time.Now()
+	s.changes = append(s.changes, change{op: "overwrite", fileId: f.FileId})
+}
+
+// must be called with s.mutex held
+func (s *Server) children(parentId string) []*File {
+	var items []*File
+	for _, f := range s.files {
+		if f.ParentFileId == parentId && !f.Trashed && f.FileId != RootId {
+			items = append(items, f)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+// must be called with s.mutex held
+func (s *Server) child(parentId string, name string) *File {
+	for _, f := range s.children(parentId) {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// must be called with s.mutex held
+func (s *Server) lookup(driveId string, path string) *File {
+	f := s.files[RootOf(driveId)]
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		f = s.child(f.FileId, name)
+		if f == nil {
+			return nil
+		}
+	}
+	return f
+}
+
+// must be called with s.mutex held
+func (s *Server) get(fileId string) *File {
+	f, ok := s.files[fileId]
+	if !ok || f.Trashed {
+		return nil
+	}
+	return f
+}
+
+// must be called with s.mutex held
+func (s *Server) remove(f *File) {
+	f.Trashed = true
+	for _, c := range s.children(f.FileId) {
+		s.remove(c)
+	}
+}
+
+// setDrive moves f and its descendants to the drive driveId.
+//
+// must be called with s.mutex held
+func (s *Server) setDrive(f *File, driveId string) {
+	f.DriveId = driveId
+	for _, c := range s.children(f.FileId) {
+		s.setDrive(c, driveId)
+	}
+}
+
+// must be called with s.mutex held
+func (s *Server) copyTree(f *File, parentId string, name string) *File {
+	c := s.create(s.files[parentId].DriveId, parentId, name, f.Type, f.Data)
+	c.Meta = f.Meta
+	for _, child := range s.children(f.FileId) {
+		s.copyTree(child, c.FileId, child.Name)
+	}
+	return c
+}
+
+// must be called with s.mutex held
+func (s *Server) uniqueName(parentId string, name string) string {
+	if s.child(parentId, name) == nil {
+		return name
+	}
+
+	ext := ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		name, ext = name[:i], name[i:]
+	}
+	for i := 1; ; i++ {
+		n := fmt.Sprintf("%s(%d)%s", name, i, ext)
+		if s.child(parentId, n) == nil {
+			return n
+		}
+	}
+}
+
+func proof(accessToken string, data []byte) string {
+	sum := md5.Sum([]byte(accessToken))
+	r, _ := new(big.Int).SetString(hex.EncodeToString(sum[:])[:16], 16)
+	o := int64(0)
+	if len(data) > 0 {
+		o = r.Mod(r, big.NewInt(int64(len(data)))).Int64()
+	}
+	end := o + 8
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return base64.StdEncoding.EncodeToString(data[o:end])
+}
+
+type request map[string]interface{}
+
+func (r request) string(key string) string {
+	v, _ := r[key].(string)
+	return v
+}
+
+func (r request) int(key string) int64 {
+	v, _ := r[key].(float64)
+	return int64(v)
+}
+
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func notFound(kind string) *apiError {
+	return &apiError{status: 404, code: "NotFound." + kind, message: "The resource " + kind + " cannot be found."}
+}
+
+func badRequest(message string) *apiError {
+	return &apiError{status: 400, code: "InvalidParameter", message: message}
+}
+
+type handler func(s *Server, r *http.Request, req request) (interface{}, *apiError)
+
+var handlers = map[string]handler{}
+
+// handle registers the fake implementation of an endpoint, the request body is decoded into req.
+func handle(path string, h handler) {
+	handlers[path] = h
+}
+
+var rangeRe = regexp.MustCompile(`^/(download|upload)/([^/]+)(?:/(\d+))?$`)
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.requests[r.URL.Path]++
+	if fs := s.failures[r.URL.Path]; len(fs) > 0 {
+		s.failures[r.URL.Path] = fs[1:]
+		s.mutex.Unlock()
+		writeError(w, &apiError{status: fs[0].status, code: fs[0].code, message: "injected failure"})
+		return
+	}
+	s.mutex.Unlock()
+
+	if m := rangeRe.FindStringSubmatch(r.URL.Path); m != nil {
+		if m[1] == "download" {
+			s.serveDownload(w, r, m[2])
+		} else {
+			s.serveUpload(w, r, m[2], m[3])
+		}
+		return
+	}
+
+	h, ok := handlers[r.URL.Path]
+	if !ok {
+		writeError(w, &apiError{status: 404, code: "NotFound", message: "unknown endpoint " + r.URL.Path})
+		return
+	}
+
+	if r.URL.Path != "/v2/account/token" && r.Header.Get("Authorization") != "Bearer "+s.AccessToken() {
+		writeError(w, &apiError{status: 401, code: "AccessTokenInvalid", message: "AccessToken is invalid."})
+		return
+	}
+
+	req := request{}
+	b, _ := ioutil.ReadAll(r.Body)
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &req); err != nil {
+			writeError(w, badRequest(err.Error()))
+			return
+		}
+	}
+
+	// the root folders of the other drives are known as RootId by clients
+	for _, key := range []string{"file_id", "parent_file_id", "to_parent_file_id"} {
+		driveId := req.string("drive_id")
+		if key == "to_parent_file_id" && req.string("to_drive_id") != "" {
+			driveId = req.string("to_drive_id")
+		}
+		if req.string(key) == RootId {
+			req[key] = RootOf(driveId)
+		}
+	}
+
+	s.mutex.Lock()
+	res, apiErr := h(s, r, req)
+	s.mutex.Unlock()
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"code": err.code, "message": err.message})
+}
+
+func (s *Server) serveDownload(w http.ResponseWriter, r *http.Request, fileId string) {
+	s.mutex.Lock()
+	f := s.get(fileId)
+	var data []byte
+	var updated time.Time
+	if f != nil {
+		data, updated = f.Data, f.Updated
+		if revisionId := r.URL.Query().Get("revision_id"); revisionId != "" {
+			if rev := f.revision(revisionId); rev != nil {
+				data, updated = rev.Data, rev.Created
+			} else {
+				f = nil
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	if f == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Header.Get("Referer") != "https://www.aliyundrive.com/" {
+		http.Error(w, "referer required", http.StatusForbidden)
+		return
+	}
+
+	http.ServeContent(w, r, "", updated, bytes.NewReader(data))
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, uploadId string, part string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, _ := strconv.Atoi(part)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.uploads[uploadId]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	u.parts[n] = data
+}
+
+func (s *Server) uploadUrl(uploadId string, part int) string {
+	return fmt.Sprintf("https://upload.test/upload/%s/%d", uploadId, part)
+}
+
+func (s *Server) nodes(files []*File) []map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(files))
+	for _, f := range files {
+		items = append(items, f.json())
+	}
+	return items
+}
+
+// page returns files[marker:marker+limit] and the next marker
+func page(files []*File, req request) ([]*File, string) {
+	start, end, next := pageBounds(len(files), req)
+	return files[start:end], next
+}
+
+// pageBounds returns the page of n items requested by the marker and limit of req.
+func pageBounds(n int, req request) (start int, end int, next string) {
+	start, _ = strconv.Atoi(req.string("marker"))
+	limit := int(req.int("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	if start > n {
+		start = n
+	}
+	end = start + limit
+	next = strconv.Itoa(end)
+	if end >= n {
+		end = n
+		next = ""
+	}
+	return start, end, next
+}
+
+// must be called with s.mutex held
+func (s *Server) createEntry(req request, kind string, data []byte) (*File, bool, *apiError) {
+	parentId := req.string("parent_file_id")
+	name := req.string("name")
+	parent := s.get(parentId)
+	if parent == nil || parent.Type != "folder" {
+		return nil, false, notFound("ParentFileId")
+	}
+
+	if existing := s.child(parentId, name); existing != nil {
+		switch req.string("check_name_mode") {
+		case "auto_rename":
+			name = s.uniqueName(parentId, name)
+		case "overwrite":
+			if existing.Type != kind {
+				return nil, false, &apiError{status: 409, code: "AlreadyExist.File", message: "The resource file has already exists."}
+			}
+			// files keep their id and their old content as a revision
+			if kind == "file" {
+				s.overwrite(existing, data)
+				existing.Meta = req.string("meta")
+				existing.LocalCreated, existing.LocalModified = time.Time{}, time.Time{}
+				if err := setLocalTimes(existing, req); err != nil {
+					return nil, false, err
+				}
+				return existing, false, nil
+			}
+			s.remove(existing)
+			s.changes = append(s.changes, change{op: "delete", fileId: existing.FileId})
+		default:
+			return existing, true, nil
+		}
+	}
+
+	driveId := req.string("drive_id")
+	if driveId == "" {
+		driveId = parent.DriveId
+	}
+	f := s.create(driveId, parentId, name, kind, data)
+	f.Meta = req.string("meta")
+	if err := setLocalTimes(f, req); err != nil {
+		return nil, false, err
+	}
+	return f, false, nil
+}
+
+// setLocalTimes sets the local times of f given by req.
+func setLocalTimes(f *File, req request) *apiError {
+	for key, t := range map[string]*time.Time{"local_created_at": &f.LocalCreated, "local_modified_at": &f.LocalModified} {
+		if v := req.string(key); v != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return badRequest("invalid " + key)
+			}
+			*t = parsed
+		}
+	}
+	return nil
+}
+
+func init() {
+	handle("/v2/account/token", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		if req.string("refresh_token") == "" {
+			return nil, &apiError{status: 400, code: "InvalidParameter.RefreshToken", message: "refresh_token is not valid"}
+		}
+		s.nextId++
+		s.accessToken = fmt.Sprintf("access-token-%d", s.nextId)
+		return map[string]interface{}{
+			"access_token":  s.accessToken,
+			"expires_in":    7200,
+			"refresh_token": fmt.Sprintf("refresh-token-%d", s.nextId),
+		}, nil
+	})
+	handle("/adrive/v2/user/get", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		return map[string]string{"default_drive_id": DriveId, "resource_drive_id": ResourceDriveId, "backup_drive_id": BackupDriveId, "user_id": UserId}, nil
+	})
+	handle("/v2/drive/list_my_drives", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		var items []map[string]interface{}
+		for _, d := range []struct{ id, name, category string }{
+			{DriveId, "Default", ""},
+			{ResourceDriveId, "resource", "resource"},
+			{BackupDriveId, "backup", "backup"},
+		} {
+			var used int
+			for _, f := range s.files {
+				if f.DriveId == d.id && !f.Trashed {
+					used += len(f.Data)
+				}
+			}
+			items = append(items, map[string]interface{}{
+				"drive_id":   d.id,
+				"drive_name": d.name,
+				"drive_type": "normal",
+				"category":   d.category,
+				"owner":      UserId,
+				"status":     "enabled",
+				"total_size": int64(1) << 40,
+				"used_size":  used,
+			})
+		}
+		return map[string]interface{}{"items": items, "next_marker": ""}, nil
+	})
+	handle("/adrive/v1/user/albums_info", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		return map[string]interface{}{"data": map[string]string{"driveId": AlbumDriveId}}, nil
+	})
+	session := func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		if r.Header.Get("X-Signature") == "" || r.Header.Get("X-Device-Id") == "" {
+			return nil, badRequest("missing signature")
+		}
+		return map[string]bool{"success": true}, nil
+	}
+	handle("/users/v1/users/device/create_session", session)
+	handle("/users/v1/users/device/renew_session", session)
+	handle("/v2/databox/get_personal_info", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		used := 0
+		for _, f := range s.files {
+			if !f.Trashed {
+				used += len(f.Data)
+			}
+		}
+		return map[string]interface{}{
+			"personal_space_info": map[string]int{"used_size": used, "total_size": 1 << 40},
+		}, nil
+	})
+	handle("/adrive/v3/file/list", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		parent := s.get(req.string("parent_file_id"))
+		if parent == nil {
+			return nil, notFound("File")
+		}
+		items, next := page(s.children(parent.FileId), req)
+		return map[string]interface{}{"items": s.nodes(items), "next_marker": next}, nil
+	})
+	handle("/v2/file/get", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil {
+			return nil, notFound("File")
+		}
+		return f.json(), nil
+	})
+	handle("/v2/file/get_by_path", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.lookup(req.string("drive_id"), req.string("file_path"))
+		if f == nil {
+			return nil, notFound("File")
+		}
+		return f.json(), nil
+	})
+	createFolder := func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f, exist, err := s.createEntry(req, "folder", nil)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"file_id": f.FileId, "parent_file_id": f.ParentFileId, "file_name": f.Name, "exist": exist}, nil
+	}
+	handle("/v2/file/create", createFolder)
+	handle("/adrive/v2/file/createWithFolders", createFolder)
+	handle("/v2/file/create_with_proof", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		hash := strings.ToUpper(req.string("content_hash"))
+		size := req.int("size")
+		if hash != "" {
+			for _, f := range s.files {
+				if f.Type != "file" || f.Trashed || f.Hash() != hash || int64(len(f.Data)) != size {
+					continue
+				}
+				if req.string("proof_code") != proof(s.accessToken, f.Data) {
+					break
+				}
+
+				c, exist, err := s.createEntry(req, "file", f.Data)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{"file_id": c.FileId, "file_name": c.Name, "rapid_upload": !exist, "exist": exist}, nil
+			}
+		}
+
+		parent := s.get(req.string("parent_file_id"))
+		if parent == nil {
+			return nil, notFound("ParentFileId")
+		}
+		if existing := s.child(parent.FileId, req.string("name")); existing != nil && req.string("check_name_mode") != "auto_rename" && req.string("check_name_mode") != "overwrite" {
+			return map[string]interface{}{"file_id": existing.FileId, "file_name": existing.Name, "exist": true}, nil
+		}
+
+		file := &File{ParentFileId: parent.FileId, Name: req.string("name"), Type: "file", Meta: req.string("meta")}
+		if err := setLocalTimes(file, req); err != nil {
+			return nil, err
+		}
+		s.nextId++
+		uploadId := fmt.Sprintf("upload%04d", s.nextId)
+		s.uploads[uploadId] = &upload{
+			file:  file,
+			parts: make(map[int][]byte),
+			mode:  req.string("check_name_mode"),
+		}
+		var parts []map[string]interface{}
+		list, _ := req["part_info_list"].([]interface{})
+		for i := range list {
+			parts = append(parts, map[string]interface{}{"part_number": i + 1, "upload_url": s.uploadUrl(uploadId, i+1)})
+		}
+		return map[string]interface{}{
+			"file_id":        uploadId,
+			"upload_id":      uploadId,
+			"file_name":      req.string("name"),
+			"part_info_list": parts,
+		}, nil
+	})
+	handle("/v2/file/get_upload_url", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		uploadId := req.string("upload_id")
+		if _, ok := s.uploads[uploadId]; !ok {
+			return nil, notFound("UploadId")
+		}
+		var parts []map[string]interface{}
+		list, _ := req["part_info_list"].([]interface{})
+		for _, p := range list {
+			n := int(request(p.(map[string]interface{})).int("part_number"))
+			parts = append(parts, map[string]interface{}{"part_number": n, "upload_url": s.uploadUrl(uploadId, n)})
+		}
+		return map[string]interface{}{"upload_id": uploadId, "file_id": uploadId, "part_info_list": parts}, nil
+	})
+	handle("/v2/file/complete", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		uploadId := req.string("upload_id")
+		u, ok := s.uploads[uploadId]
+		if !ok {
+			return nil, notFound("UploadId")
+		}
+		delete(s.uploads, uploadId)
+
+		var numbers []int
+		for n := range u.parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, u.parts[n]...)
+		}
+
+		f, _, err := s.createEntry(request{
+			"parent_file_id":  u.file.ParentFileId,
+			"name":            u.file.Name,
+			"meta":            u.file.Meta,
+			"check_name_mode": u.mode,
+		}, "file", data)
+		if err != nil {
+			return nil, err
+		}
+		f.LocalCreated, f.LocalModified = u.file.LocalCreated, u.file.LocalModified
+		return f.json(), nil
+	})
+	handle("/v2/file/get_download_url", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil {
+			return nil, notFound("File")
+		}
+		url, size := "https://download.test/download/"+f.FileId, len(f.Data)
+		if revisionId := req.string("revision_id"); revisionId != "" {
+			rev := f.revision(revisionId)
+			if rev == nil {
+				return nil, notFound("Revision")
+			}
+			url, size = url+"?revision_id="+revisionId, len(rev.Data)
+		}
+		return map[string]interface{}{
+			"url":          url,
+			"internal_url": url,
+			"size":         size,
+			"expiration":   time.Now().Add(15 * time.Minute).UTC().Format(time.RFC3339Nano),
+		}, nil
+	})
+	handle("/v2/file/update", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil {
+			return nil, notFound("File")
+		}
+		op := "update"
+		if name, ok := req["name"].(string); ok && name != "" && name != f.Name {
+			if s.child(f.ParentFileId, name) != nil && req.string("check_name_mode") == "refuse" {
+				return nil, &apiError{status: 409, code: "AlreadyExist.File", message: "The resource file has already exists."}
+			}
+			f.Name = name
+			op = "rename"
+		}
+		if meta, ok := req["meta"].(string); ok {
+			f.Meta = meta
+		}
+		if starred, ok := req["starred"].(bool); ok {
+			f.Starred = starred
+		}
+		if hidden, ok := req["hidden"].(bool); ok {
+			f.Hidden = hidden
+		}
+		if description, ok := req["description"].(string); ok {
+			f.Description = description
+		}
+		if labels, ok := req["labels"].([]interface{}); ok {
+			f.Labels = nil
+			for _, label := range labels {
+				f.Labels = append(f.Labels, label.(string))
+			}
+		}
+		if err := setLocalTimes(f, req); err != nil {
+			return nil, err
+		}
+		f.Updated = time.Now()
+		s.changes = append(s.changes, change{op: op, fileId: f.FileId})
+		return f.json(), nil
+	})
+	handle("/v2/file/move", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil || !sameDrive(f.DriveId, req.string("drive_id")) {
+			return nil, notFound("File")
+		}
+		parent := s.get(req.string("to_parent_file_id"))
+		if parent == nil || parent.Type != "folder" {
+			return nil, notFound("ParentFileId")
+		}
+		name := req.string("new_name")
+		if name == "" {
+			name = f.Name
+		}
+		if existing := s.child(parent.FileId, name); existing != nil && existing != f {
+			return nil, &apiError{status: 409, code: "AlreadyExist.File", message: "The resource file has already exists."}
+		}
+		f.ParentFileId = parent.FileId
+		f.Name = name
+		s.setDrive(f, parent.DriveId)
+		s.changes = append(s.changes, change{op: "move", fileId: f.FileId})
+		return map[string]string{"file_id": f.FileId, "drive_id": f.DriveId}, nil
+	})
+	handle("/v2/file/copy", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil || !sameDrive(f.DriveId, req.string("drive_id")) {
+			return nil, notFound("File")
+		}
+		parent := s.get(req.string("to_parent_file_id"))
+		if parent == nil || parent.Type != "folder" {
+			return nil, notFound("ParentFileId")
+		}
+		name := req.string("new_name")
+		if name == "" {
+			name = f.Name
+		}
+		if s.child(parent.FileId, name) != nil {
+			name = s.uniqueName(parent.FileId, name)
+		}
+		c := s.copyTree(f, parent.FileId, name)
+		return map[string]string{"file_id": c.FileId, "drive_id": c.DriveId}, nil
+	})
+	remove := func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil {
+			return nil, notFound("File")
+		}
+		s.remove(f)
+		s.changes = append(s.changes, change{op: "trash", fileId: f.FileId})
+		return nil, nil
+	}
+	handle("/v2/recyclebin/trash", remove)
+	handle("/v3/file/delete", remove)
+	handle("/v2/file/list_delta", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		if req.string("cursor") == "" {
+			return map[string]interface{}{"items": []interface{}{}, "cursor": strconv.Itoa(len(s.changes)), "has_more": false}, nil
+		}
+		start, err := strconv.Atoi(req.string("cursor"))
+		if err != nil || start < 0 || start > len(s.changes) {
+			return nil, &apiError{status: 400, code: "InvalidParameter.Cursor", message: "cursor is not valid"}
+		}
+
+		end := start + int(req.int("limit"))
+		if end <= start || end > len(s.changes) {
+			end = len(s.changes)
+		}
+		items := make([]map[string]interface{}, 0, end-start)
+		for _, c := range s.changes[start:end] {
+			item := map[string]interface{}{"op": c.op, "file_id": c.fileId}
+			if c.op != "delete" && c.op != "trash" {
+				item["file"] = s.files[c.fileId].json()
+			}
+			items = append(items, item)
+		}
+		return map[string]interface{}{"items": items, "cursor": strconv.Itoa(end), "has_more": end < len(s.changes)}, nil
+	})
+	nameQuery := regexp.MustCompile(`name = "(.*)"`)
+	starredQuery := "starred = true"
+	handle("/v2/share_link/create", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		list, _ := req["file_id_list"].([]interface{})
+		if len(list) == 0 {
+			return nil, badRequest("file_id_list is required")
+		}
+		now := time.Now()
+		sh := &Share{Password: req.string("share_pwd"), Created: now, Updated: now}
+		for _, id := range list {
+			fileId, _ := id.(string)
+			if s.get(fileId) == nil {
+				return nil, notFound("File")
+			}
+			sh.FileIds = append(sh.FileIds, fileId)
+		}
+		if expiration := req.string("expiration"); expiration != "" {
+			t, err := time.Parse(time.RFC3339Nano, expiration)
+			if err != nil {
+				return nil, badRequest("invalid expiration")
+			}
+			sh.Expiration = t
+		}
+		s.nextId++
+		sh.ShareId = fmt.Sprintf("share%04d", s.nextId)
+		s.shares[sh.ShareId] = sh
+		return s.shareJSON(sh), nil
+	})
+	handle("/v2/share_link/get", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		sh, ok := s.shares[req.string("share_id")]
+		if !ok {
+			return nil, notFound("ShareLink")
+		}
+		return s.shareJSON(sh), nil
+	})
+	handle("/v2/share_link/list", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		var shares []*Share
+		for _, sh := range s.shares {
+			if creator := req.string("creator"); creator != "" && creator != UserId {
+				continue
+			}
+			if !sh.Cancelled || req["include_cancelled"] == true {
+				shares = append(shares, sh)
+			}
+		}
+
+		names := make(map[*Share]string, len(shares))
+		for _, sh := range shares {
+			names[sh] = s.shareJSON(sh)["share_name"].(string)
+		}
+		less := map[string]func(a, b *Share) bool{
+			"share_name": func(a, b *Share) bool { return names[a] < names[b] },
+			"created_at": func(a, b *Share) bool { return a.Created.Before(b.Created) },
+			"updated_at": func(a, b *Share) bool { return a.Updated.Before(b.Updated) },
+			"expiration": func(a, b *Share) bool { return a.Expiration.Before(b.Expiration) },
+		}
+		orderBy := req.string("order_by")
+		if orderBy == "" {
+			orderBy = "share_name"
+		}
+		by, ok := less[orderBy]
+		if !ok {
+			return nil, badRequest("invalid order_by")
+		}
+		desc := req.string("order_direction") == "DESC"
+		sort.Slice(shares, func(i, j int) bool {
+			a, b := shares[i], shares[j]
+			if desc {
+				a, b = b, a
+			}
+			if by(a, b) != by(b, a) {
+				return by(a, b)
+			}
+			return a.ShareId < b.ShareId
+		})
+
+		start, end, next := pageBounds(len(shares), req)
+		items := make([]map[string]interface{}, 0, end-start)
+		for _, sh := range shares[start:end] {
+			items = append(items, s.shareJSON(sh))
+		}
+		return map[string]interface{}{"items": items, "next_marker": next}, nil
+	})
+	handle("/v2/share_link/update", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		sh, ok := s.shares[req.string("share_id")]
+		if !ok {
+			return nil, notFound("ShareLink")
+		}
+		if sh.Cancelled {
+			return nil, &apiError{status: 400, code: "ShareLink.Cancelled", message: "The resource share_link has been cancelled."}
+		}
+		if pwd, ok := req["share_pwd"].(string); ok {
+			sh.Password = pwd
+		}
+		if description, ok := req["description"].(string); ok {
+			sh.Description = description
+		}
+		if expiration, ok := req["expiration"].(string); ok {
+			sh.Expiration = time.Time{}
+			if expiration != "" {
+				t, err := time.Parse(time.RFC3339Nano, expiration)
+				if err != nil {
+					return nil, badRequest("invalid expiration")
+				}
+				sh.Expiration = t
+			}
+		}
+		sh.Updated = time.Now()
+		return s.shareJSON(sh), nil
+	})
+	handle("/v2/share_link/cancel", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		sh, ok := s.shares[req.string("share_id")]
+		if !ok {
+			return nil, notFound("ShareLink")
+		}
+		sh.Cancelled = true
+		return map[string]interface{}{"share_id": sh.ShareId}, nil
+	})
+	handle("/v2/share_link/get_share_token", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		sh, ok := s.shares[req.string("share_id")]
+		switch {
+		case !ok:
+			return nil, notFound("ShareLink")
+		case sh.Cancelled:
+			return nil, &apiError{status: 400, code: "ShareLink.Cancelled", message: "The resource share_link has been cancelled."}
+		case sh.expired():
+			return nil, &apiError{status: 400, code: "ShareLink.Expired", message: "The resource share_link has expired."}
+		case sh.Password != req.string("share_pwd"):
+			return nil, &apiError{status: 400, code: "InvalidResource.SharePwd", message: "The share_pwd is invalid."}
+		}
+
+		s.nextId++
+		token := fmt.Sprintf("share-token%04d", s.nextId)
+		s.shareTokens[token] = sh.ShareId
+		sh.PreviewCount++
+		return map[string]interface{}{
+			"share_token": token,
+			"expire_time": time.Now().Add(2 * time.Hour).UTC().Format(timeLayout),
+			"expires_in":  7200,
+		}, nil
+	})
+	handle("/v2/share_link/get_by_anonymous", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		sh, ok := s.shares[req.string("share_id")]
+		if !ok {
+			return nil, notFound("ShareLink")
+		}
+		if sh.Cancelled {
+			return nil, &apiError{status: 400, code: "ShareLink.Cancelled", message: "The resource share_link has been cancelled."}
+		}
+		j := s.shareJSON(sh)
+		var infos []map[string]interface{}
+		for _, id := range sh.FileIds {
+			if f := s.files[id]; f != nil {
+				infos = append(infos, map[string]interface{}{"file_id": f.FileId, "file_name": f.Name, "type": f.Type})
+			}
+		}
+		return map[string]interface{}{
+			"creator_id":   UserId,
+			"creator_name": "tester",
+			"share_name":   j["share_name"],
+			"expiration":   j["expiration"],
+			"updated_at":   j["updated_at"],
+			"file_count":   len(infos),
+			"file_infos":   infos,
+		}, nil
+	})
+	handle("/adrive/v2/file/list_by_share", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		sh, err := s.shareOf(r, req)
+		if err != nil {
+			return nil, err
+		}
+		var files []*File
+		if parentId := req.string("parent_file_id"); parentId == RootId {
+			for _, id := range sh.FileIds {
+				if f := s.get(id); f != nil {
+					files = append(files, f)
+				}
+			}
+		} else {
+			parent := s.sharedFile(sh, parentId)
+			if parent == nil {
+				return nil, notFound("File")
+			}
+			files = s.children(parent.FileId)
+		}
+		items, next := page(files, req)
+		return map[string]interface{}{"items": s.nodes(items), "next_marker": next}, nil
+	})
+	handle("/adrive/v2/file/get_by_share", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		sh, err := s.shareOf(r, req)
+		if err != nil {
+			return nil, err
+		}
+		f := s.sharedFile(sh, req.string("file_id"))
+		if f == nil {
+			return nil, notFound("File")
+		}
+		return f.json(), nil
+	})
+	handle("/v2/file/get_share_link_download_url", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		sh, err := s.shareOf(r, req)
+		if err != nil {
+			return nil, err
+		}
+		f := s.sharedFile(sh, req.string("file_id"))
+		if f == nil || f.Type != "file" {
+			return nil, notFound("File")
+		}
+		sh.DownloadCount++
+		url := "https://download.test/download/" + f.FileId
+		return map[string]interface{}{
+			"download_url": url,
+			"url":          url,
+			"expiration":   time.Now().Add(10 * time.Minute).UTC().Format(time.RFC3339Nano),
+		}, nil
+	})
+	// only the copies of share files and share link cancellations are supported, folders are copied
+	// by asynchronous tasks
+	handle("/v2/batch", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		list, _ := req["requests"].([]interface{})
+		var responses []map[string]interface{}
+		for _, item := range list {
+			sub := request(item.(map[string]interface{}))
+			body, _ := sub["body"].(map[string]interface{})
+			res := map[string]interface{}{"id": sub.string("id")}
+			responses = append(responses, res)
+			switch sub.string("url") {
+			case "/share_link/cancel":
+				shareId := request(body).string("share_id")
+				sh, ok := s.shares[shareId]
+				if !ok {
+					e := notFound("ShareLink")
+					res["status"], res["body"] = e.status, map[string]string{"code": e.code, "message": e.message}
+					continue
+				}
+				sh.Cancelled = true
+				res["status"], res["body"] = 200, map[string]string{"share_id": shareId}
+				continue
+			case "/file/copy":
+			default:
+				res["status"], res["body"] = 400, map[string]string{"code": "InvalidParameter", "message": "unsupported request"}
+				continue
+			}
+
+			f, status, result := s.copyShared(r, request(body))
+			res["status"], res["body"] = status, result
+			if f != nil && f.Type == "folder" {
+				s.nextId++
+				taskId := fmt.Sprintf("task%04d", s.nextId)
+				s.tasks[taskId] = 1
+				res["status"], res["body"] = 202, map[string]string{"file_id": f.FileId, "async_task_id": taskId}
+			}
+		}
+		return map[string]interface{}{"responses": responses}, nil
+	})
+	handle("/v2/async_task/get", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		taskId := req.string("async_task_id")
+		polls, ok := s.tasks[taskId]
+		if !ok {
+			return nil, notFound("AsyncTask")
+		}
+		state := "Succeed"
+		if polls > 0 {
+			s.tasks[taskId]--
+			state = "Running"
+		}
+		return map[string]interface{}{"async_task_id": taskId, "state": state, "status": strings.ToLower(state)}, nil
+	})
+	handle("/adrive/v1/album/create", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		if req.string("name") == "" {
+			return nil, badRequest("name is required")
+		}
+		now := time.Now()
+		s.nextId++
+		a := &Album{AlbumId: fmt.Sprintf("album%04d", s.nextId), Name: req.string("name"), Description: req.string("description"), Created: now, Updated: now}
+		s.albums[a.AlbumId] = a
+		return s.albumJSON(a), nil
+	})
+	handle("/adrive/v1/album/get", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		a, ok := s.albums[req.string("album_id")]
+		if !ok {
+			return nil, notFound("Album")
+		}
+		return s.albumJSON(a), nil
+	})
+	handle("/adrive/v1/album/list", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		albums := make([]*Album, 0, len(s.albums))
+		for _, a := range s.albums {
+			albums = append(albums, a)
+		}
+		// the most recent first, as requested by the client
+		sort.Slice(albums, func(i, j int) bool { return albums[i].AlbumId > albums[j].AlbumId })
+		start, end, next := pageBounds(len(albums), req)
+		items := make([]map[string]interface{}, 0, end-start)
+		for _, a := range albums[start:end] {
+			items = append(items, s.albumJSON(a))
+		}
+		return map[string]interface{}{"items": items, "next_marker": next}, nil
+	})
+	handle("/adrive/v1/album/update", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		a, ok := s.albums[req.string("album_id")]
+		if !ok {
+			return nil, notFound("Album")
+		}
+		if name := req.string("name"); name != "" {
+			a.Name = name
+		}
+		a.Description = req.string("description")
+		a.Updated = time.Now()
+		return s.albumJSON(a), nil
+	})
+	handle("/adrive/v1/album/delete", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		if _, ok := s.albums[req.string("album_id")]; !ok {
+			return nil, notFound("Album")
+		}
+		delete(s.albums, req.string("album_id"))
+		return map[string]interface{}{}, nil
+	})
+	handle("/adrive/v1/album/add_files", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		a, ok := s.albums[req.string("album_id")]
+		if !ok {
+			return nil, notFound("Album")
+		}
+		list, _ := req["drive_file_list"].([]interface{})
+		var added []*File
+		for _, item := range list {
+			f := s.get(request(item.(map[string]interface{})).string("file_id"))
+			if f == nil || f.Type != "file" {
+				return nil, notFound("File")
+			}
+			added = append(added, f)
+		}
+		for _, f := range added {
+			found := false
+			for _, fileId := range a.FileIds {
+				found = found || fileId == f.FileId
+			}
+			if !found {
+				a.FileIds = append(a.FileIds, f.FileId)
+			}
+		}
+		a.Updated = time.Now()
+		return map[string]interface{}{"file_list": s.nodes(added)}, nil
+	})
+	handle("/adrive/v1/album/delete_files", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		a, ok := s.albums[req.string("album_id")]
+		if !ok {
+			return nil, notFound("Album")
+		}
+		removed := make(map[string]bool)
+		list, _ := req["drive_file_list"].([]interface{})
+		for _, item := range list {
+			removed[request(item.(map[string]interface{})).string("file_id")] = true
+		}
+		fileIds := a.FileIds[:0]
+		for _, fileId := range a.FileIds {
+			if !removed[fileId] {
+				fileIds = append(fileIds, fileId)
+			}
+		}
+		a.FileIds = fileIds
+		a.Updated = time.Now()
+		return map[string]interface{}{}, nil
+	})
+	handle("/adrive/v1/album/list_files", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		a, ok := s.albums[req.string("album_id")]
+		if !ok {
+			return nil, notFound("Album")
+		}
+		// the most recently added first, trashed files are hidden
+		var files []*File
+		for i := len(a.FileIds) - 1; i >= 0; i-- {
+			if f := s.get(a.FileIds[i]); f != nil {
+				files = append(files, f)
+			}
+		}
+		files, next := page(files, req)
+		return map[string]interface{}{"items": s.nodes(files), "next_marker": next}, nil
+	})
+	handle("/adrive/v3/file/search", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		if req.string("query") == starredQuery {
+			var items []*File
+			for _, f := range s.files {
+				if !f.Trashed && f.Starred && sameDrive(f.DriveId, req.string("drive_id")) {
+					items = append(items, f)
+				}
+			}
+			// the most recently updated first
+			sort.Slice(items, func(i, j int) bool {
+				if !items[i].Updated.Equal(items[j].Updated) {
+					return items[i].Updated.After(items[j].Updated)
+				}
+				return items[i].FileId < items[j].FileId
+			})
+			items, next := page(items, req)
+			return map[string]interface{}{"items": s.nodes(items), "next_marker": next}, nil
+		}
+
+		m := nameQuery.FindStringSubmatch(req.string("query"))
+		if m == nil {
+			return nil, badRequest("unsupported query")
+		}
+		var items []*File
+		for _, f := range s.files {
+			if !f.Trashed && f.FileId != RootId && f.Name == m[1] {
+				items = append(items, f)
+			}
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].FileId < items[j].FileId })
+		items, next := page(items, req)
+		return map[string]interface{}{"items": s.nodes(items), "next_marker": next}, nil
+	})
+	handle("/adrive/v1/revision/list", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil || f.Type != "file" {
+			return nil, notFound("File")
+		}
+		items := []map[string]interface{}{f.revision(f.RevisionId).json(f.FileId, true)}
+		for i := len(f.Revisions) - 1; i >= 0; i-- {
+			items = append(items, f.Revisions[i].json(f.FileId, false))
+		}
+		start, end, next := pageBounds(len(items), req)
+		return map[string]interface{}{"items": items[start:end], "next_marker": next}, nil
+	})
+	handle("/adrive/v1/revision/restore", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil || f.Type != "file" {
+			return nil, notFound("File")
+		}
+		revisionId := req.string("revision_id")
+		rev := f.revision(revisionId)
+		if rev == nil {
+			return nil, notFound("Revision")
+		}
+		if revisionId == f.RevisionId {
+			return nil, nil
+		}
+		// the restored revision becomes the current one, keeping its id
+		restored := *rev
+		f.Revisions = removeRevision(f.Revisions, revisionId)
+		s.overwrite(f, restored.Data)
+		f.RevisionId = restored.RevisionId
+		return nil, nil
+	})
+	handle("/adrive/v1/revision/delete", func(s *Server, r *http.Request, req request) (interface{}, *apiError) {
+		f := s.get(req.string("file_id"))
+		if f == nil || f.Type != "file" {
+			return nil, notFound("File")
+		}
+		revisionId := req.string("revision_id")
+		if revisionId == f.RevisionId {
+			return nil, badRequest("the latest revision can't be deleted")
+		}
+		if f.revision(revisionId) == nil {
+			return nil, notFound("Revision")
+		}
+		f.Revisions = removeRevision(f.Revisions, revisionId)
+		return nil, nil
+	})
+}
+
+// removeRevision returns revisions without the revision revisionId.
+func removeRevision(revisions []Revision, revisionId string) []Revision {
+	var kept []Revision
+	for _, rev := range revisions {
+		if rev.RevisionId != revisionId {
+			kept = append(kept, rev)
+		}
+	}
+	return kept
+}
+
+// ReadAll is a helper returning the content of the file at path, or nil if it doesn't exist.
+func (s *Server) ReadAll(path string) []byte {
+	f := s.Lookup(path)
+	if f == nil {
+		return nil
+	}
+	return f.Data
+}
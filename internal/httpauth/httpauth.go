@@ -0,0 +1,28 @@
+// Package httpauth protects the HTTP servers of this repository.
+package httpauth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// BasicAuth wraps next with HTTP basic authentication, an empty user disables it.
+func BasicAuth(next http.Handler, realm string, user string, password string) http.Handler {
+	if user == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || !secureCompare(u, user) || !secureCompare(p, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func secureCompare(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
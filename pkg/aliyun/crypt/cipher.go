@@ -0,0 +1,249 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// magic starts every encrypted file, it is followed by a random file nonce
+	magic         = "ADCRYPT1"
+	fileNonceSize = 32
+	headerSize    = len(magic) + fileNonceSize
+
+	// content is sealed in chunks of ChunkSize bytes, so ranged reads only decrypt the chunks they need
+	ChunkSize     = 64 * 1024
+	tagSize       = 16
+	sealedSize    = ChunkSize + tagSize
+	nameNonceSize = 12
+
+	kdfIterations = 100000
+
+	// saltMagic starts the salt file of a root, it is followed by saltSize random bytes
+	saltMagic = "ADSALT01"
+	saltSize  = 32
+)
+
+// names are base32 encoded, without padding and in lower case
+var nameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// keys are derived from a passphrase, see newKeys.
+type keys struct {
+	content []byte // derives the key of each file
+	nameMac []byte // derives the nonce of a name from the name
+	name    cipher.AEAD
+}
+
+func newKeys(passphrase string, salt []byte) (*keys, error) {
+	key := pbkdf2.Key([]byte(passphrase), salt, kdfIterations, 96, sha256.New)
+	name, err := newAEAD(key[64:])
+	if err != nil {
+		return nil, err
+	}
+	return &keys{content: key[:32], nameMac: key[32:64], name: name}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.WithStack(err)
+}
+
+func (k *keys) mac(key []byte, data []byte) hash.Hash {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h
+}
+
+// encryptName encrypts name deterministically, the same name always gives the same result
+// so that files can be looked up by their encrypted path.
+func (k *keys) encryptName(name string) string {
+	nonce := k.mac(k.nameMac, []byte(name)).Sum(nil)[:nameNonceSize]
+	sealed := k.name.Seal(nonce, nonce, []byte(name), nil)
+	return strings.ToLower(nameEncoding.EncodeToString(sealed))
+}
+
+func (k *keys) decryptName(encrypted string) (string, error) {
+	sealed, err := nameEncoding.DecodeString(strings.ToUpper(encrypted))
+	if err != nil || len(sealed) < nameNonceSize+tagSize {
+		return "", errors.Wrapf(ErrorNotEncrypted, `"%s"`, encrypted)
+	}
+
+	name, err := k.name.Open(nil, sealed[:nameNonceSize], sealed[nameNonceSize:], nil)
+	if err != nil {
+		return "", errors.Wrapf(ErrorNotEncrypted, `"%s"`, encrypted)
+	}
+	return string(name), nil
+}
+
+// fileCipher seals the chunks of a file with a key derived from its random nonce.
+func (k *keys) fileCipher(fileNonce []byte) (cipher.AEAD, error) {
+	return newAEAD(k.mac(k.content, fileNonce).Sum(nil))
+}
+
+// chunkNonce is the index of the chunk, the last byte tells the final chunk
+// so that truncated files are detected.
+func chunkNonce(index int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// chunkCount is the number of chunks of size bytes, empty files have one empty chunk.
+func chunkCount(size int64) int64 {
+	if size <= 0 {
+		return 1
+	}
+	return (size + ChunkSize - 1) / ChunkSize
+}
+
+// EncryptedSize returns the size of size bytes once encrypted.
+func EncryptedSize(size int64) int64 {
+	return int64(headerSize) + size + chunkCount(size)*tagSize
+}
+
+// DecryptedSize returns the size of the content of an encrypted file of size bytes.
+func DecryptedSize(size int64) (int64, error) {
+	body := size - int64(headerSize)
+	if body < tagSize {
+		return 0, errors.Wrapf(ErrorCorrupted, "invalid size %d", size)
+	}
+
+	full, rest := body/sealedSize, body%sealedSize
+	if rest == 0 {
+		return full * ChunkSize, nil
+	}
+	if rest < tagSize {
+		return 0, errors.Wrapf(ErrorCorrupted, "invalid size %d", size)
+	}
+	return full*ChunkSize + rest - tagSize, nil
+}
+
+// encrypter encrypts the content read from in.
+type encrypter struct {
+	in    io.Reader
+	keys  *keys
+	aead  cipher.AEAD
+	index int64
+	plain []byte // read ahead by one byte to tell the final chunk
+	out   bytes.Buffer
+	done  bool
+}
+
+func newEncrypter(k *keys, in io.Reader) *encrypter {
+	return &encrypter{in: in, keys: k, plain: make([]byte, 0, ChunkSize+1)}
+}
+
+func (e *encrypter) Read(p []byte) (int, error) {
+	for e.out.Len() == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.fill(); err != nil {
+			return 0, err
+		}
+	}
+	return e.out.Read(p)
+}
+
+// fill seals the next chunk into out.
+func (e *encrypter) fill() error {
+	if e.aead == nil {
+		nonce := make([]byte, fileNonceSize)
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return errors.WithStack(err)
+		}
+		aead, err := e.keys.fileCipher(nonce)
+		if err != nil {
+			return err
+		}
+		e.aead = aead
+		e.out.WriteString(magic)
+		e.out.Write(nonce)
+	}
+
+	n, err := io.ReadFull(e.in, e.plain[len(e.plain):ChunkSize+1])
+	e.plain = e.plain[:len(e.plain)+n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return errors.WithStack(err)
+	}
+
+	final := len(e.plain) <= ChunkSize
+	chunk := e.plain
+	if !final {
+		chunk = e.plain[:ChunkSize]
+	}
+	e.out.Write(e.aead.Seal(nil, chunkNonce(e.index, final), chunk, nil))
+	e.index++
+	e.done = final
+	e.plain = append(e.plain[:0], e.plain[len(chunk):]...)
+	return nil
+}
+
+// decrypter decrypts the chunks read from in, starting at chunk index.
+type decrypter struct {
+	in     io.ReadCloser
+	aead   cipher.AEAD
+	index  int64
+	last   int64 // index of the final chunk
+	skip   int64 // bytes to drop from the first chunk
+	remain int64 // bytes left to return
+	sealed []byte
+	buf    []byte
+	plain  []byte // the decrypted bytes not returned yet, in buf
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.remain <= 0 || d.index > d.last {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(d.in, d.sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, errors.WithStack(err)
+		}
+
+		plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.index, d.index == d.last), d.sealed[:n], nil)
+		if err != nil || int64(len(plain)) < d.skip {
+			return 0, errors.Wrapf(ErrorCorrupted, "chunk %d", d.index)
+		}
+		d.index++
+		plain = plain[d.skip:]
+		d.skip = 0
+		if int64(len(plain)) > d.remain {
+			plain = plain[:d.remain]
+		}
+		d.plain = plain
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	d.remain -= int64(n)
+	return n, nil
+}
+
+func (d *decrypter) Close() error {
+	return d.in.Close()
+}
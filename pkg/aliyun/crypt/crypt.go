@@ -0,0 +1,629 @@
+// Package crypt encrypts the files of an aliyun drive on the client side.
+//
+// The Fs returned by NewFs wraps a drive.Fs and keeps the files of a folder encrypted,
+// the WebDAV, S3 and command line layers work on its decrypted view:
+//
+//	fs, err = crypt.NewFs(ctx, fs, crypt.Options{Root: "/secret", Passphrase: passphrase})
+//
+// Names are encrypted with AES-GCM using a nonce derived from the name, so the same name
+// always gives the same encrypted name and paths can still be looked up. Content is sealed
+// with AES-GCM in chunks of ChunkSize bytes, so ranged reads only download and decrypt the
+// chunks they need. The keys are derived from the passphrase with PBKDF2, salted with the
+// random salt stored in a plain file under the root when it is first used: the encrypted files
+// can't be read without it.
+//
+// The server never sees the plain content, rapid upload and the download urls of files are
+// not available through the encrypted view.
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrorNotEncrypted is returned for the names which were not encrypted with the passphrase.
+	ErrorNotEncrypted = errors.New("not encrypted with this passphrase")
+	// ErrorCorrupted is returned when encrypted content fails authentication.
+	ErrorCorrupted = errors.New("encrypted content corrupted")
+)
+
+type Options struct {
+	// Passphrase derives the keys, it is required.
+	Passphrase string
+	// Root is the folder holding the encrypted files, "/" by default.
+	// It is created if missing, paths given to the Fs are relative to it.
+	Root string
+}
+
+// Fs is a drive.Fs encrypting the names and content of the files under its root.
+//
+// The files under the root which can't be decrypted are hidden. Albums, share links and the other
+// drives of the user aren't reached through an Fs, so they can't expose the encrypted files as if
+// they were plain ones.
+type Fs struct {
+	fs   drive.Fs
+	keys *keys
+	// the root must not be moved while the Fs is used
+	rootPath string
+	root     *drive.Node
+}
+
+var (
+	_ drive.Fs            = (*Fs)(nil)
+	_ drive.NodeUpdater   = (*Fs)(nil)
+	_ drive.StarredLister = (*Fs)(nil)
+	_ drive.RevisionFs    = (*Fs)(nil)
+	_ drive.DeltaLister   = (*Fs)(nil)
+	_ drive.LimitReporter = (*Fs)(nil)
+	_ drive.Replacer      = (*Fs)(nil)
+)
+
+func NewFs(ctx context.Context, fs drive.Fs, opts Options) (*Fs, error) {
+	if opts.Passphrase == "" {
+		return nil, errors.New("passphrase is required")
+	}
+
+	rootPath := path.Clean("/" + opts.Root)
+	if rootPath != "/" {
+		if _, err := fs.CreateFolderRecursively(ctx, rootPath); err != nil {
+			return nil, errors.Wrapf(err, `failed to create "%s"`, rootPath)
+		}
+	}
+	root, err := fs.GetByPath(ctx, rootPath, drive.FolderKind)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to get "%s"`, rootPath)
+	}
+
+	salt, err := loadSalt(ctx, fs, rootPath, root)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := newKeys(opts.Passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fs{fs: fs, keys: keys, rootPath: rootPath, root: root}, nil
+}
+
+// saltName is the name of the salt file under the root, it can't be decrypted so it is hidden.
+const saltName = ".crypt-salt"
+
+// loadSalt reads the salt file of root, a random salt is stored if it has none yet.
+func loadSalt(ctx context.Context, fs drive.Fs, rootPath string, root *drive.Node) ([]byte, error) {
+	node, err := fs.GetByPath(ctx, path.Join(rootPath, saltName), drive.FileKind)
+	if err == nil {
+		return readSalt(ctx, fs, node)
+	}
+	if !errors.Is(err, drive.ErrorNotFound) {
+		return nil, errors.Wrapf(err, `failed to get the salt of "%s"`, rootPath)
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data := append([]byte(saltMagic), salt...)
+	_, err = fs.CreateFile(ctx, drive.Node{ParentId: root.NodeId, Name: saltName, Size: int64(len(data))}, bytes.NewReader(data))
+	if errors.Is(err, drive.ErrorAlreadyExisted) {
+		// stored by another Fs in the meantime
+		return loadSalt(ctx, fs, rootPath, root)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to store the salt of "%s"`, rootPath)
+	}
+	return salt, nil
+}
+
+func readSalt(ctx context.Context, fs drive.Fs, node *drive.Node) ([]byte, error) {
+	rd, err := fs.Open(ctx, node, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the salt")
+	}
+	defer rd.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(rd, int64(len(saltMagic)+saltSize+1)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the salt")
+	}
+	if len(data) != len(saltMagic)+saltSize || string(data[:len(saltMagic)]) != saltMagic {
+		return nil, errors.Wrapf(ErrorCorrupted, `invalid salt file "%s"`, node.Name)
+	}
+	return data[len(saltMagic):], nil
+}
+
+// encryptPath maps fullPath to the path of the encrypted files, "" for the root.
+func (f *Fs) encryptPath(fullPath string) string {
+	fullPath = path.Clean("/" + fullPath)
+	if fullPath == "/" {
+		return ""
+	}
+
+	parts := strings.Split(fullPath[1:], "/")
+	for i, name := range parts {
+		parts[i] = f.keys.encryptName(name)
+	}
+	return strings.Join(parts, "/")
+}
+
+// decryptNode decrypts the name and size of node, the root is returned as is.
+func (f *Fs) decryptNode(node drive.Node) (drive.Node, error) {
+	if node.NodeId == f.root.NodeId {
+		return node, nil
+	}
+
+	name, err := f.keys.decryptName(node.Name)
+	if err != nil {
+		return node, err
+	}
+	node.Name = name
+
+	if node.Type == drive.FileKind {
+		size, err := DecryptedSize(node.Size)
+		if err != nil {
+			return node, errors.Wrapf(err, `"%s"`, name)
+		}
+		node.Size = size
+		// the hash of the encrypted content is useless to callers
+		node.Hash = ""
+	}
+	return node, nil
+}
+
+// decryptNodes decrypts nodes, dropping the ones which can't be decrypted.
+func (f *Fs) decryptNodes(nodes []drive.Node) []drive.Node {
+	decrypted := nodes[:0]
+	for _, node := range nodes {
+		if node, err := f.decryptNode(node); err == nil {
+			decrypted = append(decrypted, node)
+		}
+	}
+	return decrypted
+}
+
+// About returns the space info of the whole drive, encrypted files count with their encrypted size.
+func (f *Fs) About(ctx context.Context) (*drive.PersonalSpaceInfo, error) {
+	return f.fs.About(ctx)
+}
+
+// LimiterState returns the limits of the wrapped Fs, zero if it doesn't report them.
+func (f *Fs) LimiterState() drive.LimiterState {
+	if reporter, ok := f.fs.(drive.LimitReporter); ok {
+		return reporter.LimiterState()
+	}
+	return drive.LimiterState{}
+}
+
+// Get returns drive.ErrorNotFound for the nodes which can't be decrypted.
+func (f *Fs) Get(ctx context.Context, nodeId string) (*drive.Node, error) {
+	node, err := f.fs.Get(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := f.decryptNode(*node)
+	if err != nil {
+		return nil, errors.Wrapf(drive.ErrorNotFound, `"%s" %v`, nodeId, err)
+	}
+	return &decrypted, nil
+}
+
+func (f *Fs) GetByPath(ctx context.Context, fullPath string, kind string) (*drive.Node, error) {
+	encrypted := f.encryptPath(fullPath)
+	if encrypted == "" {
+		root := *f.root
+		return &root, nil
+	}
+
+	node, err := f.fs.GetByPath(ctx, path.Join(f.rootPath, encrypted), kind)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := f.decryptNode(*node)
+	if err != nil {
+		return nil, errors.Wrapf(drive.ErrorNotFound, `"%s" %v`, fullPath, err)
+	}
+	return &decrypted, nil
+}
+
+type pager struct {
+	drive.Pager
+	fs *Fs
+}
+
+func (p *pager) Nodes(ctx context.Context) ([]drive.Node, error) {
+	nodes, err := p.Pager.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.fs.decryptNodes(nodes), nil
+}
+
+// errorPager is a Pager whose only page fails with err.
+type errorPager struct {
+	err  error
+	done bool
+}
+
+func (p *errorPager) Next() bool {
+	return !p.done
+}
+
+func (p *errorPager) Nodes(ctx context.Context) ([]drive.Node, error) {
+	p.done = true
+	return nil, p.err
+}
+
+func (f *Fs) List(nodeId string) drive.Pager {
+	return &pager{Pager: f.fs.List(nodeId), fs: f}
+}
+
+func (f *Fs) ListAll(ctx context.Context, nodeId string) ([]drive.Node, error) {
+	nodes, err := f.fs.ListAll(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+	return f.decryptNodes(nodes), nil
+}
+
+// Search only finds the files named exactly name.
+func (f *Fs) Search(ctx context.Context, name string) ([]drive.Node, error) {
+	nodes, err := f.fs.Search(ctx, f.keys.encryptName(name))
+	if err != nil {
+		return nil, err
+	}
+	return f.decryptNodes(nodes), nil
+}
+
+// Starred drops the starred nodes of the drive which aren't encrypted with the passphrase.
+func (f *Fs) Starred() drive.Pager {
+	lister, ok := f.fs.(drive.StarredLister)
+	if !ok {
+		return &errorPager{err: errors.Wrap(drive.ErrorNotSupported, "no starred files")}
+	}
+	return &pager{Pager: lister.Starred(), fs: f}
+}
+
+func (f *Fs) ListStarred(ctx context.Context) ([]drive.Node, error) {
+	lister, ok := f.fs.(drive.StarredLister)
+	if !ok {
+		return nil, errors.Wrap(drive.ErrorNotSupported, "no starred files")
+	}
+	nodes, err := lister.ListStarred(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return f.decryptNodes(nodes), nil
+}
+
+// ListRevisions returns the sizes of the plain content of the revisions.
+func (f *Fs) ListRevisions(ctx context.Context, nodeId string) ([]drive.Revision, error) {
+	revisionFs, ok := f.fs.(drive.RevisionFs)
+	if !ok {
+		return nil, errors.Wrap(drive.ErrorNotSupported, "no revisions")
+	}
+	revisions, err := revisionFs.ListRevisions(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range revisions {
+		size, err := DecryptedSize(revisions[i].Size)
+		if err != nil {
+			return nil, errors.Wrapf(err, `revision "%s" of "%s"`, revisions[i].RevisionId, nodeId)
+		}
+		revisions[i].Size = size
+		// the hash of the encrypted content is useless to callers
+		revisions[i].Hash = ""
+	}
+	return revisions, nil
+}
+
+func (f *Fs) RestoreRevision(ctx context.Context, nodeId string, revisionId string) error {
+	revisionFs, ok := f.fs.(drive.RevisionFs)
+	if !ok {
+		return errors.Wrap(drive.ErrorNotSupported, "no revisions")
+	}
+	return revisionFs.RestoreRevision(ctx, nodeId, revisionId)
+}
+
+func (f *Fs) DeleteRevision(ctx context.Context, nodeId string, revisionId string) error {
+	revisionFs, ok := f.fs.(drive.RevisionFs)
+	if !ok {
+		return errors.Wrap(drive.ErrorNotSupported, "no revisions")
+	}
+	return revisionFs.DeleteRevision(ctx, nodeId, revisionId)
+}
+
+// ListDelta drops the changes of the nodes which can't be decrypted.
+func (f *Fs) ListDelta(ctx context.Context, cursor string) (*drive.Delta, error) {
+	lister, ok := f.fs.(drive.DeltaLister)
+	if !ok {
+		return nil, errors.Wrap(drive.ErrorNotSupported, "no delta")
+	}
+	delta, err := lister.ListDelta(ctx, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := delta.Changes[:0]
+	for _, change := range delta.Changes {
+		if change.Node != nil {
+			node, err := f.decryptNode(*change.Node)
+			if err != nil {
+				continue
+			}
+			change.Node = &node
+		}
+		changes = append(changes, change)
+	}
+	delta.Changes = changes
+	return delta, nil
+}
+
+func (f *Fs) CreateFolder(ctx context.Context, node drive.Node) (string, error) {
+	node.Name = f.keys.encryptName(node.Name)
+	return f.fs.CreateFolder(ctx, node)
+}
+
+func (f *Fs) CreateFolderRecursively(ctx context.Context, fullPath string) (string, error) {
+	encrypted := f.encryptPath(fullPath)
+	if encrypted == "" {
+		return f.root.NodeId, nil
+	}
+
+	return f.fs.CreateFolderRecursively(ctx, path.Join(f.rootPath, encrypted))
+}
+
+func (f *Fs) Move(ctx context.Context, nodeId string, dstParentNodeId string, dstName string) (string, error) {
+	if dstName != "" {
+		dstName = f.keys.encryptName(dstName)
+	}
+	return f.fs.Move(ctx, nodeId, dstParentNodeId, dstName)
+}
+
+func (f *Fs) Copy(ctx context.Context, nodeId string, dstParentNodeId string, dstName string) (string, error) {
+	if dstName != "" {
+		dstName = f.keys.encryptName(dstName)
+	}
+	return f.fs.Copy(ctx, nodeId, dstParentNodeId, dstName)
+}
+
+// Remove refuses to remove the root, and the nodes which can't be decrypted such as the salt file.
+func (f *Fs) Remove(ctx context.Context, nodeId string) error {
+	if nodeId == f.root.NodeId {
+		return errors.Errorf(`can't remove the root "%s"`, f.rootPath)
+	}
+	if _, err := f.Get(ctx, nodeId); err != nil {
+		return err
+	}
+	return f.fs.Remove(ctx, nodeId)
+}
+
+func (f *Fs) Update(ctx context.Context, node drive.Node) (string, error) {
+	if node.Name != "" {
+		node.Name = f.keys.encryptName(node.Name)
+	}
+	return f.fs.Update(ctx, node)
+}
+
+func (f *Fs) UpdateNode(ctx context.Context, nodeId string, update drive.NodeUpdate) (*drive.Node, error) {
+	if update.Name != nil && *update.Name != "" {
+		name := f.keys.encryptName(*update.Name)
+		update.Name = &name
+	}
+	updater, ok := f.fs.(drive.NodeUpdater)
+	if !ok {
+		return nil, errors.Wrap(drive.ErrorNotSupported, "UpdateNode")
+	}
+	node, err := updater.UpdateNode(ctx, nodeId, update)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := f.decryptNode(*node)
+	if err != nil {
+		return nil, errors.Wrapf(drive.ErrorNotFound, `"%s" %v`, nodeId, err)
+	}
+	return &decrypted, nil
+}
+
+// CreateFile encrypts in, node.Size is the size of the plain content.
+func (f *Fs) CreateFile(ctx context.Context, node drive.Node, in io.Reader) (string, error) {
+	node, err := f.encryptFile(node, in)
+	if err != nil {
+		return "", err
+	}
+	// the encrypted content is new to the server, no rapid upload
+	return f.fs.CreateFileWithProof(ctx, node, newEncrypter(f.keys, in), "", "")
+}
+
+// ReplaceFile encrypts in as CreateFile does.
+func (f *Fs) ReplaceFile(ctx context.Context, node drive.Node, in io.Reader) (string, error) {
+	replacer, ok := f.fs.(drive.Replacer)
+	if !ok {
+		return "", errors.Wrap(drive.ErrorNotSupported, "files can't be replaced")
+	}
+	node, err := f.encryptFile(node, in)
+	if err != nil {
+		return "", err
+	}
+	return replacer.ReplaceFile(ctx, node, newEncrypter(f.keys, in))
+}
+
+// encryptFile returns the node of the encrypted content of in.
+func (f *Fs) encryptFile(node drive.Node, in io.Reader) (drive.Node, error) {
+	if node.ParentId == "" || node.Name == "" {
+		return node, drive.ErrorMissingFields
+	}
+
+	if fin, ok := in.(*os.File); ok && node.LocalModified.IsZero() {
+		if fi, err := fin.Stat(); err == nil {
+			node.LocalModified = fi.ModTime()
+		}
+	}
+	node.Name = f.keys.encryptName(node.Name)
+	node.Size = EncryptedSize(node.Size)
+	return node, nil
+}
+
+// CalcProof returns an empty proof without reading in, CreateFileWithProof ignores it.
+func (f *Fs) CalcProof(fileSize int64, in io.ReaderAt) (string, error) {
+	return "", nil
+}
+
+// CreateFileWithProof ignores sha1Code and proofCode, they are computed on the plain content.
+func (f *Fs) CreateFileWithProof(ctx context.Context, node drive.Node, in io.Reader, sha1Code string, proofCode string) (string, error) {
+	return f.CreateFile(ctx, node, in)
+}
+
+// Open decrypts the content of node, a "Range" header is mapped to the encrypted chunks it covers.
+func (f *Fs) Open(ctx context.Context, node *drive.Node, headers map[string]string) (io.ReadCloser, error) {
+	if node == nil {
+		return nil, errors.New("node is nil")
+	}
+
+	others := make(map[string]string)
+	var start, end int64 = 0, node.Size - 1
+	for key, value := range headers {
+		if !strings.EqualFold(key, "Range") {
+			others[key] = value
+			continue
+		}
+
+		var err error
+		start, end, err = parseRange(value, node.Size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	first, final := start/ChunkSize, chunkCount(node.Size)-1
+	last := end / ChunkSize
+	if last > final {
+		last = final
+	}
+
+	// the header holding the file nonce is read first, unless the range starts with it
+	header := make([]byte, headerSize)
+	if first > 0 {
+		rd, err := f.fs.Open(ctx, node, withRange(others, 0, int64(headerSize)-1))
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(rd, header)
+		_ = rd.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, `failed to read the header of "%s"`, node.Name)
+		}
+	}
+
+	headers = others
+	offset := int64(headerSize) + first*sealedSize
+	if first > 0 || end < node.Size-1 {
+		from := offset
+		if first == 0 {
+			from = 0
+		}
+		headers = withRange(others, from, int64(headerSize)+(last+1)*sealedSize-1)
+	}
+	rd, err := f.fs.Open(ctx, node, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if first == 0 {
+		if _, err := io.ReadFull(rd, header); err != nil {
+			_ = rd.Close()
+			return nil, errors.Wrapf(err, `failed to read the header of "%s"`, node.Name)
+		}
+	}
+	if string(header[:len(magic)]) != magic {
+		_ = rd.Close()
+		return nil, errors.Wrapf(ErrorCorrupted, `"%s" has no header`, node.Name)
+	}
+
+	aead, err := f.keys.fileCipher(header[len(magic):])
+	if err != nil {
+		_ = rd.Close()
+		return nil, err
+	}
+	return &decrypter{
+		in:     rd,
+		aead:   aead,
+		index:  first,
+		last:   final,
+		skip:   start - first*ChunkSize,
+		remain: end - start + 1,
+		sealed: make([]byte, sealedSize),
+		buf:    make([]byte, 0, ChunkSize),
+	}, nil
+}
+
+func withRange(headers map[string]string, start int64, end int64) map[string]string {
+	h := map[string]string{"Range": "bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10)}
+	for key, value := range headers {
+		h[key] = value
+	}
+	return h
+}
+
+// parseRange parses a single "bytes=" range of a file of size bytes, it returns inclusive offsets.
+func parseRange(value string, size int64) (start int64, end int64, err error) {
+	spec := strings.TrimSpace(value)
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, 0, errors.Errorf(`unsupported range "%s"`, value)
+	}
+
+	from, to := spec[len("bytes="):], ""
+	if i := strings.Index(from, "-"); i >= 0 {
+		from, to = strings.TrimSpace(from[:i]), strings.TrimSpace(from[i+1:])
+	} else {
+		return 0, 0, errors.Errorf(`invalid range "%s"`, value)
+	}
+
+	end = size - 1
+	switch {
+	case from == "":
+		// the last bytes of the file
+		n, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errors.Errorf(`invalid range "%s"`, value)
+		}
+		start = size - n
+		if start < 0 {
+			start = 0
+		}
+	default:
+		start, err = strconv.ParseInt(from, 10, 64)
+		if err != nil || start < 0 {
+			return 0, 0, errors.Errorf(`invalid range "%s"`, value)
+		}
+		if to != "" {
+			end, err = strconv.ParseInt(to, 10, 64)
+			if err != nil || end < start {
+				return 0, 0, errors.Errorf(`invalid range "%s"`, value)
+			}
+			if end > size-1 {
+				end = size - 1
+			}
+		}
+	}
+
+	if start >= size {
+		return 0, 0, errors.Errorf(`range "%s" not satisfiable, size is %d`, value, size)
+	}
+	return start, end, nil
+}
@@ -0,0 +1,260 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFs(t *testing.T) (*Fs, *drivetest.Server) {
+	srv := drivetest.NewServer()
+	t.Cleanup(srv.Close)
+	fs, err := drive.NewFs(context.Background(), &drive.Config{RefreshToken: "token", DeviceId: "device", HttpClient: srv.Client()})
+	require.NoError(t, err)
+	cfs, err := NewFs(context.Background(), fs, Options{Passphrase: "secret", Root: "/crypt"})
+	require.NoError(t, err)
+	return cfs, srv
+}
+
+func TestSizes(t *testing.T) {
+	for _, size := range []int64{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize, 5*ChunkSize + 7} {
+		decrypted, err := DecryptedSize(EncryptedSize(size))
+		require.NoError(t, err)
+		assert.Equal(t, size, decrypted)
+	}
+
+	_, err := DecryptedSize(int64(headerSize) + 3)
+	assert.ErrorIs(t, err, ErrorCorrupted)
+}
+
+func TestNames(t *testing.T) {
+	k, err := newKeys("secret", []byte("salt"))
+	require.NoError(t, err)
+	encrypted := k.encryptName("hello world.txt")
+	assert.Equal(t, encrypted, k.encryptName("hello world.txt"), "names are encrypted deterministically")
+	assert.NotEqual(t, encrypted, k.encryptName("hello world.tx"))
+	assert.NotContains(t, encrypted, "hello")
+
+	name, err := k.decryptName(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world.txt", name)
+
+	other, err := newKeys("secret", []byte("other salt"))
+	require.NoError(t, err)
+	_, err = other.decryptName(encrypted)
+	assert.ErrorIs(t, err, ErrorNotEncrypted)
+	_, err = k.decryptName("plain.txt")
+	assert.ErrorIs(t, err, ErrorNotEncrypted)
+}
+
+func TestFs(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	p := drive.NewPathFs(fs, 0)
+	data := make([]byte, 3*ChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	_, err := p.MkdirAll(ctx, "/docs/sub")
+	require.NoError(t, err)
+	node, err := p.Create(ctx, "/docs/data.bin", bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	assert.Equal(t, "data.bin", node.Name)
+	assert.Equal(t, int64(len(data)), node.Size)
+	_, err = p.Create(ctx, "/docs/empty.txt", bytes.NewReader(nil), 0)
+	require.NoError(t, err)
+
+	// the server only sees encrypted names and content
+	crypt := srv.Lookup("/crypt")
+	require.NotNil(t, crypt)
+	docs := srv.Lookup("/crypt/" + fs.keys.encryptName("docs"))
+	require.NotNil(t, docs)
+	stored := srv.ReadAll("/crypt/" + fs.keys.encryptName("docs") + "/" + fs.keys.encryptName("data.bin"))
+	assert.Len(t, stored, int(EncryptedSize(int64(len(data)))))
+	assert.False(t, bytes.Contains(stored, data[:64]))
+	assert.Zero(t, srv.Requests("/v2/file/get_download_url"))
+
+	// plain files under the root are hidden
+	srv.Put(docs.FileId, "plain.txt", []byte("plain"))
+	nodes, err := p.ReadDir(ctx, "/docs")
+	require.NoError(t, err)
+	var names []string
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	assert.ElementsMatch(t, []string{"sub", "data.bin", "empty.txt"}, names)
+
+	found, err := fs.Search(ctx, "data.bin")
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, node.NodeId, found[0].NodeId)
+
+	// plain starred files are dropped too
+	starred := true
+	updated, err := fs.UpdateNode(ctx, node.NodeId, drive.NodeUpdate{Starred: &starred})
+	require.NoError(t, err)
+	assert.Equal(t, "data.bin", updated.Name)
+	srv.Modify(srv.Lookup("/crypt/"+fs.keys.encryptName("docs")+"/plain.txt").FileId, func(f *drivetest.File) { f.Starred = true })
+	found, err = fs.ListStarred(ctx)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "data.bin", found[0].Name)
+
+	read := func(headers map[string]string) []byte {
+		rd, err := p.OpenFile(ctx, "/docs/data.bin", headers)
+		require.NoError(t, err)
+		defer rd.Close()
+		b, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		return b
+	}
+	assert.Equal(t, data, read(nil))
+	size := len(data)
+	for _, r := range []struct{ start, end int }{
+		{0, 9}, {5, ChunkSize + 5}, {ChunkSize, 2*ChunkSize - 1}, {2*ChunkSize + 3, size - 1}, {size - 1, size - 1},
+	} {
+		assert.Equal(t, data[r.start:r.end+1], read(map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", r.start, r.end)}), r)
+	}
+	assert.Equal(t, data[ChunkSize+1:], read(map[string]string{"Range": fmt.Sprintf("bytes=%d-", ChunkSize+1)}))
+	assert.Equal(t, data[size-10:], read(map[string]string{"Range": "bytes=-10"}))
+
+	rd, err := p.OpenFile(ctx, "/docs/empty.txt", nil)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	assert.Empty(t, b)
+	require.NoError(t, rd.Close())
+
+	require.NoError(t, p.Rename(ctx, "/docs/data.bin", "/docs/sub/moved.bin"))
+	moved, err := fs.GetByPath(ctx, "/docs/sub/moved.bin", drive.FileKind)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), moved.Size)
+
+	// another passphrase sees nothing
+	other, err := NewFs(ctx, fs.fs, Options{Passphrase: "other", Root: "/crypt"})
+	require.NoError(t, err)
+	nodes, err = other.ListAll(ctx, other.root.NodeId)
+	require.NoError(t, err)
+	assert.Empty(t, nodes)
+	_, err = other.GetByPath(ctx, "/docs", drive.AnyKind)
+	assert.ErrorIs(t, err, drive.ErrorNotFound)
+}
+
+func TestSalt(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	stored := srv.ReadAll("/crypt/" + saltName)
+	require.Len(t, stored, len(saltMagic)+saltSize)
+
+	// the salt is read again by the next Fs of the root
+	reopened, err := NewFs(ctx, fs.fs, Options{Passphrase: "secret", Root: "/crypt"})
+	require.NoError(t, err)
+	assert.Equal(t, fs.keys.encryptName("a.txt"), reopened.keys.encryptName("a.txt"))
+	assert.Equal(t, stored, srv.ReadAll("/crypt/"+saltName))
+	nodes, err := reopened.ListAll(ctx, reopened.root.NodeId)
+	require.NoError(t, err)
+	assert.Empty(t, nodes, "the salt file is hidden")
+
+	// each root has its own salt
+	other, err := NewFs(ctx, fs.fs, Options{Passphrase: "secret", Root: "/other"})
+	require.NoError(t, err)
+	assert.NotEqual(t, fs.keys.encryptName("a.txt"), other.keys.encryptName("a.txt"))
+
+	srv.Put(srv.Mkdir(drivetest.RootId, "broken"), saltName, []byte("salt"))
+	_, err = NewFs(ctx, fs.fs, Options{Passphrase: "secret", Root: "/broken"})
+	assert.ErrorIs(t, err, ErrorCorrupted)
+}
+
+func TestFsRemove(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	nodeId, err := fs.CreateFile(ctx, drive.Node{ParentId: fs.root.NodeId, Name: "a.txt", Size: 1}, bytes.NewReader([]byte("a")))
+	require.NoError(t, err)
+
+	assert.Error(t, fs.Remove(ctx, fs.root.NodeId))
+	salt, err := fs.fs.GetByPath(ctx, "/crypt/"+saltName, drive.FileKind)
+	require.NoError(t, err)
+	assert.ErrorIs(t, fs.Remove(ctx, salt.NodeId), drive.ErrorNotFound)
+	assert.Len(t, srv.ReadAll("/crypt/"+saltName), len(saltMagic)+saltSize)
+
+	require.NoError(t, fs.Remove(ctx, nodeId))
+	_, err = fs.GetByPath(ctx, "/a.txt", drive.FileKind)
+	assert.ErrorIs(t, err, drive.ErrorNotFound)
+}
+
+func TestFsCorrupted(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	p := drive.NewPathFs(fs, 0)
+	data := bytes.Repeat([]byte("0123456789"), ChunkSize/5)
+	_, err := p.Create(ctx, "/a.txt", bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	// the server keeps its own copies, tampered ones are put next to the original
+	stored := srv.ReadAll("/crypt/" + fs.keys.encryptName("a.txt"))
+	require.NotEmpty(t, stored)
+	tampered := append([]byte(nil), stored...)
+	tampered[len(tampered)-1] ^= 1
+	srv.Put(fs.root.NodeId, fs.keys.encryptName("tampered.txt"), tampered)
+	// the final chunk must not be dropped
+	srv.Put(fs.root.NodeId, fs.keys.encryptName("truncated.txt"), stored[:len(stored)-sealedSize])
+
+	rd, err := p.OpenFile(ctx, "/tampered.txt", map[string]string{"Range": "bytes=0-9"})
+	require.NoError(t, err)
+	b, err := io.ReadAll(rd)
+	require.NoError(t, err, "the first chunk is intact")
+	assert.Equal(t, data[:10], b)
+
+	for _, name := range []string{"/tampered.txt", "/truncated.txt"} {
+		rd, err = p.OpenFile(ctx, name, nil)
+		require.NoError(t, err)
+		_, err = io.ReadAll(rd)
+		assert.ErrorIs(t, err, ErrorCorrupted, name)
+	}
+}
+
+func TestFsNoRapidUpload(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	data := []byte("same content")
+	srv.Put(drivetest.RootId, "plain.txt", data)
+	hash := fmt.Sprintf("%X", sha1.Sum(data))
+	_, err := fs.CreateFileWithProof(ctx, drive.Node{ParentId: fs.root.NodeId, Name: "a.txt", Size: int64(len(data))}, bytes.NewReader(data), hash, "proof")
+	require.NoError(t, err)
+	assert.NotEqual(t, data, srv.ReadAll("/crypt/"+fs.keys.encryptName("a.txt")))
+
+	_, ok := interface{}(fs).(drive.Uploader)
+	assert.False(t, ok, "the content must be encrypted as a whole by CreateFile")
+	_, ok = interface{}(fs).(drive.DownloadUrlGetter)
+	assert.False(t, ok, "the download urls would serve the encrypted content")
+}
+
+func TestFsRevisions(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := newTestFs(t)
+	p := drive.NewPathFs(fs, 0)
+	_, err := p.Create(ctx, "/config.yml", bytes.NewReader([]byte("first")), 5)
+	require.NoError(t, err)
+	node, err := p.Create(ctx, "/config.yml", bytes.NewReader([]byte("second!")), 7)
+	require.NoError(t, err)
+
+	revisions, err := fs.ListRevisions(ctx, node.NodeId)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, []int64{7, 5}, []int64{revisions[0].Size, revisions[1].Size})
+
+	rd, err := fs.Open(ctx, revisions[1].Node(*node), nil)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	assert.Equal(t, "first", string(b))
+}
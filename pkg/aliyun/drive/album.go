@@ -0,0 +1,222 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiCreateAlbum      = "https://api.aliyundrive.com/adrive/v1/album/create"
+	apiGetAlbum         = "https://api.aliyundrive.com/adrive/v1/album/get"
+	apiListAlbums       = "https://api.aliyundrive.com/adrive/v1/album/list"
+	apiUpdateAlbum      = "https://api.aliyundrive.com/adrive/v1/album/update"
+	apiDeleteAlbum      = "https://api.aliyundrive.com/adrive/v1/album/delete"
+	apiAddAlbumFiles    = "https://api.aliyundrive.com/adrive/v1/album/add_files"
+	apiDeleteAlbumFiles = "https://api.aliyundrive.com/adrive/v1/album/delete_files"
+	apiListAlbumFiles   = "https://api.aliyundrive.com/adrive/v1/album/list_files"
+)
+
+// Album groups files of the album drive, a file may belong to several albums.
+type Album struct {
+	AlbumId     string `json:"album_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	FileCount   int64  `json:"file_count"`
+	ImageCount  int64  `json:"image_count"`
+	VideoCount  int64  `json:"video_count"`
+	Owner       string `json:"owner,omitempty"`
+	Created     time.Time
+	Updated     time.Time
+}
+
+// UnmarshalJSON parses the timestamps of the album API, which are in milliseconds.
+func (album *Album) UnmarshalJSON(b []byte) error {
+	type plain Album
+	var raw struct {
+		plain
+		CreatedAt int64 `json:"created_at"`
+		UpdatedAt int64 `json:"updated_at"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*album = Album(raw.plain)
+	if raw.CreatedAt > 0 {
+		album.Created = time.Unix(0, raw.CreatedAt*int64(time.Millisecond))
+	}
+	if raw.UpdatedAt > 0 {
+		album.Updated = time.Unix(0, raw.UpdatedAt*int64(time.Millisecond))
+	}
+	return nil
+}
+
+type ListAlbums struct {
+	Items      []Album `json:"items"`
+	NextMarker string  `json:"next_marker"`
+}
+
+type driveFile struct {
+	DriveId string `json:"drive_id"`
+	FileId  string `json:"file_id"`
+}
+
+// CreateAlbum creates an album in the album drive, RenameAlbum and DeleteAlbum manage it,
+// deleting an album keeps its files.
+func (drive *Drive) CreateAlbum(ctx context.Context, name string, description string) (*Album, error) {
+	body := map[string]string{
+		"name":        name,
+		"description": description,
+	}
+	var album Album
+	err := drive.jsonRequest(ctx, "POST", apiCreateAlbum, &body, &album)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to create album "%s"`, name)
+	}
+	return &album, nil
+}
+
+func (drive *Drive) GetAlbum(ctx context.Context, albumId string) (*Album, error) {
+	body := map[string]string{
+		"album_id": albumId,
+	}
+	var album Album
+	err := drive.jsonRequest(ctx, "POST", apiGetAlbum, &body, &album)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to get album "%s"`, albumId)
+	}
+	return &album, nil
+}
+
+func (drive *Drive) ListAlbums(ctx context.Context) ([]Album, error) {
+	body := map[string]interface{}{
+		"limit":           100,
+		"order_by":        "created_at",
+		"order_direction": "DESC",
+		"marker":          "",
+	}
+	var albums []Album
+	for {
+		var result ListAlbums
+		err := drive.jsonRequest(ctx, "POST", apiListAlbums, &body, &result)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list albums")
+		}
+
+		albums = append(albums, result.Items...)
+		if result.NextMarker == "" {
+			return albums, nil
+		}
+		body["marker"] = result.NextMarker
+	}
+}
+
+// RenameAlbum renames the album and replaces its description.
+func (drive *Drive) RenameAlbum(ctx context.Context, albumId string, name string, description string) (*Album, error) {
+	body := map[string]string{
+		"album_id":    albumId,
+		"name":        name,
+		"description": description,
+	}
+	var album Album
+	err := drive.jsonRequest(ctx, "POST", apiUpdateAlbum, &body, &album)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to rename album "%s"`, albumId)
+	}
+	return &album, nil
+}
+
+// DeleteAlbum deletes the album, its files are kept in the drive.
+func (drive *Drive) DeleteAlbum(ctx context.Context, albumId string) error {
+	body := map[string]string{
+		"album_id": albumId,
+	}
+	err := drive.jsonRequest(ctx, "POST", apiDeleteAlbum, &body, nil)
+	if err != nil {
+		return errors.Wrapf(err, `failed to delete album "%s"`, albumId)
+	}
+	return nil
+}
+
+func (drive *Drive) driveFiles(nodes []Node) []driveFile {
+	files := make([]driveFile, len(nodes))
+	for i, node := range nodes {
+		files[i] = driveFile{DriveId: drive.driveId, FileId: node.NodeId}
+	}
+	return files
+}
+
+// AddAlbumFiles adds files of the drive to the album.
+func (drive *Drive) AddAlbumFiles(ctx context.Context, albumId string, nodes []Node) error {
+	body := map[string]interface{}{
+		"album_id":        albumId,
+		"drive_file_list": drive.driveFiles(nodes),
+	}
+	err := drive.jsonRequest(ctx, "POST", apiAddAlbumFiles, &body, nil)
+	if err != nil {
+		return errors.Wrapf(err, `failed to add files to album "%s"`, albumId)
+	}
+	return nil
+}
+
+// RemoveAlbumFiles removes files from the album, they are kept in the drive.
+func (drive *Drive) RemoveAlbumFiles(ctx context.Context, albumId string, nodes []Node) error {
+	body := map[string]interface{}{
+		"album_id":        albumId,
+		"drive_file_list": drive.driveFiles(nodes),
+	}
+	err := drive.jsonRequest(ctx, "POST", apiDeleteAlbumFiles, &body, nil)
+	if err != nil {
+		return errors.Wrapf(err, `failed to remove files from album "%s"`, albumId)
+	}
+	return nil
+}
+
+type albumPager struct {
+	drive  *Drive
+	param  map[string]interface{}
+	lNodes *ListNodes
+}
+
+func (p *albumPager) Next() bool {
+	return p.lNodes == nil || p.lNodes.NextMarker != ""
+}
+
+func (p *albumPager) Nodes(ctx context.Context) ([]Node, error) {
+	p.lNodes = nil
+	err := p.drive.jsonRequest(ctx, "POST", apiListAlbumFiles, &p.param, &p.lNodes)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to list album "%s"`, p.param["album_id"])
+	}
+
+	p.param["marker"] = p.lNodes.NextMarker
+	return p.lNodes.Items, nil
+}
+
+// AlbumFiles lists the files of the album, the most recent first.
+func (drive *Drive) AlbumFiles(albumId string) Pager {
+	param := map[string]interface{}{
+		"album_id":        albumId,
+		"limit":           100,
+		"order_by":        "joined_at",
+		"order_direction": "DESC",
+		"marker":          "",
+	}
+	return &albumPager{drive: drive, param: param}
+}
+
+func (drive *Drive) ListAlbumFiles(ctx context.Context, albumId string) ([]Node, error) {
+	p := drive.AlbumFiles(albumId)
+	var nodes []Node
+	for p.Next() {
+		data, err := p.Nodes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, data...)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,63 @@
+package drive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlbums(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	photo := srv.Put(drivetest.RootId, "a.jpg", []byte("jpg"))
+	video := srv.Put(drivetest.RootId, "b.mp4", []byte("mp4"))
+
+	album, err := drive.CreateAlbum(ctx, "holidays", "summer")
+	require.NoError(t, err)
+	assert.NotEmpty(t, album.AlbumId)
+	assert.Equal(t, "holidays", album.Name)
+	assert.Equal(t, "summer", album.Description)
+	assert.WithinDuration(t, time.Now(), album.Created, time.Minute)
+	other, err := drive.CreateAlbum(ctx, "work", "")
+	require.NoError(t, err)
+
+	require.NoError(t, drive.AddAlbumFiles(ctx, album.AlbumId, []Node{{NodeId: photo}}))
+	require.NoError(t, drive.AddAlbumFiles(ctx, album.AlbumId, []Node{{NodeId: video}, {NodeId: photo}}))
+	assert.Error(t, drive.AddAlbumFiles(ctx, album.AlbumId, []Node{{NodeId: drivetest.RootId}}))
+
+	album, err = drive.GetAlbum(ctx, album.AlbumId)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), album.FileCount)
+	assert.Equal(t, int64(1), album.ImageCount)
+	assert.Equal(t, int64(1), album.VideoCount)
+
+	nodes, err := drive.ListAlbumFiles(ctx, album.AlbumId)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "b.mp4", nodes[0].Name)
+	assert.Equal(t, "a.jpg", nodes[1].Name)
+
+	albums, err := drive.ListAlbums(ctx)
+	require.NoError(t, err)
+	require.Len(t, albums, 2)
+	assert.Equal(t, other.AlbumId, albums[0].AlbumId)
+
+	renamed, err := drive.RenameAlbum(ctx, album.AlbumId, "summer 2021", "")
+	require.NoError(t, err)
+	assert.Equal(t, "summer 2021", renamed.Name)
+	assert.Empty(t, renamed.Description)
+
+	require.NoError(t, drive.RemoveAlbumFiles(ctx, album.AlbumId, []Node{{NodeId: video}}))
+	assert.Equal(t, []string{photo}, srv.Album(album.AlbumId).FileIds)
+	assert.NotNil(t, srv.File(video), "removed files are kept in the drive")
+
+	require.NoError(t, drive.DeleteAlbum(ctx, album.AlbumId))
+	assert.Nil(t, srv.Album(album.AlbumId))
+	assert.NotNil(t, srv.File(photo))
+	_, err = drive.GetAlbum(ctx, album.AlbumId)
+	assert.ErrorIs(t, err, ErrorNotFound)
+}
@@ -0,0 +1,279 @@
+package drive
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const apiListDelta = "https://api.aliyundrive.com/v2/file/list_delta"
+
+// snapshotCursor prefixes the cursors of ChangeFeed when the server has no delta endpoint,
+// it is followed by the unix time in nanoseconds of the snapshot.
+const snapshotCursor = "snapshot:"
+
+type ChangeType int
+
+const (
+	ChangeCreate ChangeType = iota
+	ChangeUpdate
+	// ChangeMove is a rename or a move to another folder.
+	ChangeMove
+	// ChangeDelete is a move to the recycle bin or a deletion.
+	ChangeDelete
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeCreate:
+		return "create"
+	case ChangeUpdate:
+		return "update"
+	case ChangeMove:
+		return "move"
+	case ChangeDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Change is a change of a file or folder.
+//
+// Changing a folder is a single change, e.g. the files of a deleted folder are not reported.
+type Change struct {
+	Type   ChangeType
+	NodeId string
+	// Node is the node after the change, nil for deletions.
+	Node *Node
+}
+
+// Delta is a page of changes returned by ListDelta.
+type Delta struct {
+	Changes []Change
+	// Cursor is the position after Changes.
+	Cursor string
+	// HasMore is true if more changes are available right away.
+	HasMore bool
+}
+
+type deltaItem struct {
+	Op     string `json:"op"`
+	NodeId string `json:"file_id"`
+	Node   *Node  `json:"file"`
+}
+
+type deltaResult struct {
+	Items   []deltaItem `json:"items"`
+	Cursor  string      `json:"cursor"`
+	HasMore bool        `json:"has_more"`
+}
+
+func changeType(op string) ChangeType {
+	switch op {
+	case "create":
+		return ChangeCreate
+	case "move", "rename":
+		return ChangeMove
+	case "delete", "trash":
+		return ChangeDelete
+	}
+	// "update", "overwrite" and operations we don't know
+	return ChangeUpdate
+}
+
+// ListDelta returns the changes of the drive since cursor, an empty cursor
+// returns no changes and the current cursor.
+//
+// may return ErrorNotSupported if the server has no delta endpoint, see ChangeFeed.
+func (drive *Drive) ListDelta(ctx context.Context, cursor string) (*Delta, error) {
+	body := map[string]interface{}{
+		"drive_id": drive.driveId,
+		"cursor":   cursor,
+		"limit":    100,
+	}
+	var result deltaResult
+	err := drive.jsonRequest(ctx, "POST", apiListDelta, &body, &result)
+	if errors.Is(err, ErrorNotFound) {
+		return nil, errors.Wrapf(ErrorNotSupported, `failed to request "%s"`, apiListDelta)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to post list delta request")
+	}
+
+	delta := &Delta{Cursor: result.Cursor, HasMore: result.HasMore}
+	for _, item := range result.Items {
+		change := Change{Type: changeType(item.Op), NodeId: item.NodeId, Node: item.Node}
+		if change.Type == ChangeDelete {
+			change.Node = nil
+		} else if change.Node == nil {
+			// the item is useless without the node
+			continue
+		}
+		if change.NodeId == "" {
+			change.NodeId = change.Node.NodeId
+		}
+		delta.Changes = append(delta.Changes, change)
+	}
+	return delta, nil
+}
+
+// ChangeFeed iterates the changes of a drive since a cursor:
+//
+//	feed := NewChangeFeed(fs, cursor)
+//	for {
+//		changes, err := feed.Changes(ctx)
+//		...
+//		save(feed.Cursor())
+//		if !feed.Next() {
+//			time.Sleep(interval)
+//		}
+//	}
+//
+// Next returns false once the feed is up to date, until then Changes has more changes to return
+// right away. Cursor is to be saved, to resume with a new feed.
+//
+// Changes come from the delta endpoint of the server, when fs is a DeltaLister. Otherwise, or when
+// the endpoint isn't available, the feed falls back to diffing snapshots of the whole drive, listed
+// recursively, by NodeId and Updated:
+// snapshot cursors only hold the time of the snapshot, so resuming from one in a new feed reports
+// the nodes updated since then as ChangeUpdate, deletions made meanwhile are missed.
+// A delta cursor can't be resumed once the feed has fallen back, it restarts from now.
+//
+// A ChangeFeed must not be used concurrently.
+type ChangeFeed struct {
+	fs      Fs
+	cursor  string
+	polled  bool
+	hasMore bool
+	// snapshot is the last listing of the drive by NodeId, in fallback mode
+	snapshot map[string]Node
+}
+
+// NewChangeFeed returns the changes of fs since cursor, from now if cursor is empty.
+func NewChangeFeed(fs Fs, cursor string) *ChangeFeed {
+	return &ChangeFeed{fs: fs, cursor: cursor}
+}
+
+// Next reports whether Changes has changes to return right away.
+func (f *ChangeFeed) Next() bool {
+	return !f.polled || f.hasMore
+}
+
+// Cursor returns the position after the changes returned so far.
+func (f *ChangeFeed) Cursor() string {
+	return f.cursor
+}
+
+// Changes returns the next changes, in order.
+func (f *ChangeFeed) Changes(ctx context.Context) ([]Change, error) {
+	if !strings.HasPrefix(f.cursor, snapshotCursor) {
+		if lister, ok := f.fs.(DeltaLister); ok {
+			delta, err := lister.ListDelta(ctx, f.cursor)
+			switch {
+			case err == nil:
+				f.polled = true
+				f.cursor, f.hasMore = delta.Cursor, delta.HasMore
+				return delta.Changes, nil
+			case !errors.Is(err, ErrorNotSupported):
+				return nil, err
+			}
+		}
+		f.cursor = ""
+	}
+
+	return f.diff(ctx)
+}
+
+// diff lists the drive and compares it with the last snapshot.
+func (f *ChangeFeed) diff(ctx context.Context) ([]Change, error) {
+	now := time.Now()
+	snapshot, err := f.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+	if f.snapshot == nil && f.cursor != "" {
+		nanos, err := strconv.ParseInt(strings.TrimPrefix(f.cursor, snapshotCursor), 10, 64)
+		if err != nil {
+			return nil, errors.Errorf(`invalid change cursor "%s"`, f.cursor)
+		}
+		since = time.Unix(0, nanos)
+	}
+
+	var changes []Change
+	for _, node := range sortedSnapshot(snapshot) {
+		node := node
+		old, ok := f.snapshot[node.NodeId]
+		switch {
+		case f.snapshot == nil:
+			if updated, err := node.GetTime(); !since.IsZero() && (err != nil || updated.After(since)) {
+				changes = append(changes, Change{Type: ChangeUpdate, NodeId: node.NodeId, Node: &node})
+			}
+		case !ok:
+			changes = append(changes, Change{Type: ChangeCreate, NodeId: node.NodeId, Node: &node})
+		case old.ParentId != node.ParentId || old.Name != node.Name:
+			changes = append(changes, Change{Type: ChangeMove, NodeId: node.NodeId, Node: &node})
+		case old.Updated != node.Updated || old.Hash != node.Hash || old.Size != node.Size:
+			changes = append(changes, Change{Type: ChangeUpdate, NodeId: node.NodeId, Node: &node})
+		}
+	}
+	for _, old := range sortedSnapshot(f.snapshot) {
+		if _, ok := snapshot[old.NodeId]; ok {
+			continue
+		}
+		// only the top of a deleted tree is reported
+		if _, parentDeleted := f.snapshot[old.ParentId]; parentDeleted {
+			if _, ok := snapshot[old.ParentId]; !ok {
+				continue
+			}
+		}
+		changes = append(changes, Change{Type: ChangeDelete, NodeId: old.NodeId})
+	}
+
+	f.snapshot = snapshot
+	f.polled, f.hasMore = true, false
+	f.cursor = snapshotCursor + strconv.FormatInt(now.UnixNano(), 10)
+	return changes, nil
+}
+
+func (f *ChangeFeed) list(ctx context.Context) (map[string]Node, error) {
+	root, err := f.fs.GetByPath(ctx, "/", FolderKind)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := map[string]Node{}
+	var walk func(nodeId string) error
+	walk = func(nodeId string) error {
+		nodes, err := f.fs.ListAll(ctx, nodeId)
+		if err != nil {
+			return err
+		}
+
+		for _, node := range nodes {
+			snapshot[node.NodeId] = node
+			if node.IsDirectory() {
+				if err := walk(node.NodeId); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return snapshot, walk(root.NodeId)
+}
+
+// sortedSnapshot returns the nodes of a snapshot sorted by NodeId, for a stable order of changes.
+func sortedSnapshot(snapshot map[string]Node) []Node {
+	nodes := make([]Node, 0, len(snapshot))
+	for _, node := range snapshot {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeId < nodes[j].NodeId })
+	return nodes
+}
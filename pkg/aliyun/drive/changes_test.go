@@ -0,0 +1,149 @@
+package drive
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type changeSummary struct {
+	Type   ChangeType
+	NodeId string
+	Name   string
+}
+
+func readChanges(t *testing.T, feed *ChangeFeed) []changeSummary {
+	var summaries []changeSummary
+	for {
+		changes, err := feed.Changes(context.Background())
+		require.NoError(t, err)
+		for _, c := range changes {
+			s := changeSummary{Type: c.Type, NodeId: c.NodeId}
+			if c.Node != nil {
+				s.Name = c.Node.Name
+			}
+			summaries = append(summaries, s)
+		}
+		if !feed.Next() {
+			return summaries
+		}
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	srv.Put(drivetest.RootId, "before.txt", []byte("before"))
+
+	feed := NewChangeFeed(drive, "")
+	assert.Empty(t, readChanges(t, feed), "an empty cursor starts from now")
+
+	file := srv.Put(drivetest.RootId, "a.txt", []byte("a"))
+	dir := srv.Mkdir(drivetest.RootId, "dir")
+	_, err := drive.Move(ctx, file, dir, "a.txt")
+	require.NoError(t, err)
+	_, err = drive.Update(ctx, Node{NodeId: dir, Name: "renamed"})
+	require.NoError(t, err)
+	require.NoError(t, drive.Remove(ctx, file))
+
+	// changes hold the current node
+	assert.Equal(t, []changeSummary{
+		{ChangeCreate, file, "a.txt"},
+		{ChangeCreate, dir, "renamed"},
+		{ChangeMove, file, "a.txt"},
+		{ChangeMove, dir, "renamed"},
+		{ChangeDelete, file, ""},
+	}, readChanges(t, feed))
+	assert.Empty(t, readChanges(t, feed))
+
+	// resumed from the cursor
+	cursor := feed.Cursor()
+	other := srv.Put(dir, "b.txt", []byte("b"))
+	assert.Equal(t, []changeSummary{{ChangeCreate, other, "b.txt"}}, readChanges(t, NewChangeFeed(drive, cursor)))
+}
+
+func TestChangeFeedSnapshots(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	dir := srv.Mkdir(drivetest.RootId, "dir")
+	file := srv.Put(dir, "a.txt", []byte("a"))
+	tree := srv.Mkdir(drivetest.RootId, "tree")
+	srv.Put(tree, "b.txt", []byte("b"))
+
+	srv.Fail("/v2/file/list_delta", 404, "NotFound")
+	feed := NewChangeFeed(drive, "")
+	assert.Empty(t, readChanges(t, feed))
+	assert.True(t, strings.HasPrefix(feed.Cursor(), snapshotCursor), feed.Cursor())
+
+	created := srv.Put(drivetest.RootId, "c.txt", []byte("c"))
+	_, err := drive.Move(ctx, file, drivetest.RootId, "moved.txt")
+	require.NoError(t, err)
+	require.NoError(t, drive.Remove(ctx, tree))
+	assert.ElementsMatch(t, []changeSummary{
+		{ChangeCreate, created, "c.txt"},
+		{ChangeMove, file, "moved.txt"},
+		{ChangeDelete, tree, ""},
+	}, readChanges(t, feed), "only the top of a deleted tree is reported")
+
+	// a new feed only knows the time of the snapshot
+	cursor := feed.Cursor()
+	time.Sleep(5 * time.Millisecond)
+	_, err = drive.Update(ctx, Node{NodeId: dir, Name: "dir", Meta: "touched"})
+	require.NoError(t, err)
+	feed = NewChangeFeed(drive, cursor)
+	assert.Equal(t, []changeSummary{{ChangeUpdate, dir, "dir"}}, readChanges(t, feed))
+	assert.Empty(t, readChanges(t, feed))
+	assert.Equal(t, 1, srv.Requests("/v2/file/list_delta"), "snapshot cursors don't use the delta endpoint")
+}
+
+func TestChangeFeedWithoutDelta(t *testing.T) {
+	drive, srv := newTestDrive(t, Config{})
+	srv.Mkdir(drivetest.RootId, "dir")
+
+	// an Fs which isn't a DeltaLister
+	feed := NewChangeFeed(struct{ Fs }{drive}, "")
+	assert.Empty(t, readChanges(t, feed))
+	assert.True(t, strings.HasPrefix(feed.Cursor(), snapshotCursor), feed.Cursor())
+
+	created := srv.Put(drivetest.RootId, "a.txt", []byte("a"))
+	assert.Equal(t, []changeSummary{{ChangeCreate, created, "a.txt"}}, readChanges(t, feed))
+	assert.Equal(t, 0, srv.Requests("/v2/file/list_delta"))
+}
+
+func TestPathFsApply(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	dir := srv.Mkdir(drivetest.RootId, "dir")
+	file := srv.Put(dir, "a.txt", []byte("a"))
+	fs := NewPathFs(drive, time.Hour)
+	_, err := fs.Stat(ctx, "/dir/a.txt")
+	require.NoError(t, err)
+	_, err = fs.ReadDir(ctx, "/dir")
+	require.NoError(t, err)
+
+	feed := NewChangeFeed(drive, "")
+	readChanges(t, feed)
+	// changes made by another client
+	_, err = drive.Update(ctx, Node{NodeId: file, Name: "b.txt"})
+	require.NoError(t, err)
+	srv.Put(dir, "c.txt", []byte("c"))
+
+	_, err = fs.Stat(ctx, "/dir/a.txt")
+	require.NoError(t, err, "still cached")
+
+	changes, err := feed.Changes(ctx)
+	require.NoError(t, err)
+	fs.Apply(changes)
+	_, err = fs.Stat(ctx, "/dir/a.txt")
+	assert.ErrorIs(t, err, ErrorNotFound)
+	nodes, err := fs.ReadDir(ctx, "/dir")
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "b.txt", nodes[0].Name)
+	assert.Equal(t, "c.txt", nodes[1].Name)
+}
@@ -0,0 +1,150 @@
+package drive
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDrive(t *testing.T, config Config) (*Drive, *drivetest.Server) {
+	srv := drivetest.NewServer()
+	t.Cleanup(srv.Close)
+	config.RefreshToken = "refresh-token"
+	config.DeviceId = "device"
+	config.HttpClient = srv.Client()
+	fs, err := NewFs(context.Background(), &config)
+	require.NoError(t, err)
+	return fs.(*Drive), srv
+}
+
+// run with -race
+func TestConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	fileId := srv.Put(drivetest.RootId, "a.txt", []byte("hello"))
+	node, err := drive.Get(ctx, fileId)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	folderIds := make([]string, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%4 == 0 {
+				// force the token and the device session to be renewed concurrently
+				drive.tokenMutex.Lock()
+				drive.expireAt = 0
+				drive.tokenMutex.Unlock()
+				drive.deviceSessionMutex.Lock()
+				drive.deviceSessionExpireAt = 0
+				drive.deviceSessionMutex.Unlock()
+			}
+
+			rd, err := drive.Open(ctx, node, nil)
+			assert.NoError(t, err)
+			data, err := ioutil.ReadAll(rd)
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+			_ = rd.Close()
+
+			_, err = drive.ListAll(ctx, drivetest.RootId)
+			assert.NoError(t, err)
+
+			folderIds[i], err = drive.CreateFolderRecursively(ctx, "/x/y")
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, id := range folderIds {
+		assert.Equal(t, folderIds[0], id)
+	}
+	nodes, err := drive.ListAll(ctx, drivetest.RootId)
+	require.NoError(t, err)
+	assert.Len(t, nodes, 2)
+}
+
+func TestConnectionLimits(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{MaxDownloads: 1, RequestsPerSecond: 100, RequestBurst: 10})
+	fileId := srv.Put(drivetest.RootId, "a.txt", []byte("hello"))
+	node, err := drive.Get(ctx, fileId)
+	require.NoError(t, err)
+
+	rd, err := drive.Open(ctx, node, nil)
+	require.NoError(t, err)
+	state := drive.LimiterState()
+	assert.Equal(t, 1, state.Downloads)
+	assert.Equal(t, 1, state.MaxDownloads)
+	assert.Equal(t, 10, state.RequestBurst)
+
+	// the only download slot is taken until rd is closed
+	ctx2, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = drive.Open(ctx2, node, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, rd.Close())
+	assert.Equal(t, 0, drive.LimiterState().Downloads)
+}
+
+func TestRefreshTokenCallback(t *testing.T) {
+	ctx := context.Background()
+	var drive *Drive
+	var callbackErr error
+	drive, srv := newTestDrive(t, Config{OnRefreshToken: func(string) {
+		if drive != nil {
+			// the callback may use the drive
+			_, callbackErr = drive.Get(ctx, "root")
+		}
+	}})
+	fileId := srv.Put(drivetest.RootId, "a.txt", []byte("hello"))
+
+	drive.expireAt = 0
+	done := make(chan error, 1)
+	go func() {
+		_, err := drive.Get(ctx, fileId)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+		assert.NoError(t, callbackErr)
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnRefreshToken is called with the token lock held")
+	}
+}
+
+func TestDownloadErrors(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{MaxDownloads: 1})
+	fileId := srv.Put(drivetest.RootId, "a.txt", []byte("hello"))
+	node, err := drive.Get(ctx, fileId)
+	require.NoError(t, err)
+
+	srv.Fail("/download/"+fileId, 403, "AccessDenied")
+	_, err = drive.Open(ctx, node, nil)
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 403, apiErr.Status)
+	assert.Equal(t, 0, drive.LimiterState().Downloads, "the download slot is released")
+
+	// a server ignoring the range would send the whole content
+	ignoring := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer ignoring.Close()
+	drive.httpClient = http.DefaultClient
+	_, err = drive.openUrl(ctx, node, &DownloadUrl{Url: ignoring.URL}, map[string]string{"Range": "bytes=2-"})
+	assert.Error(t, err)
+	assert.Equal(t, 0, drive.LimiterState().Downloads)
+}
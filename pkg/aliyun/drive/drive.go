@@ -64,15 +64,14 @@ const (
 	apiListShareLink           = "https://api.aliyundrive.com/v2/share_link/list"
 	apiGetShareToken           = "https://api.aliyundrive.com/v2/share_link/get_share_token"
 	apiCancelShareLink         = "https://api.aliyundrive.com/v2/share_link/cancel"
+	apiUpdateShareLink         = "https://api.aliyundrive.com/v2/share_link/update"
 	apiGetShareLinkByAnonymous = "https://api.aliyundrive.com/v2/share_link/get_by_anonymous"
 
 	deviceSessionExpireSeconds = 300 // 5 min
-)
 
-var (
-	ErrorLivpUpload     = errors.New("uploading .livp to album is not supported")
-	ErrorAlreadyExisted = errors.New("already existed")
-	ErrorMissingFields  = errors.New("required fields: ParentId, Name")
+	// imageThumbnailProcess and videoThumbnailProcess size the Node.Thumbnail previews
+	imageThumbnailProcess = "image/resize,w_400/format,jpeg"
+	videoThumbnailProcess = "video/snapshot,t_0,f_jpg,ar_auto,w_400"
 )
 
 type Pager interface {
@@ -80,6 +79,7 @@ type Pager interface {
 	Nodes(ctx context.Context) ([]Node, error)
 }
 
+// Fs is safe for concurrent use by multiple goroutines.
 type Fs interface {
 	About(ctx context.Context) (*PersonalSpaceInfo, error)
 	Get(ctx context.Context, nodeId string) (*Node, error)
@@ -95,15 +95,20 @@ type Fs interface {
 	CreateFolder(ctx context.Context, node Node) (nodeIdOut string, err error)
 	Move(ctx context.Context, nodeId string, dstParentNodeId string, dstName string) (nodeIdOut string, err error)
 	Remove(ctx context.Context, nodeId string) error
+
+	// Open downloads a file, headers (e.g. "Range") are passed to the download request.
+	//
+	// the download url is cached in node, the same node may be opened from several goroutines.
 	Open(ctx context.Context, node *Node, headers map[string]string) (io.ReadCloser, error)
 
 	// CreateFile puts a file to aliyun drive.
 	//
 	// required Node fields: ParentId, Name.
+	// node.LocalModified defaults to the modification time of in if it is an *os.File.
 	//
 	// may return ErrorMissingFields if required fields are missing.
 	CreateFile(ctx context.Context, node Node, in io.Reader) (nodeIdOut string, err error)
-	CalcProof(fileSize int64, in *os.File) (proof string, err error)
+	CalcProof(fileSize int64, in io.ReaderAt) (proof string, err error)
 
 	// CreateFileWithProof puts a file to aliyun drive.
 	//
@@ -111,19 +116,97 @@ type Fs interface {
 	//
 	// may return ErrorMissingFields if required fields are missing.
 	CreateFileWithProof(ctx context.Context, node Node, in io.Reader, sha1Code string, proofCode string) (nodeIdOut string, err error)
+
 	Copy(ctx context.Context, nodeId string, dstParentNodeId string, dstName string) (nodeIdOut string, err error)
 	CreateFolderRecursively(ctx context.Context, fullPath string) (nodeIdOut string, err error)
+
+	// Update renames a node if node.Name isn't empty and sets its meta, and its LocalCreated and
+	// LocalModified times if not zero.
 	Update(ctx context.Context, node Node) (nodeIdOut string, err error)
 
-	CreateShareLink(ctx context.Context, node []Node, pwd string, expiresIn int64) (ShareID string, SharePwd string, Expiration string, err error)
-	ListShareLinks(ctx context.Context) (items []SharedFile, nextMarker string, err error)
-	GetShareInfo(ctx context.Context, shareID string) (ShareID string, Pwd string, Expiration string, FileIDList []string, err error)
-	GetShareToken(ctx context.Context, pwd string, shareID string) (shareToken string, error error)
-	CancelShareLink(ctx context.Context, shareID string) error
-	GetShareLinkByAnonymous(ctx context.Context, shareID string) (Expiration string, Creator string, err error)
 	Search(ctx context.Context, name string) ([]Node, error)
 }
 
+// The following interfaces are implemented by *Drive beside Fs, callers holding an Fs detect them with
+// a type assertion. Albums and the other drives of the user are only reached through a *Drive.
+
+// NodeUpdater changes the attributes of a node which Update doesn't cover.
+type NodeUpdater interface {
+	UpdateNode(ctx context.Context, nodeId string, update NodeUpdate) (*Node, error)
+}
+
+// StarredLister lists the starred nodes of the drive, see NodeUpdate.Starred.
+type StarredLister interface {
+	Starred() Pager
+	ListStarred(ctx context.Context) ([]Node, error)
+}
+
+// RevisionFs manages the previous contents of the files, see Replacer.
+type RevisionFs interface {
+	// ListRevisions returns the revisions of a file, the latest first, use Revision.Node to open one.
+	ListRevisions(ctx context.Context, nodeId string) ([]Revision, error)
+	RestoreRevision(ctx context.Context, nodeId string, revisionId string) error
+	DeleteRevision(ctx context.Context, nodeId string, revisionId string) error
+}
+
+// ShareLinker manages the share links of the user, see Drive.ShareLinks for paging and filters.
+type ShareLinker interface {
+	CreateShareLink(ctx context.Context, node []Node, pwd string, expiresIn int64) (*ShareLink, error)
+	ListShareLinks(ctx context.Context, opts ShareListOptions) ([]ShareLink, error)
+	GetShareInfo(ctx context.Context, shareID string) (*ShareLink, error)
+	GetShareToken(ctx context.Context, pwd string, shareID string) (*ShareToken, error)
+	CancelShareLink(ctx context.Context, shareID string) error
+	GetShareLinkByAnonymous(ctx context.Context, shareID string) (*ShareLink, error)
+}
+
+// Replacer overwrites files, ReplaceFile is CreateFile overwriting the file of the same name, its content
+// is kept as a revision.
+//
+// required Node fields: ParentId, Name.
+type Replacer interface {
+	ReplaceFile(ctx context.Context, node Node, in io.Reader) (nodeIdOut string, err error)
+}
+
+// Uploader uploads a file part by part, CreateUpload, UploadPart and CompleteUpload are the steps of
+// CreateFileWithProof, for callers which receive the content part by part.
+type Uploader interface {
+	CreateUpload(ctx context.Context, node Node, sha1Code string, proofCode string, partCount int) (*Upload, error)
+	// ReplaceUpload is CreateUpload overwriting the file of the same name once completed, its content is
+	// kept as a revision.
+	ReplaceUpload(ctx context.Context, node Node, sha1Code string, proofCode string, partCount int) (*Upload, error)
+	UploadPart(ctx context.Context, upload *Upload, partNumber int, in io.Reader) error
+	CompleteUpload(ctx context.Context, upload *Upload) (nodeIdOut string, err error)
+}
+
+// DownloadUrlGetter returns the signed download url of a file, cached in node until it expires.
+//
+// the url is only served to requests with the "Referer: https://www.aliyundrive.com/" header.
+type DownloadUrlGetter interface {
+	GetDownloadUrl(ctx context.Context, node *Node) (*DownloadUrl, error)
+}
+
+// LimitReporter reports the current state of the client side rate and connection limits.
+type LimitReporter interface {
+	LimiterState() LimiterState
+}
+
+// DeltaLister returns the changes of the drive since cursor, see ChangeFeed.
+type DeltaLister interface {
+	ListDelta(ctx context.Context, cursor string) (*Delta, error)
+}
+
+var (
+	_ NodeUpdater       = (*Drive)(nil)
+	_ StarredLister     = (*Drive)(nil)
+	_ RevisionFs        = (*Drive)(nil)
+	_ DeltaLister       = (*Drive)(nil)
+	_ LimitReporter     = (*Drive)(nil)
+	_ DownloadUrlGetter = (*Drive)(nil)
+	_ Uploader          = (*Drive)(nil)
+	_ Replacer          = (*Drive)(nil)
+	_ ShareLinker       = (*Drive)(nil)
+)
+
 type Config struct {
 	RefreshToken   string `json:"refresh_token"`
 	DeviceId       string `json:"device_id"`
@@ -131,26 +214,48 @@ type Config struct {
 	HttpClient     *http.Client
 	OnRefreshToken func(refreshToken string)
 	UseInternalUrl bool `json:"use_internal_url,omitempty"`
+
+	// RequestsPerSecond limits API calls made by all goroutines sharing a Drive, 0 means unlimited.
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	// RequestBurst is the number of API calls allowed to exceed RequestsPerSecond at once.
+	RequestBurst int `json:"request_burst,omitempty"`
+	// MaxUploads limits concurrent upload connections, 0 means unlimited.
+	MaxUploads int `json:"max_uploads,omitempty"`
+	// MaxDownloads limits concurrent download connections, 0 means unlimited.
+	// A connection is held until the reader returned by Open is closed.
+	MaxDownloads int `json:"max_downloads,omitempty"`
 }
 
 func (config Config) String() string {
 	return fmt.Sprintf("Config{RefreshToken: %s}", config.RefreshToken)
 }
 
+// Drive is a drive of the user, the drives of OpenDrive share the token, device session and limits
+// of the Drive they were opened from.
 type Drive struct {
-	token
-	deviceSession
-	config     Config
+	*token
+	*deviceSession
+	config     *Config
 	driveId    string
 	rootId     string
 	rootNode   Node
 	httpClient *http.Client
-	mutex      sync.Mutex
+
+	// downloadMutex guards Node.downloadUrl per node id,
+	// folderMutex serializes CreateFolderRecursively per path.
+	downloadMutex keyedMutex
+	folderMutex   keyedMutex
+
+	apiLimiter    *tokenBucket
+	uploadSlots   semaphore
+	downloadSlots semaphore
 }
 
 type token struct {
 	accessToken string
 	expireAt    int64
+	// tokenMutex guards accessToken, expireAt and config.RefreshToken
+	tokenMutex sync.Mutex
 }
 
 type deviceSession struct {
@@ -159,17 +264,8 @@ type deviceSession struct {
 	deviceSessionPrivateKey *ecdsa.PrivateKey
 	nonce                   int
 	signature               string
-	deviceSessionMutex      sync.Mutex
-}
-
-type HTTPStatusError interface {
-	error
-	StatusCode() int
-}
-
-type httpStatusError struct {
-	message    string
-	statusCode int
+	// deviceSessionMutex guards all the fields above except userId, which is set once in NewFs
+	deviceSessionMutex sync.Mutex
 }
 
 type pager struct {
@@ -178,18 +274,6 @@ type pager struct {
 	lNodes *ListNodes
 }
 
-func (err httpStatusError) Error() string {
-	return err.message
-}
-
-func (err httpStatusError) StatusCode() int {
-	return err.statusCode
-}
-
-func newHttpStatusError(message string, statusCode int) HTTPStatusError {
-	return httpStatusError{message: message, statusCode: statusCode}
-}
-
 func (p *pager) Next() bool {
 	if p.drive == nil {
 		return false
@@ -214,6 +298,8 @@ func (drive *Drive) String() string {
 
 // https://github.com/alist-org/alist/pull/3390
 // https://github.com/foxxorcat/alist/tree/fix_aliyundriver
+//
+// must be called with deviceSessionMutex held
 func (drive *Drive) sign() error {
 	if drive.deviceSessionPrivateKey == nil {
 		return errors.Errorf("failed to sign: deviceSessionPrivateKey is nil")
@@ -251,14 +337,32 @@ func (drive *Drive) request(ctx context.Context, method, url string, headers map
 	return res, nil
 }
 
+func (drive *Drive) getAccessToken() string {
+	drive.tokenMutex.Lock()
+	defer drive.tokenMutex.Unlock()
+	return drive.accessToken
+}
+
+func (drive *Drive) makeHeaders(signature string) map[string]string {
+	return map[string]string{
+		"content-type":  "application/json;charset=UTF-8",
+		"authorization": "Bearer " + drive.getAccessToken(),
+		"x-device-id":   drive.config.DeviceId,
+		"X-Signature":   signature,
+	}
+}
+
+// must not be called with deviceSessionMutex held
+func (drive *Drive) authHeaders() map[string]string {
+	drive.deviceSessionMutex.Lock()
+	signature := drive.signature
+	drive.deviceSessionMutex.Unlock()
+	return drive.makeHeaders(signature)
+}
+
 func (drive *Drive) jsonRequestNoExpireCheck(ctx context.Context, method, url string, headers map[string]string, request interface{}, response interface{}) error {
 	if headers == nil {
-		headers = map[string]string{
-			"content-type":  "application/json;charset=UTF-8",
-			"authorization": "Bearer " + drive.accessToken,
-			"x-device-id":   drive.config.DeviceId,
-			"X-Signature":   drive.signature,
-		}
+		headers = drive.authHeaders()
 	}
 
 	var bodyBytes []byte
@@ -270,6 +374,10 @@ func (drive *Drive) jsonRequestNoExpireCheck(ctx context.Context, method, url st
 		bodyBytes = b
 	}
 
+	if err := drive.apiLimiter.Wait(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
 	res, err := drive.request(ctx, method, url, headers, bytes.NewBuffer(bodyBytes))
 	if err != nil {
 		return errors.WithStack(err)
@@ -282,7 +390,7 @@ func (drive *Drive) jsonRequestNoExpireCheck(ctx context.Context, method, url st
 	}
 
 	if res.StatusCode >= 400 {
-		return newHttpStatusError(fmt.Sprintf(`failed to request "%s", got "%d", %s`, url, res.StatusCode, string(b)), res.StatusCode)
+		return newAPIError(method, url, res.StatusCode, b)
 	}
 
 	if response != nil {
@@ -295,7 +403,30 @@ func (drive *Drive) jsonRequestNoExpireCheck(ctx context.Context, method, url st
 	return nil
 }
 
+// refreshToken refreshes the access token if it has expired,
+// concurrent callers wait for a single refresh.
+// OnRefreshToken is called once the lock is released, so that it may use the drive.
 func (drive *Drive) refreshToken(ctx context.Context) error {
+	refreshToken, err := drive.refreshTokenLocked(ctx)
+	if err != nil {
+		return err
+	}
+	if refreshToken != "" && drive.config.OnRefreshToken != nil {
+		drive.config.OnRefreshToken(refreshToken)
+	}
+	return nil
+}
+
+// refreshTokenLocked refreshes the access token under tokenMutex, it returns the new
+// refresh token, empty if the access token has not expired.
+func (drive *Drive) refreshTokenLocked(ctx context.Context) (string, error) {
+	drive.tokenMutex.Lock()
+	defer drive.tokenMutex.Unlock()
+
+	if drive.expireAt >= time.Now().Unix() {
+		return "", nil
+	}
+
 	headers := map[string]string{
 		"content-type": "application/json;charset=UTF-8",
 	}
@@ -305,41 +436,39 @@ func (drive *Drive) refreshToken(ctx context.Context) error {
 	}
 	var token Token
 	if err := drive.jsonRequestNoExpireCheck(ctx, "POST", apiRefreshToken, headers, &data, &token); err != nil {
-		return err
+		return "", err
 	}
 
 	drive.accessToken = token.AccessToken
 	drive.expireAt = token.ExpiresIn + time.Now().Unix()
 	drive.config.RefreshToken = token.RefreshToken
-	if drive.config.OnRefreshToken != nil {
-		drive.config.OnRefreshToken(token.RefreshToken)
-	}
-	return nil
+	return token.RefreshToken, nil
 }
 
 func (drive *Drive) jsonRequest(ctx context.Context, method, url string, request interface{}, response interface{}) error {
 	// Token expired, refresh access
-	if drive.expireAt < time.Now().Unix() {
-		err := drive.refreshToken(ctx)
-		if err != nil {
-			return errors.WithStack(err)
-		}
+	if err := drive.refreshToken(ctx); err != nil {
+		return errors.WithStack(err)
 	}
 
-	if drive.deviceSessionExpireAt < time.Now().Unix() {
-		if err := drive.createDeviceSession(ctx); err != nil {
-			return err
-		}
+	if err := drive.createDeviceSession(ctx); err != nil {
+		return err
 	}
 
 	return drive.jsonRequestNoExpireCheck(ctx, method, url, nil, request, response)
 }
 
+// createDeviceSession creates a new device session if the current one has expired.
+//
 // https://github.com/alist-org/alist/issues/3375
 func (drive *Drive) createDeviceSession(ctx context.Context) error {
 	drive.deviceSessionMutex.Lock()
 	defer drive.deviceSessionMutex.Unlock()
 
+	if drive.deviceSessionExpireAt >= time.Now().Unix() {
+		return nil
+	}
+
 	key, err := ecdsa.GenerateKey(ecc.P256k1(), rand.Reader)
 	if err != nil {
 		return err
@@ -359,7 +488,7 @@ func (drive *Drive) createDeviceSession(ctx context.Context) error {
 		"pubKey":     s,
 	}
 	var result CreateDeviceSessionResult
-	err = drive.jsonRequestNoExpireCheck(ctx, "POST", apiCreateDeviceSession, nil, &data, &result)
+	err = drive.jsonRequestNoExpireCheck(ctx, "POST", apiCreateDeviceSession, drive.makeHeaders(drive.signature), &data, &result)
 	if err != nil {
 		return err
 	}
@@ -382,7 +511,7 @@ func (drive *Drive) renewDeviceSession(ctx context.Context) error {
 	}
 
 	var result CreateDeviceSessionResult
-	err := drive.jsonRequestNoExpireCheck(ctx, "POST", apiRenewDeviceSession, nil, map[string]string{}, &result)
+	err := drive.jsonRequestNoExpireCheck(ctx, "POST", apiRenewDeviceSession, drive.makeHeaders(drive.signature), map[string]string{}, &result)
 	if err != nil {
 		return err
 	}
@@ -396,9 +525,15 @@ func (drive *Drive) renewDeviceSession(ctx context.Context) error {
 }
 
 func NewFs(ctx context.Context, config *Config) (Fs, error) {
+	conf := *config
 	drive := &Drive{
-		config:     *config,
-		httpClient: config.HttpClient,
+		token:         &token{},
+		deviceSession: &deviceSession{},
+		config:        &conf,
+		httpClient:    config.HttpClient,
+		apiLimiter:    newTokenBucket(config.RequestsPerSecond, config.RequestBurst),
+		uploadSlots:   newSemaphore(config.MaxUploads),
+		downloadSlots: newSemaphore(config.MaxDownloads),
 	}
 
 	if drive.httpClient == nil {
@@ -425,7 +560,7 @@ func NewFs(ctx context.Context, config *Config) (Fs, error) {
 	if config.IsAlbum {
 		var albumInfo AlbumInfo
 		data := map[string]string{}
-		err := drive.jsonRequest(ctx, "POST", "https://api.aliyundrive.com/adrive/v1/user/albums_info", &data, &albumInfo)
+		err := drive.jsonRequest(ctx, "POST", apiGetAlbumsInfo, &data, &albumInfo)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to get driveId")
 		}
@@ -461,14 +596,17 @@ func (drive *Drive) findNameNode(ctx context.Context, nodeId string, name string
 		}
 	}
 
-	return nil, errors.Wrapf(os.ErrNotExist, `can't find "%s", kind: "%s" under "%s"`, name, kind, nodeId)
+	return nil, errors.Wrapf(ErrorNotFound, `can't find "%s", kind: "%s" under "%s"`, name, kind, nodeId)
 }
 
 // https://help.aliyun.com/document_detail/175927.html#h2-u83B7u53D6u6587u4EF6u6216u6587u4EF6u5939u4FE1u606F17
 func (drive *Drive) Get(ctx context.Context, nodeId string) (*Node, error) {
 	data := map[string]interface{}{
-		"drive_id": drive.driveId,
-		"file_id":  nodeId,
+		"drive_id":                drive.driveId,
+		"file_id":                 nodeId,
+		"fields":                  "*",
+		"image_thumbnail_process": imageThumbnailProcess,
+		"video_thumbnail_process": videoThumbnailProcess,
 	}
 	var node Node
 	err := drive.jsonRequest(ctx, "POST", apiGet, &data, &node)
@@ -484,12 +622,16 @@ func (drive *Drive) GetByPath(ctx context.Context, fullPath string, kind string)
 	fullPath = normalizePath(fullPath)
 
 	if fullPath == "/" || fullPath == "" {
-		return &drive.rootNode, nil
+		root := drive.rootNode
+		return &root, nil
 	}
 
 	data := map[string]interface{}{
-		"drive_id":  drive.driveId,
-		"file_path": fullPath,
+		"drive_id":                drive.driveId,
+		"file_path":               fullPath,
+		"fields":                  "*",
+		"image_thumbnail_process": imageThumbnailProcess,
+		"video_thumbnail_process": videoThumbnailProcess,
 	}
 
 	var node *Node
@@ -497,11 +639,11 @@ func (drive *Drive) GetByPath(ctx context.Context, fullPath string, kind string)
 	// paths with surrounding white spaces (like `/ test / test1 `)
 	// can't be found by `get_by_path`
 	// https://github.com/K265/aliyundrive-go/issues/3
-	if err != nil && !strings.Contains(err.Error(), `got "404"`) {
+	if err != nil && !errors.Is(err, ErrorNotFound) {
 		return nil, errors.WithStack(err)
 	}
 
-	if node != nil && node.Type == kind {
+	if node != nil && (kind == AnyKind || node.Type == kind) {
 		return node, nil
 	}
 
@@ -531,10 +673,13 @@ func (drive *Drive) About(ctx context.Context) (*PersonalSpaceInfo, error) {
 
 func (drive *Drive) List(nodeId string) Pager {
 	param := map[string]interface{}{
-		"drive_id":       drive.driveId,
-		"parent_file_id": nodeId,
-		"limit":          200,
-		"marker":         "",
+		"drive_id":                drive.driveId,
+		"parent_file_id":          nodeId,
+		"limit":                   200,
+		"marker":                  "",
+		"fields":                  "*",
+		"image_thumbnail_process": imageThumbnailProcess,
+		"video_thumbnail_process": videoThumbnailProcess,
 	}
 	p := &pager{param: param, drive: drive}
 	return p
@@ -629,15 +774,18 @@ func (drive *Drive) Remove(ctx context.Context, nodeId string) error {
 	return nil
 }
 
-func (drive *Drive) getDownloadUrl(ctx context.Context, nodeId string) (*DownloadUrl, error) {
+func (drive *Drive) getDownloadUrl(ctx context.Context, node *Node) (*DownloadUrl, error) {
 	var detail DownloadUrl
 	data := map[string]string{
 		"drive_id": drive.driveId,
-		"file_id":  nodeId,
+		"file_id":  node.NodeId,
+	}
+	if node.revisionId != "" {
+		data["revision_id"] = node.revisionId
 	}
 	err := drive.jsonRequest(ctx, "POST", apiGetDownloadUrl, &data, &detail)
 	if err != nil {
-		return nil, errors.Wrapf(err, `failed to get node detail of "%s"`, nodeId)
+		return nil, errors.Wrapf(err, `failed to get node detail of "%s"`, node.NodeId)
 	}
 	return &detail, nil
 }
@@ -657,6 +805,22 @@ func (drive *Drive) needUpdateNodeDownloadUrl(node *Node) bool {
 	return expirationTime.Before(time.Now())
 }
 
+// GetDownloadUrl returns the signed download url of a file, see DownloadUrlGetter.
+func (drive *Drive) GetDownloadUrl(ctx context.Context, node *Node) (*DownloadUrl, error) {
+	unlock := drive.downloadMutex.Lock(node.NodeId)
+	defer unlock()
+
+	if drive.needUpdateNodeDownloadUrl(node) {
+		downloadUrl, err := drive.getDownloadUrl(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		node.downloadUrl = downloadUrl
+	}
+
+	return node.downloadUrl, nil
+}
+
 func (drive *Drive) Open(ctx context.Context, node *Node, headers map[string]string) (io.ReadCloser, error) {
 	if node == nil {
 		return nil, errors.New("node is nil")
@@ -666,29 +830,55 @@ func (drive *Drive) Open(ctx context.Context, node *Node, headers map[string]str
 		return nil, errors.New("can't open folder")
 	}
 
-	drive.mutex.Lock()
-	defer drive.mutex.Unlock()
+	downloadUrl, err := drive.GetDownloadUrl(ctx, node)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
 
-	if drive.needUpdateNodeDownloadUrl(node) {
-		var err error
-		node.downloadUrl, err = drive.getDownloadUrl(ctx, node.NodeId)
-		if err != nil {
-			return nil, errors.Wrap(err, "Open")
+	return drive.openUrl(ctx, node, downloadUrl, headers)
+}
+
+// download requests url, the response must be successful, and partial if headers have a range.
+// The body of the response is closed on error.
+func (drive *Drive) download(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
+	res, err := drive.request(ctx, "GET", url, headers, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to download "%s"`, url)
+	}
+
+	if res.StatusCode >= 400 {
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, newAPIError("GET", url, res.StatusCode, b)
+	}
+	for k := range headers {
+		// a server ignoring the range would send the content from its start
+		if strings.EqualFold(k, "Range") && res.StatusCode != http.StatusPartialContent {
+			res.Body.Close()
+			return nil, errors.Errorf(`failed to download a range of "%s", got "%d"`, url, res.StatusCode)
 		}
 	}
+	return res, nil
+}
 
-	downloadUrl := node.downloadUrl
-	url := node.downloadUrl.Url
+// openUrl downloads the content of node from downloadUrl.
+func (drive *Drive) openUrl(ctx context.Context, node *Node, downloadUrl *DownloadUrl, headers map[string]string) (io.ReadCloser, error) {
+	url := downloadUrl.Url
 	if drive.config.UseInternalUrl {
 		url = downloadUrl.InternalUrl
 	}
 	if url != "" {
-		res, err := drive.request(ctx, "GET", url, headers, nil)
+		if err := drive.downloadSlots.Acquire(ctx); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		res, err := drive.download(ctx, url, headers)
 		if err != nil {
-			return nil, errors.Wrapf(err, `failed to download "%s"`, url)
+			drive.downloadSlots.Release()
+			return nil, err
 		}
 
-		return res.Body, nil
+		return &releaseOnClose{ReadCloser: res.Body, release: drive.downloadSlots.Release}, nil
 	}
 
 	// for iOS live photos (.livp)
@@ -703,16 +893,22 @@ func (drive *Drive) Open(ctx context.Context, node *Node, headers map[string]str
 				return nil, errors.Wrapf(err, `failed to creat entry "%s" in zip file`, name)
 			}
 
-			res, err := drive.request(ctx, "GET", u, headers, nil)
-			if err != nil {
-				return nil, errors.Wrapf(err, `failed to download "%s"`, u)
+			if err := drive.downloadSlots.Acquire(ctx); err != nil {
+				return nil, errors.WithStack(err)
 			}
 
-			if _, err := io.Copy(w, res.Body); err != nil {
-				return nil, errors.Wrapf(err, `failed to write "%s" to zip`, name)
+			res, err := drive.download(ctx, u, headers)
+			if err != nil {
+				drive.downloadSlots.Release()
+				return nil, err
 			}
 
+			_, err = io.Copy(w, res.Body)
 			_ = res.Body.Close()
+			drive.downloadSlots.Release()
+			if err != nil {
+				return nil, errors.Wrapf(err, `failed to write "%s" to zip`, name)
+			}
 		}
 
 		err := zw.Close()
@@ -761,21 +957,35 @@ func calcProof(accessToken string, fileSize int64, in io.ReaderAt) (string, erro
 	return proof, nil
 }
 
-func (drive *Drive) CalcProof(fileSize int64, in *os.File) (string, error) {
-	return calcProof(drive.accessToken, fileSize, in)
+func (drive *Drive) CalcProof(fileSize int64, in io.ReaderAt) (string, error) {
+	return calcProof(drive.getAccessToken(), fileSize, in)
 }
 
 func (drive *Drive) CreateFile(ctx context.Context, node Node, in io.Reader) (string, error) {
-	sha1Code := ""
-	proofCode := ""
+	in, sha1Code, proofCode := drive.proveFile(&node, in)
+	return drive.createFile(ctx, node, in, sha1Code, proofCode, CheckNameRefuse)
+}
+
+// ReplaceFile uploads in over the file of the same name, whose content is kept as a revision.
+func (drive *Drive) ReplaceFile(ctx context.Context, node Node, in io.Reader) (string, error) {
+	in, sha1Code, proofCode := drive.proveFile(&node, in)
+	return drive.createFile(ctx, node, in, sha1Code, proofCode, CheckNameOverwrite)
+}
 
+// proveFile computes the sha1 and proof codes of in if it is an *os.File, for rapid upload, and
+// defaults node.LocalModified to its modification time. in is rewound.
+func (drive *Drive) proveFile(node *Node, in io.Reader) (io.Reader, string, string) {
 	fin, ok := in.(*os.File)
-	if ok {
-		in, sha1Code, _ = CalcSha1(fin)
-		proofCode, _ = drive.CalcProof(node.Size, fin)
+	if !ok {
+		return in, "", ""
 	}
 
-	return drive.CreateFileWithProof(ctx, node, in, sha1Code, proofCode)
+	in, sha1Code, _ := CalcSha1(fin)
+	proofCode, _ := drive.CalcProof(node.Size, fin)
+	if fi, err := fin.Stat(); err == nil && node.LocalModified.IsZero() {
+		node.LocalModified = fi.ModTime()
+	}
+	return in, sha1Code, proofCode
 }
 
 func makePartInfoList(size int64) []*PartInfo {
@@ -794,81 +1004,32 @@ func makePartInfoList(size int64) []*PartInfo {
 }
 
 func (drive *Drive) CreateFileWithProof(ctx context.Context, node Node, in io.Reader, sha1Code string, proofCode string) (string, error) {
-	if err := createCheck(node); err != nil {
-		return "", err
-	}
+	return drive.createFile(ctx, node, in, sha1Code, proofCode, CheckNameRefuse)
+}
 
-	if strings.HasSuffix(strings.ToLower(node.Name), ".livp") {
-		return "", ErrorLivpUpload
+func (drive *Drive) createFile(ctx context.Context, node Node, in io.Reader, sha1Code string, proofCode string, checkNameMode string) (string, error) {
+	partInfoList := makePartInfoList(node.Size)
+	upload, err := drive.createUpload(ctx, node, sha1Code, proofCode, len(partInfoList), checkNameMode)
+	if err != nil {
+		return "", err
 	}
 
-	var proofResult ProofResult
-
-	proof := &FileProof{
-		DriveID:         drive.driveId,
-		PartInfoList:    makePartInfoList(node.Size),
-		ParentFileID:    node.ParentId,
-		Name:            node.Name,
-		Type:            "file",
-		CheckNameMode:   "refuse",
-		Size:            node.Size,
-		ContentHash:     sha1Code,
-		ContentHashName: "sha1",
-		ProofCode:       proofCode,
-		ProofVersion:    "v1",
-		Meta:            node.Meta,
+	if upload.RapidUpload {
+		return upload.FileId, nil
 	}
 
-	{
-		err := drive.jsonRequest(ctx, "POST", apiCreateFileWithProof, proof, &proofResult)
-		if err != nil {
-			return "", errors.Wrap(err, `failed to post create file request`)
-		}
-
-		if proofResult.RapidUpload {
-			return proofResult.FileId, nil
-		}
-
-		if proofResult.Exist {
-			return "", ErrorAlreadyExisted
-		}
-
-		if len(proofResult.PartInfoList) < 1 {
-			return "", errors.New(`failed to extract uploadUrl`)
-		}
+	if len(upload.parts) < 1 {
+		return "", errors.New(`failed to extract uploadUrl`)
 	}
 
-	for _, part := range proofResult.PartInfoList {
+	for _, part := range partInfoList {
 		partReader := io.LimitReader(in, MaxPartSize)
-		uploadUrl := part.UploadUrl
-		if drive.config.UseInternalUrl {
-			uploadUrl = part.InternalUploadURL
-		}
-		req, err := http.NewRequestWithContext(ctx, "PUT", uploadUrl, partReader)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to create upload request")
-		}
-		resp, err := drive.httpClient.Do(req)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to upload file")
+		if err := drive.UploadPart(ctx, upload, part.PartNumber, partReader); err != nil {
+			return "", err
 		}
-		resp.Body.Close()
 	}
 
-	var result NodeId
-	{
-		body := map[string]interface{}{
-			"drive_id":  drive.driveId,
-			"file_id":   proofResult.FileId,
-			"upload_id": proofResult.UploadId,
-		}
-
-		err := drive.jsonRequest(ctx, "POST", apiCompleteUpload, &body, &result)
-		if err != nil {
-			return "", errors.Wrap(err, `failed to post upload complete request`)
-		}
-	}
-	return result.NodeId, nil
+	return drive.CompleteUpload(ctx, upload)
 }
 
 // https://help.aliyun.com/document_detail/175927.html#pdscopyfilerequest
@@ -889,8 +1050,8 @@ func (drive *Drive) Copy(ctx context.Context, nodeId string, dstParentNodeId str
 }
 
 func (drive *Drive) createFolderInternal(ctx context.Context, parent string, name string) (string, error) {
-	drive.mutex.Lock()
-	defer drive.mutex.Unlock()
+	unlock := drive.folderMutex.Lock(parent + "/" + name)
+	defer unlock()
 
 	node, err := drive.GetByPath(ctx, parent+"/"+name, FolderKind)
 	if err == nil {
@@ -941,131 +1102,6 @@ func (drive *Drive) CreateFolderRecursively(ctx context.Context, fullPath string
 	return nodeId, nil
 }
 
-func (drive *Drive) Update(ctx context.Context, node Node) (string, error) {
-	body := map[string]string{
-		"drive_id": drive.driveId,
-		"file_id":  node.NodeId,
-		// "check_name_mode": "refuse",
-		"name": node.Name,
-		"meta": node.Meta,
-	}
-	var result NodeId
-	err := drive.jsonRequest(ctx, "POST", apiUpdate, &body, &result)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to post update request")
-	}
-	return result.NodeId, nil
-}
-
-// create share link, para: ctx, file node list, pwd, expiresIn(second)
-// return shareID, pwd, expiration
-func (drive *Drive) CreateShareLink(ctx context.Context, node []Node, pwd string, expiresIn int64) (string, string, string, error) {
-	var NodeIDs []string
-	for _, node := range node {
-		NodeIDs = append(NodeIDs, node.NodeId)
-	}
-	expiration := time.Unix(time.Now().Unix()+expiresIn, 0).Format(time.RFC3339Nano)
-	expiration = expiration[0:19] + ".999Z"
-
-	body := map[string]interface{}{
-		"share_pwd":    pwd,
-		"drive_id":     drive.driveId,
-		"file_id_list": NodeIDs,
-		"expiration":   expiration,
-		// expiration: A RFC3339 style: 2022-07-02T15:04:05.923Z,
-	}
-	var result SharedFile
-	err := drive.jsonRequest(ctx, "POST", apiCreateShareLink, &body, &result)
-	if err != nil {
-		return "", "", "", errors.Wrap(err, "failed to post create share link request")
-	}
-	return result.ShareID, result.Pwd, result.Expiration, nil
-}
-
-// get share info by shareID
-// return value may vary
-func (drive *Drive) GetShareInfo(ctx context.Context, shareID string) (string, string, string, []string, error) {
-	body := map[string]string{
-		"share_id": shareID,
-	}
-	var result SharedFile
-	err := drive.jsonRequest(ctx, "POST", apiGetShareLinkByShareID, &body, &result)
-	if err != nil {
-		return "", "", "", []string{}, errors.Wrap(err, "failed to get share info by shareID")
-	}
-	return result.ShareID, result.Pwd, result.Expiration, result.FileIDList[:], nil
-}
-
-// get share_token using pwd and shareID
-// How to use share_token: https://help.aliyun.com/document_detail/397603.html
-func (drive *Drive) GetShareToken(ctx context.Context, pwd string, shareID string) (shareToken string, error error) {
-	body := make(map[string]string)
-	if pwd == "" {
-		body = map[string]string{
-			"share_id": shareID,
-		}
-	} else {
-		body = map[string]string{
-			"share_id":  shareID,
-			"share_pwd": pwd,
-		}
-	}
-	var result ShareToken
-	err := drive.jsonRequest(ctx, "POST", apiGetShareToken, &body, &result)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to get share_token")
-	}
-	return result.shareToken, nil
-}
-
-// cancel shareLink by shareID
-func (drive *Drive) CancelShareLink(ctx context.Context, shareID string) error {
-	body := map[string]string{
-		"share_id": shareID,
-	}
-	err := drive.jsonRequest(ctx, "POST", apiCancelShareLink, &body, nil) // No need to parse json response, so nil
-	if err != nil {
-		return errors.Wrap(err, "failed to post cancel share link request")
-	}
-	return nil
-}
-
-func (drive *Drive) ListShareLinks(ctx context.Context) ([]SharedFile, string, error) {
-	body := map[string]interface{}{
-		"limit":             200,
-		"order_by":          "share_name",
-		"order_direction":   "ASC",
-		"include_cancelled": false,
-	}
-	var sharedFiles []SharedFile
-	var lSharedFiles *ListSharedFile
-	for {
-		if lSharedFiles != nil && lSharedFiles.NextMarker == "" {
-			break
-		}
-		err := drive.jsonRequest(ctx, "POST", apiListShareLink, &body, &lSharedFiles)
-		if err != nil {
-			return nil, "", errors.Wrap(err, "failed to get share links")
-		}
-		sharedFiles = append(sharedFiles, lSharedFiles.Items...)
-		body["marker"] = lSharedFiles.NextMarker
-	}
-
-	return sharedFiles, lSharedFiles.NextMarker, nil
-}
-
-func (drive *Drive) GetShareLinkByAnonymous(ctx context.Context, shareID string) (Expiration string, Creator string, err error) {
-	body := map[string]string{
-		"share_id": shareID,
-	}
-	var result SharedFile
-	errs := drive.jsonRequest(ctx, "POST", apiGetShareLinkByAnonymous, &body, &result)
-	if errs != nil {
-		return "", "", errors.Wrap(err, "failed to get share link by shareID")
-	}
-	return result.Expiration, result.Creator, nil
-}
-
 func (drive *Drive) Search(ctx context.Context, name string) ([]Node, error) {
 	body := map[string]interface{}{
 		"drive_id": drive.driveId,
@@ -50,24 +50,26 @@ func TestIntegration(t *testing.T) {
 		require.NoError(t, err)
 		fmt.Printf("node: %s\n", node)
 
-		shareID, sharePwd, expiration, err := fs.CreateShareLink(ctx, []Node{*node}, "1234", Hour*24)
+		drive := fs.(ShareLinker)
+		link, err := drive.CreateShareLink(ctx, []Node{*node}, "1234", Hour*24)
 		require.NoError(t, err)
-		fmt.Printf("shareID: %s; sharePwd: %s; expire at: %s\n", shareID, sharePwd, expiration)
-		shareToken, err := fs.GetShareToken(ctx, sharePwd, shareID)
-		fmt.Printf("shareToken: %s", shareToken)
+		shareID := link.ShareId
+		fmt.Printf("shareID: %s; sharePwd: %s; expire at: %s\n", shareID, link.Password, link.Expiration)
+		shareToken, err := drive.GetShareToken(ctx, link.Password, shareID)
 		require.NoError(t, err)
-		_, _, _, fileID, err := fs.GetShareInfo(ctx, shareID)
+		fmt.Printf("shareToken: %s", shareToken.Token)
+		shareInfo, err := drive.GetShareInfo(ctx, shareID)
 		require.NoError(t, err)
-		fmt.Println(fileID)
-		Expiration, Creator, err := fs.GetShareLinkByAnonymous(ctx, shareID)
+		fmt.Println(shareInfo.FileIdList)
+		anonymous, err := drive.GetShareLinkByAnonymous(ctx, shareID)
 		require.NoError(t, err)
-		fmt.Printf("Expiration: %s; Creator: %s", Expiration, Creator)
+		fmt.Printf("Expiration: %s; Creator: %s", anonymous.Expiration, anonymous.CreatorName)
 		time.Sleep(5 * time.Second)
-		SharedFile, nextMarker, err := fs.ListShareLinks(ctx)
+		shareLinks, err := drive.ListShareLinks(ctx, ShareListOptions{})
 		require.NoError(t, err)
-		fmt.Printf("SharedFileList: %v; nextMarker: %s\n", SharedFile, nextMarker)
+		fmt.Printf("ShareLinks: %v\n", shareLinks)
 		defer func() {
-			err := fs.CancelShareLink(ctx, shareID)
+			err := drive.CancelShareLink(ctx, shareID)
 			require.NoError(t, err)
 		}()
 
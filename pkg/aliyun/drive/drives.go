@@ -0,0 +1,160 @@
+package drive
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiListMyDrives  = "https://api.aliyundrive.com/v2/drive/list_my_drives"
+	apiGetAlbumsInfo = "https://api.aliyundrive.com/adrive/v1/user/albums_info"
+)
+
+// The drive names accepted by OpenDrive besides drive ids.
+const (
+	DefaultDrive  = "default"
+	ResourceDrive = "resource"
+	BackupDrive   = "backup"
+	AlbumDrive    = "album"
+)
+
+// DriveInfo is a drive of the user, see Drive.ListDrives.
+type DriveInfo struct {
+	DriveId   string `json:"drive_id"`
+	Name      string `json:"drive_name"`
+	Type      string `json:"drive_type"`
+	Category  string `json:"category,omitempty"` // resource | backup | empty for the default drive
+	Owner     string `json:"owner"`
+	Status    string `json:"status"`
+	TotalSize int64  `json:"total_size"`
+	UsedSize  int64  `json:"used_size"`
+}
+
+type ListDrives struct {
+	Items      []DriveInfo `json:"items"`
+	NextMarker string      `json:"next_marker"`
+}
+
+// DriveId returns the id of the drive.
+func (drive *Drive) DriveId() string {
+	return drive.driveId
+}
+
+// ListDrives returns the drives of the user, OpenDrive opens one of them with the same session.
+func (drive *Drive) ListDrives(ctx context.Context) ([]DriveInfo, error) {
+	body := map[string]interface{}{
+		"limit":  100,
+		"marker": "",
+	}
+	var drives []DriveInfo
+	for {
+		var result ListDrives
+		err := drive.jsonRequest(ctx, "POST", apiListMyDrives, &body, &result)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list drives")
+		}
+
+		drives = append(drives, result.Items...)
+		if result.NextMarker == "" {
+			return drives, nil
+		}
+		body["marker"] = result.NextMarker
+	}
+}
+
+// resolveDriveId returns the id of the drive named name, or name itself if it isn't a drive name.
+func (drive *Drive) resolveDriveId(ctx context.Context, name string) (string, error) {
+	if name == AlbumDrive {
+		var albumInfo AlbumInfo
+		data := map[string]string{}
+		err := drive.jsonRequest(ctx, "POST", apiGetAlbumsInfo, &data, &albumInfo)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get driveId")
+		}
+		return albumInfo.Data.DriveId, nil
+	}
+	if name != DefaultDrive && name != ResourceDrive && name != BackupDrive {
+		return name, nil
+	}
+
+	var user User
+	data := map[string]string{}
+	err := drive.jsonRequest(ctx, "POST", apiUserGet, &data, &user)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get driveId")
+	}
+	driveId := map[string]string{
+		DefaultDrive:  user.DriveId,
+		ResourceDrive: user.ResourceDriveId,
+		BackupDrive:   user.BackupDriveId,
+	}[name]
+	if driveId == "" {
+		return "", errors.Wrapf(ErrorNotFound, `the user has no %s drive`, name)
+	}
+	return driveId, nil
+}
+
+// OpenDrive returns the drive driveId of the user, driveId is a drive id or one of the DefaultDrive,
+// ResourceDrive, BackupDrive and AlbumDrive names.
+//
+// The returned Drive shares the token, device session and limits of drive, drive ids are not
+// checked: the calls of a Drive opened with an unknown id fail.
+func (drive *Drive) OpenDrive(ctx context.Context, driveId string) (*Drive, error) {
+	driveId, err := drive.resolveDriveId(ctx, driveId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Drive{
+		token:         drive.token,
+		deviceSession: drive.deviceSession,
+		config:        drive.config,
+		driveId:       driveId,
+		rootId:        drive.rootId,
+		rootNode:      drive.rootNode,
+		httpClient:    drive.httpClient,
+		apiLimiter:    drive.apiLimiter,
+		uploadSlots:   drive.uploadSlots,
+		downloadSlots: drive.downloadSlots,
+	}, nil
+}
+
+// CopyToDrive copies a file or folder to the folder dstParentNodeId of the drive dstDriveId, on the server side.
+func (drive *Drive) CopyToDrive(ctx context.Context, nodeId string, dstDriveId string, dstParentNodeId string, dstName string) (string, error) {
+	body := map[string]string{
+		"drive_id":          drive.driveId,
+		"file_id":           nodeId,
+		"to_drive_id":       dstDriveId,
+		"to_parent_file_id": dstParentNodeId,
+		"new_name":          dstName,
+	}
+	var result NodeId
+	err := drive.jsonRequest(ctx, "POST", apiCopy, &body, &result)
+	if err != nil {
+		return "", errors.Wrapf(err, `failed to copy "%s" to drive "%s"`, nodeId, dstDriveId)
+	}
+	return result.NodeId, nil
+}
+
+// MoveToDrive moves a file or folder to the folder dstParentNodeId of the drive dstDriveId,
+// the API only moves between the drives of the same user.
+func (drive *Drive) MoveToDrive(ctx context.Context, nodeId string, dstDriveId string, dstParentNodeId string, dstName string) (string, error) {
+	if err := drive.checkRoot(nodeId); err != nil {
+		return "", err
+	}
+
+	body := map[string]string{
+		"drive_id":          drive.driveId,
+		"file_id":           nodeId,
+		"to_drive_id":       dstDriveId,
+		"to_parent_file_id": dstParentNodeId,
+		"new_name":          dstName,
+	}
+	var result NodeId
+	err := drive.jsonRequest(ctx, "POST", apiMove, &body, &result)
+	if err != nil {
+		return "", errors.Wrapf(err, `failed to move "%s" to drive "%s"`, nodeId, dstDriveId)
+	}
+	return result.NodeId, nil
+}
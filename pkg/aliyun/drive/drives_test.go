@@ -0,0 +1,75 @@
+package drive
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrives(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	srv.Put(drivetest.RootId, "a.txt", []byte("default"))
+	srv.Put(drivetest.RootOf(drivetest.ResourceDriveId), "a.txt", []byte("resource"))
+
+	drives, err := drive.ListDrives(ctx)
+	require.NoError(t, err)
+	require.Len(t, drives, 3)
+	assert.Equal(t, drivetest.DriveId, drives[0].DriveId)
+	assert.Equal(t, "resource", drives[1].Category)
+	assert.Equal(t, int64(len("resource")), drives[1].UsedSize)
+
+	resource, err := drive.OpenDrive(ctx, ResourceDrive)
+	require.NoError(t, err)
+	assert.Equal(t, drivetest.ResourceDriveId, resource.DriveId())
+	backup, err := drive.OpenDrive(ctx, drivetest.BackupDriveId)
+	require.NoError(t, err)
+	assert.Equal(t, drivetest.BackupDriveId, backup.DriveId())
+	album, err := drive.OpenDrive(ctx, AlbumDrive)
+	require.NoError(t, err)
+	assert.Equal(t, drivetest.AlbumDriveId, album.DriveId())
+
+	// every drive has its own root
+	node, err := resource.GetByPath(ctx, "/a.txt", FileKind)
+	require.NoError(t, err)
+	assert.Equal(t, "root", node.ParentId)
+	rd, err := resource.Open(ctx, node, nil)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	rd.Close()
+	assert.Equal(t, "resource", string(data))
+	_, err = backup.GetByPath(ctx, "/a.txt", FileKind)
+	assert.ErrorIs(t, err, ErrorNotFound)
+
+	// the drives share the session of drive
+	requests := srv.Requests("/v2/account/token")
+	_, err = backup.CreateFolderRecursively(ctx, "/photos")
+	require.NoError(t, err)
+	assert.Equal(t, requests, srv.Requests("/v2/account/token"))
+
+	folder, err := backup.GetByPath(ctx, "/photos", FolderKind)
+	require.NoError(t, err)
+	copyId, err := resource.CopyToDrive(ctx, node.NodeId, drivetest.BackupDriveId, folder.NodeId, "")
+	require.NoError(t, err)
+	assert.Equal(t, drivetest.BackupDriveId, srv.File(copyId).DriveId)
+	assert.Equal(t, []byte("resource"), srv.File(copyId).Data)
+
+	defaultNode, err := drive.GetByPath(ctx, "/a.txt", FileKind)
+	require.NoError(t, err)
+	_, err = drive.MoveToDrive(ctx, defaultNode.NodeId, drivetest.BackupDriveId, "root", "b.txt")
+	require.NoError(t, err)
+	moved, err := backup.GetByPath(ctx, "/b.txt", FileKind)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("default")), moved.Size)
+	_, err = drive.GetByPath(ctx, "/a.txt", FileKind)
+	assert.ErrorIs(t, err, ErrorNotFound)
+
+	// the source drive must hold the file
+	_, err = backup.CopyToDrive(ctx, node.NodeId, drivetest.DriveId, "root", "")
+	assert.ErrorIs(t, err, ErrorNotFound)
+}
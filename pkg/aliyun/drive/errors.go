@@ -0,0 +1,103 @@
+package drive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrorLivpUpload     = errors.New("uploading .livp to album is not supported")
+	ErrorAlreadyExisted = errors.New("already existed")
+	ErrorMissingFields  = errors.New("required fields: ParentId, Name")
+	ErrorNotRapid       = errors.New("content not found on the server, rapid upload refused")
+	ErrorNotSupported   = errors.New("not supported by the server")
+
+	// ErrorNotFound, ErrorForbidden and ErrorReadOnly also match os.ErrNotExist and os.ErrPermission with errors.Is.
+	ErrorNotFound      = errors.Wrap(os.ErrNotExist, "not found")
+	ErrorForbidden     = errors.Wrap(os.ErrPermission, "forbidden")
+	ErrorReadOnly      = errors.Wrap(os.ErrPermission, "read-only")
+	ErrorQuotaExceeded = errors.New("quota exceeded")
+	ErrorTokenExpired  = errors.New("token expired")
+	ErrorRateLimited   = errors.New("rate limited")
+	ErrorShareExpired  = errors.New("share link expired")
+)
+
+type HTTPStatusError interface {
+	error
+	StatusCode() int
+}
+
+// APIError is returned when aliyun drive answers with an HTTP error status.
+//
+// Known error codes unwrap to one of the Error* sentinels, so callers can use
+// errors.Is(err, ErrorNotFound) instead of matching on messages.
+type APIError struct {
+	Method  string `json:"-"`
+	Url     string `json:"-"`
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Body is the raw response body, kept for codes we don't understand
+	Body string `json:"-"`
+}
+
+func newAPIError(method string, url string, statusCode int, body []byte) *APIError {
+	err := &APIError{
+		Method: method,
+		Url:    url,
+		Status: statusCode,
+		Body:   string(body),
+	}
+	// the body is not always JSON (e.g. errors from a proxy), keep the raw body then
+	_ = json.Unmarshal(body, err)
+	return err
+}
+
+func (err *APIError) Error() string {
+	return fmt.Sprintf(`failed to request "%s", got "%d", %s`, err.Url, err.Status, err.Body)
+}
+
+func (err *APIError) StatusCode() int {
+	return err.Status
+}
+
+// Unwrap returns the sentinel error matching the error code, or nil.
+func (err *APIError) Unwrap() error {
+	code := err.Code
+	switch {
+	case strings.HasPrefix(code, "NotFound"):
+		return ErrorNotFound
+	case strings.HasPrefix(code, "AlreadyExist"):
+		return ErrorAlreadyExisted
+	case strings.HasPrefix(code, "QuotaExhausted"):
+		return ErrorQuotaExceeded
+	case code == "AccessTokenInvalid" || code == "AccessTokenExpired" || code == "InvalidParameter.RefreshToken":
+		return ErrorTokenExpired
+	case code == "TooManyRequests":
+		return ErrorRateLimited
+	case code == "ShareLink.Expired" || code == "ShareLink.Cancelled":
+		return ErrorShareExpired
+	case strings.HasPrefix(code, "Forbidden") || code == "ShareLink.Forbidden":
+		return ErrorForbidden
+	}
+
+	// fall back to the status code for unknown or missing codes
+	switch err.Status {
+	case 401:
+		return ErrorTokenExpired
+	case 403:
+		return ErrorForbidden
+	case 404:
+		return ErrorNotFound
+	case 409:
+		return ErrorAlreadyExisted
+	case 429:
+		return ErrorRateLimited
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package drive
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIError(t *testing.T) {
+	body := []byte(`{"code":"NotFound.File","message":"The resource file cannot be found. file not exist"}`)
+	var err error = newAPIError("POST", apiGet, 404, body)
+	err = errors.Wrap(err, "failed to get node")
+
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "NotFound.File", apiErr.Code)
+	assert.Equal(t, "The resource file cannot be found. file not exist", apiErr.Message)
+	assert.True(t, errors.Is(err, ErrorNotFound))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.False(t, errors.Is(err, ErrorForbidden))
+
+	var statusErr HTTPStatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, 404, statusErr.StatusCode())
+
+	cases := map[string]error{
+		`{"code":"AlreadyExist.File"}`:            ErrorAlreadyExisted,
+		`{"code":"QuotaExhausted.Drive"}`:         ErrorQuotaExceeded,
+		`{"code":"AccessTokenInvalid"}`:           ErrorTokenExpired,
+		`{"code":"TooManyRequests"}`:              ErrorRateLimited,
+		`{"code":"ShareLink.Expired"}`:            ErrorShareExpired,
+		`{"code":"ForbiddenFileInTheRecycleBin"}`: ErrorForbidden,
+	}
+	for b, target := range cases {
+		assert.ErrorIs(t, newAPIError("POST", apiGet, 400, []byte(b)), target, b)
+	}
+
+	// unknown codes and non JSON bodies fall back to the status code
+	assert.ErrorIs(t, newAPIError("GET", apiGet, 429, []byte("<html></html>")), ErrorRateLimited)
+	assert.Nil(t, newAPIError("GET", apiGet, 500, nil).Unwrap())
+}
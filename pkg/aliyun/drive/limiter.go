@@ -0,0 +1,169 @@
+package drive
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// LimiterState is a snapshot of the client side limits of a Drive, useful for diagnostics.
+type LimiterState struct {
+	// RequestsPerSecond and RequestBurst are zero if API calls are not limited.
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	RequestBurst      int     `json:"request_burst"`
+	// Tokens is the number of API calls that can be made right now without waiting.
+	Tokens float64 `json:"tokens"`
+	// Waiting is the number of API calls currently blocked by the limiter.
+	Waiting int `json:"waiting"`
+
+	Uploads      int `json:"uploads"`
+	MaxUploads   int `json:"max_uploads"`
+	Downloads    int `json:"downloads"`
+	MaxDownloads int `json:"max_downloads"`
+}
+
+// tokenBucket is a token bucket rate limiter, a nil *tokenBucket never blocks.
+type tokenBucket struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	waiting int
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// advance must be called with b.mutex held
+func (b *tokenBucket) advance(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	b.advance(time.Now())
+	// reserve a token, going into debt if necessary, so that waiters are served in order
+	b.tokens--
+	if b.tokens >= 0 {
+		b.mutex.Unlock()
+		return nil
+	}
+
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.waiting++
+	b.mutex.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		b.mutex.Lock()
+		b.waiting--
+		b.mutex.Unlock()
+		return nil
+	case <-ctx.Done():
+		b.mutex.Lock()
+		b.waiting--
+		b.tokens++
+		b.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (b *tokenBucket) state() (rate float64, burst int, tokens float64, waiting int) {
+	if b == nil {
+		return 0, 0, 0, 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.advance(time.Now())
+	return b.rate, int(b.burst), b.tokens, b.waiting
+}
+
+// semaphore limits concurrent connections, a nil semaphore never blocks.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(semaphore, n)
+}
+
+func (s semaphore) Acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) Release() {
+	if s == nil {
+		return
+	}
+
+	<-s
+}
+
+// releaseOnClose releases a connection slot when the body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// LimiterState reports the current state of the client side rate and connection limits.
+func (drive *Drive) LimiterState() LimiterState {
+	rate, burst, tokens, waiting := drive.apiLimiter.state()
+	return LimiterState{
+		RequestsPerSecond: rate,
+		RequestBurst:      burst,
+		Tokens:            tokens,
+		Waiting:           waiting,
+		Uploads:           len(drive.uploadSlots),
+		MaxUploads:        cap(drive.uploadSlots),
+		Downloads:         len(drive.downloadSlots),
+		MaxDownloads:      cap(drive.downloadSlots),
+	}
+}
@@ -0,0 +1,47 @@
+package drive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	b := newTokenBucket(20, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, b.Wait(ctx))
+	}
+	// 2 calls from the burst, 2 more at 20/s
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(90*time.Millisecond))
+
+	ctx2, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	b2 := newTokenBucket(0.1, 1)
+	require.NoError(t, b2.Wait(ctx2))
+	assert.ErrorIs(t, b2.Wait(ctx2), context.DeadlineExceeded)
+	_, _, _, waiting := b2.state()
+	assert.Equal(t, 0, waiting)
+
+	var unlimited *tokenBucket
+	assert.NoError(t, unlimited.Wait(ctx))
+}
+
+func TestSemaphore(t *testing.T) {
+	ctx := context.Background()
+	s := newSemaphore(1)
+	require.NoError(t, s.Acquire(ctx))
+	ctx2, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Acquire(ctx2), context.DeadlineExceeded)
+	s.Release()
+	assert.NoError(t, s.Acquire(ctx))
+
+	var unlimited semaphore
+	assert.NoError(t, unlimited.Acquire(ctx))
+	unlimited.Release()
+}
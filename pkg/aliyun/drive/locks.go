@@ -0,0 +1,41 @@
+package drive
+
+import "sync"
+
+// keyedMutex is a set of mutexes keyed by string, so that unrelated keys don't block one another.
+// The zero value is ready to use.
+type keyedMutex struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks key and returns the function to unlock it.
+func (m *keyedMutex) Lock(key string) (unlock func()) {
+	m.mutex.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyedLock)
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mutex.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mutex.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mutex.Unlock()
+	}
+}
@@ -1,8 +1,12 @@
 package drive
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 type NodeId struct {
@@ -10,16 +14,149 @@ type NodeId struct {
 }
 
 type Node struct {
-	Url         string `json:"url,omitempty"`
-	Type        string `json:"type"`                   // folder | file
-	Hash        string `json:"content_hash,omitempty"` // sha1
-	Name        string `json:"name"`
-	NodeId      string `json:"file_id"`
-	ParentId    string `json:"parent_file_id,omitempty"`
-	Size        int64  `json:"size,omitempty"`
-	Updated     string `json:"updated_at"`
-	Meta        string `json:"meta,omitempty"`
+	Url      string `json:"url,omitempty"`
+	Type     string `json:"type"`                   // folder | file
+	Hash     string `json:"content_hash,omitempty"` // sha1
+	Name     string `json:"name"`
+	NodeId   string `json:"file_id"`
+	ParentId string `json:"parent_file_id,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Updated  string `json:"updated_at"`
+	Meta     string `json:"meta,omitempty"`
+
+	Created time.Time `json:"created_at"`
+	// LocalCreated and LocalModified are the times of the uploaded local file, zero if unknown.
+	// They are sent by CreateFile, CreateFileWithProof and Update when set.
+	LocalCreated  time.Time `json:"local_created_at"`
+	LocalModified time.Time `json:"local_modified_at"`
+	MimeType      string    `json:"mime_type,omitempty"`
+	Extension     string    `json:"file_extension,omitempty"`
+	Category      string    `json:"category,omitempty"` // image | video | audio | doc | app | zip | others
+	// Thumbnail is a signed url of a preview image of images and videos
+	Thumbnail   string   `json:"thumbnail,omitempty"`
+	Starred     bool     `json:"starred,omitempty"`
+	Hidden      bool     `json:"hidden,omitempty"` // not shown by the official clients
+	Description string   `json:"description,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	// ImageMedia and VideoMedia are only set for images and videos
+	ImageMedia *MediaMetadata `json:"image_media_metadata,omitempty"`
+	VideoMedia *MediaMetadata `json:"video_media_metadata,omitempty"`
+
 	downloadUrl *DownloadUrl
+	// revisionId is the revision read by Open, empty for the current content, see Revision.Node
+	revisionId string
+}
+
+// UnmarshalJSON accepts empty, missing or invalid timestamps, which are left zero.
+func (n *Node) UnmarshalJSON(b []byte) error {
+	type plain Node
+	var raw struct {
+		*plain
+		Created       string `json:"created_at"`
+		LocalCreated  string `json:"local_created_at"`
+		LocalModified string `json:"local_modified_at"`
+	}
+	raw.plain = (*plain)(n)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return errors.WithStack(err)
+	}
+
+	n.Created = parseTime(raw.Created)
+	n.LocalCreated = parseTime(raw.LocalCreated)
+	n.LocalModified = parseTime(raw.LocalModified)
+	return nil
+}
+
+// apiTimeLayout is the layout of the timestamps sent to the API.
+const apiTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// formatTime formats t for the API, the zero time is empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(apiTimeLayout)
+}
+
+// timeLayouts are the layouts of the timestamps of the API, the last one is the EXIF layout.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02 15:04:05",
+	"2006:01:02 15:04:05",
+}
+
+// parseTime parses a timestamp of the API, it is zero for empty or invalid timestamps,
+// e.g. the "0000:00:00 00:00:00" of cameras without a clock.
+func parseTime(s string) time.Time {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Location is a position from the metadata of an image or video.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// MediaMetadata is the metadata the server extracts from images and videos.
+type MediaMetadata struct {
+	Width  int
+	Height int
+	// Duration is zero for images
+	Duration time.Duration
+	// Taken is the time the photo or video was taken, zero if unknown
+	Taken    time.Time
+	Location *Location
+	// Exif is the JSON encoded EXIF data of images
+	Exif string
+}
+
+// mediaMetadata is MediaMetadata as sent by the API.
+type mediaMetadata struct {
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Duration string `json:"duration,omitempty"` // seconds
+	Time     string `json:"time,omitempty"`
+	Location string `json:"location,omitempty"` // latitude,longitude
+	Exif     string `json:"exif,omitempty"`
+}
+
+func (m *MediaMetadata) UnmarshalJSON(b []byte) error {
+	var raw mediaMetadata
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the metadata is extracted from the files, invalid values are left unknown
+	*m = MediaMetadata{Width: raw.Width, Height: raw.Height, Exif: raw.Exif, Taken: parseTime(raw.Time)}
+	if seconds, err := strconv.ParseFloat(raw.Duration, 64); err == nil {
+		m.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	var loc Location
+	if _, err := fmt.Sscanf(raw.Location, "%g,%g", &loc.Latitude, &loc.Longitude); err == nil {
+		m.Location = &loc
+	}
+	return nil
+}
+
+// MarshalJSON encodes m as the API does, so that it can be decoded again.
+func (m MediaMetadata) MarshalJSON() ([]byte, error) {
+	raw := mediaMetadata{Width: m.Width, Height: m.Height, Exif: m.Exif}
+	if m.Duration != 0 {
+		raw.Duration = strconv.FormatFloat(m.Duration.Seconds(), 'f', -1, 64)
+	}
+	if !m.Taken.IsZero() {
+		raw.Time = m.Taken.Format(time.RFC3339Nano)
+	}
+	if m.Location != nil {
+		raw.Location = fmt.Sprintf("%g,%g", m.Location.Latitude, m.Location.Longitude)
+	}
+	return json.Marshal(raw)
 }
 
 func (n Node) String() string {
@@ -34,6 +171,16 @@ func (n *Node) IsDirectory() bool {
 	return n.Type == "folder"
 }
 
+// ModTime returns the modification time of the local file the node was uploaded from,
+// or the time of its last update on the server if unknown.
+func (n *Node) ModTime() time.Time {
+	if !n.LocalModified.IsZero() {
+		return n.LocalModified
+	}
+	t, _ := n.GetTime()
+	return t
+}
+
 func (n *Node) GetTime() (time.Time, error) {
 	layout := "2006-01-02T15:04:05.000Z"
 	t, err := time.Parse(layout, n.Updated)
@@ -49,14 +196,16 @@ type ListNodes struct {
 	NextMarker string `json:"next_marker"`
 }
 
-type ListSharedFile struct {
-	Items      []SharedFile `json:"items"`
-	NextMarker string       `json:"next_marker"`
+type ListShareLinks struct {
+	Items      []ShareLink `json:"items"`
+	NextMarker string      `json:"next_marker"`
 }
 
 type User struct {
-	DriveId string `json:"default_drive_id"`
-	UserId  string `json:"user_id"`
+	DriveId         string `json:"default_drive_id"`
+	ResourceDriveId string `json:"resource_drive_id,omitempty"`
+	BackupDriveId   string `json:"backup_drive_id,omitempty"`
+	UserId          string `json:"user_id"`
 }
 
 type AlbumInfo struct {
@@ -79,19 +228,74 @@ type DownloadUrl struct {
 	Expiration  string            `json:"expiration"` // 2006-01-02T15:04:05.999Z
 }
 
-type SharedFile struct {
-	DriveID    string   `json:"drive_id,omitempty"`
-	Pwd        string   `json:"share_pwd,omitempty"`
-	ShareID    string   `json:"share_id"`
-	Creator    string   `json:"creator,omitempty"`
-	ShareName  string   `json:"share_name,omitempty"`
-	Expiration string   `json:"expiration"`
-	FileIDList []string `json:"file_id_list,omitempty"`
+// ShareLink is a share link of files of a drive.
+type ShareLink struct {
+	ShareId string `json:"share_id"`
+	Name    string `json:"share_name,omitempty"`
+	// Description is the message shown with the shared files
+	Description string `json:"description,omitempty"`
+	Url         string `json:"share_url,omitempty"`
+	Password    string `json:"share_pwd,omitempty"`
+	// Expiration is zero for share links which never expire
+	Expiration time.Time `json:"expiration"`
+	Expired    bool      `json:"expired,omitempty"`
+	// Status is "enabled", "forbidden" or "cancelled"
+	Status        string    `json:"status,omitempty"`
+	DriveId       string    `json:"drive_id,omitempty"`
+	FileIdList    []string  `json:"file_id_list,omitempty"`
+	CreatorId     string    `json:"creator_id,omitempty"`
+	CreatorName   string    `json:"creator_name,omitempty"`
+	PreviewCount  int64     `json:"preview_count"`
+	DownloadCount int64     `json:"download_count"`
+	SaveCount     int64     `json:"save_count"`
+	Created       time.Time `json:"created_at"`
+	Updated       time.Time `json:"updated_at"`
 }
+
+// UnmarshalJSON accepts the empty expiration of share links which never expire, and the
+// file list of anonymous requests.
+func (link *ShareLink) UnmarshalJSON(b []byte) error {
+	type plain ShareLink
+	var raw struct {
+		*plain
+		Expiration string `json:"expiration"`
+		Creator    string `json:"creator"`
+		FileInfos  []struct {
+			FileId string `json:"file_id"`
+		} `json:"file_infos"`
+	}
+	raw.plain = (*plain)(link)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return errors.WithStack(err)
+	}
+
+	link.Expiration = time.Time{}
+	if raw.Expiration != "" {
+		t, err := time.Parse(time.RFC3339Nano, raw.Expiration)
+		if err != nil {
+			return errors.Wrapf(err, `invalid expiration "%s"`, raw.Expiration)
+		}
+		link.Expiration = t
+	}
+	if link.CreatorId == "" {
+		link.CreatorId = raw.Creator
+	}
+	if len(link.FileIdList) == 0 {
+		for _, info := range raw.FileInfos {
+			link.FileIdList = append(link.FileIdList, info.FileId)
+		}
+	}
+	if link.Url == "" && link.ShareId != "" {
+		link.Url = shareUrl + link.ShareId
+	}
+	return nil
+}
+
+// ShareToken grants access to the files of a share link, see GetShareToken.
 type ShareToken struct {
-	exp        string `json:"expire_time"`
-	expIn      int64  `json:"expires_in"`
-	shareToken string `json:"share_token"`
+	Token      string    `json:"share_token"`
+	Expiration time.Time `json:"expire_time"`
+	ExpiresIn  int64     `json:"expires_in"`
 }
 
 type FileProof struct {
@@ -107,6 +311,8 @@ type FileProof struct {
 	ProofCode       string      `json:"proof_code"`
 	ProofVersion    string      `json:"proof_version"`
 	Meta            string      `json:"meta,omitempty"`
+	LocalCreatedAt  string      `json:"local_created_at,omitempty"`
+	LocalModifiedAt string      `json:"local_modified_at,omitempty"`
 }
 
 type PartInfo struct {
@@ -0,0 +1,157 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeMetadata(t *testing.T) {
+	var node Node
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "file",
+		"name": "IMG_0001.HEIC",
+		"file_id": "f1",
+		"created_at": "2021-06-01T10:00:00.000Z",
+		"updated_at": "2021-06-02T10:00:00.000Z",
+		"local_modified_at": "",
+		"mime_type": "image/heic",
+		"file_extension": "HEIC",
+		"category": "image",
+		"starred": true,
+		"labels": ["beach", "sea"],
+		"image_media_metadata": {
+			"width": 4032,
+			"height": 3024,
+			"time": "2021:05:30 18:30:00",
+			"location": "30.25,120.125",
+			"exif": "{\"Make\":\"Apple\"}"
+		}
+	}`), &node))
+	assert.Equal(t, time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC), node.Created)
+	assert.True(t, node.LocalModified.IsZero())
+	assert.Equal(t, "image/heic", node.MimeType)
+	assert.Equal(t, "image", node.Category)
+	assert.True(t, node.Starred)
+	assert.Equal(t, []string{"beach", "sea"}, node.Labels)
+	require.NotNil(t, node.ImageMedia)
+	assert.Equal(t, 4032, node.ImageMedia.Width)
+	assert.Equal(t, time.Date(2021, 5, 30, 18, 30, 0, 0, time.UTC), node.ImageMedia.Taken)
+	assert.Equal(t, &Location{Latitude: 30.25, Longitude: 120.125}, node.ImageMedia.Location)
+	assert.Nil(t, node.VideoMedia)
+
+	// the encoded nodes decode to the same node
+	b, err := json.Marshal(node)
+	require.NoError(t, err)
+	var decoded Node
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, node, decoded)
+
+	var video MediaMetadata
+	require.NoError(t, json.Unmarshal([]byte(`{"width": 1920, "height": 1080, "duration": "12.5"}`), &video))
+	assert.Equal(t, 12500*time.Millisecond, video.Duration)
+
+	// invalid metadata is left unknown instead of failing the listing
+	var lenient Node
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"created_at": "yesterday",
+		"local_modified_at": "2021-06-01T08:00:00+0800",
+		"image_media_metadata": {"width": 10, "time": "0000:00:00 00:00:00", "location": "somewhere"},
+		"video_media_metadata": {"duration": "N/A"}
+	}`), &lenient))
+	assert.True(t, lenient.Created.IsZero())
+	assert.Equal(t, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), lenient.LocalModified.UTC())
+	require.NotNil(t, lenient.ImageMedia)
+	assert.Equal(t, 10, lenient.ImageMedia.Width)
+	assert.True(t, lenient.ImageMedia.Taken.IsZero())
+	assert.Nil(t, lenient.ImageMedia.Location)
+	require.NotNil(t, lenient.VideoMedia)
+	assert.Zero(t, lenient.VideoMedia.Duration)
+}
+
+func TestNodeMetadataFromServer(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	videoId := srv.Put(drivetest.RootId, "clip.mp4", []byte("mp4"))
+	taken := time.Date(2021, 5, 30, 18, 30, 0, 0, time.UTC)
+	srv.Modify(videoId, func(f *drivetest.File) {
+		f.Description = "first steps"
+		f.VideoMedia = map[string]interface{}{"width": 1920, "height": 1080, "duration": "3.2", "time": taken.Format(time.RFC3339)}
+	})
+	srv.Put(drivetest.RootId, "notes.txt", []byte("text"))
+
+	nodes, err := drive.ListAll(ctx, "root")
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	clip, notes := nodes[0], nodes[1]
+	assert.Equal(t, "video", clip.Category)
+	assert.Equal(t, "mp4", clip.Extension)
+	assert.Equal(t, "video/mp4", clip.MimeType)
+	assert.NotEmpty(t, clip.Thumbnail)
+	assert.Equal(t, "first steps", clip.Description)
+	assert.WithinDuration(t, time.Now(), clip.Created, time.Minute)
+	require.NotNil(t, clip.VideoMedia)
+	assert.Equal(t, 3200*time.Millisecond, clip.VideoMedia.Duration)
+	assert.Equal(t, taken, clip.VideoMedia.Taken)
+	assert.Equal(t, "doc", notes.Category)
+	assert.Empty(t, notes.Thumbnail)
+
+	node, err := drive.Get(ctx, videoId)
+	require.NoError(t, err)
+	assert.Equal(t, clip, *node)
+}
+
+func TestLocalTimes(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	created := time.Date(2020, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	modified := created.Add(time.Hour)
+
+	nodeId, err := drive.CreateFile(ctx, Node{ParentId: "root", Name: "a.txt", Size: 1, LocalCreated: created, LocalModified: modified}, strings.NewReader("a"))
+	require.NoError(t, err)
+	node, err := drive.Get(ctx, nodeId)
+	require.NoError(t, err)
+	assert.Equal(t, created, node.LocalCreated)
+	assert.Equal(t, modified, node.LocalModified)
+	assert.Equal(t, modified, node.ModTime())
+	assert.Equal(t, modified, srv.File(nodeId).LocalModified)
+
+	// the modification time of local files is sent by default
+	name := filepath.Join(t.TempDir(), "b.txt")
+	require.NoError(t, ioutil.WriteFile(name, []byte("b"), 0644))
+	require.NoError(t, os.Chtimes(name, modified, modified))
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	nodeId, err = drive.CreateFile(ctx, Node{ParentId: "root", Name: "b.txt", Size: 1}, f)
+	require.NoError(t, err)
+	node, err = drive.Get(ctx, nodeId)
+	require.NoError(t, err)
+	assert.Equal(t, modified, node.LocalModified)
+	assert.True(t, node.LocalCreated.IsZero())
+
+	node.LocalModified = modified.Add(time.Minute)
+	_, err = drive.Update(ctx, *node)
+	require.NoError(t, err)
+	node, err = drive.Get(ctx, nodeId)
+	require.NoError(t, err)
+	assert.Equal(t, modified.Add(time.Minute), node.LocalModified)
+
+	// nodes without local times fall back to the update time
+	nodeId = srv.Put(drivetest.RootId, "c.txt", []byte("c"))
+	node, err = drive.Get(ctx, nodeId)
+	require.NoError(t, err)
+	assert.True(t, node.LocalModified.IsZero())
+	updated, err := node.GetTime()
+	require.NoError(t, err)
+	assert.Equal(t, updated, node.ModTime())
+}
@@ -0,0 +1,424 @@
+package drive
+
+import (
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultCacheTTL = time.Minute
+
+// PathFs is a path oriented view of a Fs.
+//
+// Nodes are cached by path for ttl, mutations made through PathFs update the cache,
+// changes made by other clients are seen once the cached entries expire or Invalidate is called.
+// PathFs is safe for concurrent use by multiple goroutines.
+type PathFs struct {
+	fs  Fs
+	ttl time.Duration
+
+	mutex sync.Mutex
+	nodes map[string]cachedNode
+	dirs  map[string]cachedDir
+}
+
+type cachedNode struct {
+	node     Node
+	expireAt time.Time
+}
+
+// cachedDir holds the names of the children of a listed folder
+type cachedDir struct {
+	names    map[string]struct{}
+	expireAt time.Time
+}
+
+// NewPathFs creates a PathFs on top of fs, ttl <= 0 means DefaultCacheTTL.
+func NewPathFs(fs Fs, ttl time.Duration) *PathFs {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	return &PathFs{
+		fs:    fs,
+		ttl:   ttl,
+		nodes: make(map[string]cachedNode),
+		dirs:  make(map[string]cachedDir),
+	}
+}
+
+// Fs returns the underlying Fs.
+func (p *PathFs) Fs() Fs {
+	return p.fs
+}
+
+func cleanPath(s string) string {
+	return normalizePath(path.Clean("/" + s))
+}
+
+func isSubPath(s string, dir string) bool {
+	return s == dir || dir == "/" || strings.HasPrefix(s, dir+"/")
+}
+
+func sortNodes(nodes []Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+}
+
+// must be called with p.mutex held
+func (p *PathFs) lookup(fullPath string) (*Node, bool) {
+	now := time.Now()
+	if c, ok := p.nodes[fullPath]; ok && now.Before(c.expireAt) {
+		node := c.node
+		return &node, true
+	}
+
+	// a fresh listing of the parent without the name means it doesn't exist
+	parent, name := path.Split(fullPath)
+	if d, ok := p.dirs[normalizePath(parent)]; ok && now.Before(d.expireAt) {
+		if _, ok := d.names[name]; !ok {
+			return nil, true
+		}
+	}
+
+	return nil, false
+}
+
+// must be called with p.mutex held
+func (p *PathFs) put(fullPath string, node Node) {
+	p.nodes[fullPath] = cachedNode{node: node, expireAt: time.Now().Add(p.ttl)}
+	parent, name := path.Split(fullPath)
+	if d, ok := p.dirs[normalizePath(parent)]; ok {
+		d.names[name] = struct{}{}
+	}
+}
+
+// must be called with p.mutex held
+func (p *PathFs) drop(fullPath string) {
+	for k := range p.nodes {
+		if isSubPath(k, fullPath) {
+			delete(p.nodes, k)
+		}
+	}
+	for k := range p.dirs {
+		if isSubPath(k, fullPath) {
+			delete(p.dirs, k)
+		}
+	}
+
+	parent, name := path.Split(fullPath)
+	if d, ok := p.dirs[normalizePath(parent)]; ok {
+		delete(d.names, name)
+	}
+}
+
+// Invalidate drops the cached entries of fullPath and everything below it.
+func (p *PathFs) Invalidate(fullPath string) {
+	fullPath = cleanPath(fullPath)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if fullPath == "/" {
+		p.nodes = make(map[string]cachedNode)
+		p.dirs = make(map[string]cachedDir)
+		return
+	}
+
+	p.drop(fullPath)
+	parent, _ := path.Split(fullPath)
+	delete(p.dirs, normalizePath(parent))
+}
+
+// Apply drops the cached entries made stale by changes, e.g. those of a ChangeFeed.
+func (p *PathFs) Apply(changes []Change) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stale := map[string]bool{}
+	listings := map[string]bool{}
+	for _, change := range changes {
+		stale[change.NodeId] = true
+		if change.Node != nil && change.Node.ParentId != "" {
+			listings[change.Node.ParentId] = true
+		}
+	}
+
+	var drops []string
+	for fullPath, c := range p.nodes {
+		if stale[c.node.NodeId] {
+			drops = append(drops, fullPath)
+		}
+		if listings[c.node.NodeId] {
+			delete(p.dirs, fullPath)
+		}
+	}
+	for _, fullPath := range drops {
+		p.drop(fullPath)
+		parent, _ := path.Split(fullPath)
+		delete(p.dirs, normalizePath(parent))
+	}
+}
+
+// Stat returns the node at fullPath, errors.Is(err, ErrorNotFound) if there is none.
+func (p *PathFs) Stat(ctx context.Context, fullPath string) (*Node, error) {
+	fullPath = cleanPath(fullPath)
+	p.mutex.Lock()
+	node, ok := p.lookup(fullPath)
+	p.mutex.Unlock()
+	if ok {
+		if node == nil {
+			return nil, errors.Wrapf(ErrorNotFound, `failed to stat "%s"`, fullPath)
+		}
+		return node, nil
+	}
+
+	node, err := p.fs.GetByPath(ctx, fullPath, AnyKind)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to stat "%s"`, fullPath)
+	}
+
+	p.mutex.Lock()
+	p.put(fullPath, *node)
+	p.mutex.Unlock()
+	return node, nil
+}
+
+// ReadDir lists the folder at fullPath.
+func (p *PathFs) ReadDir(ctx context.Context, fullPath string) ([]Node, error) {
+	fullPath = cleanPath(fullPath)
+	p.mutex.Lock()
+	if d, ok := p.dirs[fullPath]; ok && time.Now().Before(d.expireAt) {
+		nodes := make([]Node, 0, len(d.names))
+		complete := true
+		for name := range d.names {
+			c, ok := p.nodes[path.Join(fullPath, name)]
+			if !ok {
+				complete = false
+				break
+			}
+			nodes = append(nodes, c.node)
+		}
+		if complete {
+			p.mutex.Unlock()
+			sortNodes(nodes)
+			return nodes, nil
+		}
+	}
+	p.mutex.Unlock()
+
+	dir, err := p.Stat(ctx, fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dir.IsDirectory() {
+		return nil, errors.Errorf(`"%s" is not a folder`, fullPath)
+	}
+
+	nodes, err := p.fs.ListAll(ctx, dir.NodeId)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to read "%s"`, fullPath)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	d := cachedDir{names: make(map[string]struct{}, len(nodes)), expireAt: time.Now().Add(p.ttl)}
+	p.dirs[fullPath] = d
+	for _, node := range nodes {
+		p.put(path.Join(fullPath, node.Name), node)
+	}
+
+	return nodes, nil
+}
+
+// MkdirAll creates the folder at fullPath along with any missing parents, like "mkdir -p".
+func (p *PathFs) MkdirAll(ctx context.Context, fullPath string) (*Node, error) {
+	fullPath = cleanPath(fullPath)
+	node, err := p.Stat(ctx, fullPath)
+	if err == nil {
+		if !node.IsDirectory() {
+			return nil, errors.Wrapf(ErrorAlreadyExisted, `"%s" is not a folder`, fullPath)
+		}
+		return node, nil
+	}
+
+	if !errors.Is(err, ErrorNotFound) {
+		return nil, err
+	}
+
+	parentPath, name := path.Split(fullPath)
+	parent, err := p.MkdirAll(ctx, parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeId, err := p.fs.CreateFolder(ctx, Node{ParentId: parent.NodeId, Name: name})
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to create "%s"`, fullPath)
+	}
+
+	node = &Node{Type: FolderKind, Name: name, NodeId: nodeId, ParentId: parent.NodeId}
+	p.mutex.Lock()
+	p.put(fullPath, *node)
+	p.mutex.Unlock()
+	return node, nil
+}
+
+// Rename moves the node at oldPath to newPath, newPath must not exist.
+func (p *PathFs) Rename(ctx context.Context, oldPath string, newPath string) error {
+	oldPath = cleanPath(oldPath)
+	newPath = cleanPath(newPath)
+	node, err := p.Stat(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+
+	if isSubPath(newPath, oldPath) {
+		return errors.Errorf(`can't move "%s" into itself`, oldPath)
+	}
+
+	if _, err := p.Stat(ctx, newPath); err == nil {
+		return errors.Wrapf(ErrorAlreadyExisted, `failed to rename to "%s"`, newPath)
+	} else if !errors.Is(err, ErrorNotFound) {
+		return err
+	}
+
+	newParentPath, newName := path.Split(newPath)
+	newParent, err := p.Stat(ctx, newParentPath)
+	if err != nil {
+		return err
+	}
+
+	if newParent.NodeId == node.ParentId {
+		renamed := *node
+		renamed.Name = newName
+		_, err = p.fs.Update(ctx, renamed)
+	} else {
+		_, err = p.fs.Move(ctx, node.NodeId, newParent.NodeId, newName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, `failed to rename "%s" to "%s"`, oldPath, newPath)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.drop(oldPath)
+	node.Name = newName
+	node.ParentId = newParent.NodeId
+	p.put(newPath, *node)
+	return nil
+}
+
+// Copy copies the node at srcPath to dstPath on the server side, dstPath must not exist.
+func (p *PathFs) Copy(ctx context.Context, srcPath string, dstPath string) (*Node, error) {
+	srcPath = cleanPath(srcPath)
+	dstPath = cleanPath(dstPath)
+	node, err := p.Stat(ctx, srcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if isSubPath(dstPath, srcPath) {
+		return nil, errors.Errorf(`can't copy "%s" into itself`, srcPath)
+	}
+
+	if _, err := p.Stat(ctx, dstPath); err == nil {
+		return nil, errors.Wrapf(ErrorAlreadyExisted, `failed to copy to "%s"`, dstPath)
+	} else if !errors.Is(err, ErrorNotFound) {
+		return nil, err
+	}
+
+	dstParentPath, dstName := path.Split(dstPath)
+	dstParent, err := p.Stat(ctx, dstParentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeId, err := p.fs.Copy(ctx, node.NodeId, dstParent.NodeId, dstName)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to copy "%s" to "%s"`, srcPath, dstPath)
+	}
+
+	copied, err := p.fs.Get(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	p.put(dstPath, *copied)
+	p.mutex.Unlock()
+	return copied, nil
+}
+
+// Remove moves the node at fullPath to the recycle bin.
+func (p *PathFs) Remove(ctx context.Context, fullPath string) error {
+	fullPath = cleanPath(fullPath)
+	node, err := p.Stat(ctx, fullPath)
+	if err != nil {
+		return err
+	}
+
+	if err := p.fs.Remove(ctx, node.NodeId); err != nil {
+		return errors.Wrapf(err, `failed to remove "%s"`, fullPath)
+	}
+
+	p.mutex.Lock()
+	p.drop(fullPath)
+	p.mutex.Unlock()
+	return nil
+}
+
+// OpenFile opens the file at fullPath for reading, see Fs.Open.
+func (p *PathFs) OpenFile(ctx context.Context, fullPath string, headers map[string]string) (io.ReadCloser, error) {
+	node, err := p.Stat(ctx, fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.fs.Open(ctx, node, headers)
+}
+
+// Create uploads in to fullPath, creating missing parent folders and replacing an existing file,
+// whose content is kept as a revision. Replacing fails with ErrorNotSupported if the Fs isn't a Replacer.
+func (p *PathFs) Create(ctx context.Context, fullPath string, in io.Reader, size int64) (*Node, error) {
+	fullPath = cleanPath(fullPath)
+	parentPath, name := path.Split(fullPath)
+	parent, err := p.MkdirAll(ctx, parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	create := p.fs.CreateFile
+	if old, err := p.Stat(ctx, fullPath); err == nil {
+		if old.IsDirectory() {
+			return nil, errors.Wrapf(ErrorAlreadyExisted, `"%s" is a folder`, fullPath)
+		}
+		replacer, ok := p.fs.(Replacer)
+		if !ok {
+			return nil, errors.Wrapf(ErrorNotSupported, `can't replace "%s"`, fullPath)
+		}
+		create = replacer.ReplaceFile
+	} else if !errors.Is(err, ErrorNotFound) {
+		return nil, err
+	}
+
+	nodeId, err := create(ctx, Node{ParentId: parent.NodeId, Name: name, Size: size}, in)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to create "%s"`, fullPath)
+	}
+
+	node, err := p.fs.Get(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	p.put(fullPath, *node)
+	p.mutex.Unlock()
+	return node, nil
+}
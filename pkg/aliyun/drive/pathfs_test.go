@@ -0,0 +1,96 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathFs(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	p := NewPathFs(drive, time.Minute)
+
+	dir, err := p.MkdirAll(ctx, "/a/b/c")
+	require.NoError(t, err)
+	assert.Equal(t, srv.Lookup("/a/b/c").FileId, dir.NodeId)
+
+	data := []byte("hello world")
+	node, err := p.Create(ctx, "/a/b/c/hello.txt", bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	assert.Equal(t, "hello.txt", node.Name)
+	assert.Equal(t, data, srv.ReadAll("/a/b/c/hello.txt"))
+
+	// replacing a file
+	data = []byte("bye")
+	_, err = p.Create(ctx, "/a/b/c/hello.txt", bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	assert.Equal(t, data, srv.ReadAll("/a/b/c/hello.txt"))
+	_, err = NewPathFs(struct{ Fs }{drive}, 0).Create(ctx, "/a/b/c/hello.txt", bytes.NewReader(data), int64(len(data)))
+	assert.ErrorIs(t, err, ErrorNotSupported, "an Fs which isn't a Replacer")
+
+	// deep lookups are served from the cache
+	gets := srv.Requests("/v2/file/get_by_path") + srv.Requests("/adrive/v3/file/list")
+	for i := 0; i < 3; i++ {
+		node, err = p.Stat(ctx, "/a/b/c/hello.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), node.Size)
+	}
+	nodes, err := p.ReadDir(ctx, "/a/b/c")
+	require.NoError(t, err)
+	assert.Len(t, nodes, 1)
+	nodes, err = p.ReadDir(ctx, "/a/b/c")
+	require.NoError(t, err)
+	assert.Len(t, nodes, 1)
+	_, err = p.Stat(ctx, "/a/b/c/missing")
+	assert.ErrorIs(t, err, ErrorNotFound)
+	assert.Equal(t, gets+1, srv.Requests("/v2/file/get_by_path")+srv.Requests("/adrive/v3/file/list"))
+
+	rd, err := p.OpenFile(ctx, "/a/b/c/hello.txt", map[string]string{"Range": "bytes=1-"})
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	_ = rd.Close()
+	assert.Equal(t, "ye", string(b))
+
+	require.NoError(t, p.Rename(ctx, "/a/b", "/a/d"))
+	assert.Nil(t, srv.Lookup("/a/b"))
+	_, err = p.Stat(ctx, "/a/b/c/hello.txt")
+	assert.ErrorIs(t, err, ErrorNotFound)
+	node, err = p.Stat(ctx, "/a/d/c/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, srv.Lookup("/a/d/c/hello.txt").FileId, node.NodeId)
+
+	require.NoError(t, p.Rename(ctx, "/a/d/c/hello.txt", "/hello.txt"))
+	assert.NotNil(t, srv.Lookup("/hello.txt"))
+	assert.ErrorIs(t, p.Rename(ctx, "/a", "/hello.txt"), ErrorAlreadyExisted)
+
+	copied, err := p.Copy(ctx, "/a/d", "/a/copy")
+	require.NoError(t, err)
+	assert.Equal(t, srv.Lookup("/a/copy").FileId, copied.NodeId)
+	assert.NotNil(t, srv.Lookup("/a/copy/c"))
+	_, err = p.Copy(ctx, "/a/d", "/a/copy")
+	assert.ErrorIs(t, err, ErrorAlreadyExisted)
+
+	require.NoError(t, p.Remove(ctx, "/a"))
+	assert.Nil(t, srv.Lookup("/a"))
+	_, err = p.Stat(ctx, "/a/d")
+	assert.ErrorIs(t, err, ErrorNotFound)
+
+	// changes made behind the cache's back are seen after Invalidate
+	_, err = p.ReadDir(ctx, "/")
+	require.NoError(t, err)
+	srv.Mkdir("root", "e")
+	nodes, err = p.ReadDir(ctx, "/")
+	require.NoError(t, err)
+	assert.Len(t, nodes, 1)
+	p.Invalidate("/")
+	nodes, err = p.ReadDir(ctx, "/")
+	require.NoError(t, err)
+	assert.Len(t, nodes, 2)
+}
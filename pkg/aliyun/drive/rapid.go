@@ -0,0 +1,274 @@
+package drive
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RapidEntry is a line of a rapid upload list, "path|sha1|size".
+//
+// A file whose content is already on aliyun drive can be created from its sha1 and size
+// alone, without uploading the content, see ImportRapidList.
+type RapidEntry struct {
+	// Path is relative to the exported folder, "/" separated.
+	Path string
+	// Hash is the sha1 of the content, upper case hex like Node.Hash.
+	Hash string
+	Size int64
+}
+
+func (e RapidEntry) String() string {
+	return fmt.Sprintf("%s|%s|%d", e.Path, e.Hash, e.Size)
+}
+
+// ParseRapidEntry parses a "path|sha1|size" line, the path may contain "|".
+func ParseRapidEntry(line string) (RapidEntry, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 3 {
+		return RapidEntry{}, errors.Errorf(`invalid rapid upload line "%s"`, line)
+	}
+
+	n := len(fields)
+	size, err := strconv.ParseInt(fields[n-1], 10, 64)
+	if err != nil || size < 0 {
+		return RapidEntry{}, errors.Errorf(`invalid size in rapid upload line "%s"`, line)
+	}
+
+	hash := strings.ToUpper(fields[n-2])
+	if len(hash) != 40 {
+		return RapidEntry{}, errors.Errorf(`invalid sha1 in rapid upload line "%s"`, line)
+	}
+
+	p := strings.Trim(path.Clean("/"+strings.Join(fields[:n-2], "|")), "/")
+	if p == "" {
+		return RapidEntry{}, errors.Errorf(`invalid path in rapid upload line "%s"`, line)
+	}
+	return RapidEntry{Path: p, Hash: hash, Size: size}, nil
+}
+
+// ReadRapidList reads rapid upload lines, blank lines and lines starting with "#" are skipped.
+func ReadRapidList(r io.Reader) ([]RapidEntry, error) {
+	var entries []RapidEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := ParseRapidEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, errors.WithStack(scanner.Err())
+}
+
+func WriteRapidList(w io.Writer, entries []RapidEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		if _, err := fmt.Fprintln(bw, entry); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(bw.Flush())
+}
+
+// ExportRapidList lists the files below the folder at fullPath as rapid upload entries.
+func ExportRapidList(ctx context.Context, fs Fs, fullPath string) ([]RapidEntry, error) {
+	root, err := fs.GetByPath(ctx, fullPath, FolderKind)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []RapidEntry
+	var walk func(nodeId string, dir string) error
+	walk = func(nodeId string, dir string) error {
+		nodes, err := fs.ListAll(ctx, nodeId)
+		if err != nil {
+			return err
+		}
+
+		sortNodes(nodes)
+		for _, node := range nodes {
+			p := path.Join(dir, node.Name)
+			if node.IsDirectory() {
+				if err := walk(node.NodeId, p); err != nil {
+					return err
+				}
+				continue
+			}
+
+			entries = append(entries, RapidEntry{Path: p, Hash: strings.ToUpper(node.Hash), Size: node.Size})
+		}
+		return nil
+	}
+
+	if err := walk(root.NodeId, ""); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ProofSource gives access to the content of rapid upload entries.
+//
+// Rapid upload requires a proof code along with the sha1: 8 bytes of the content at an offset
+// derived from the access token, which proves that the uploader has the content.
+// A list alone is therefore not enough, the bytes have to come from somewhere:
+//
+//   - LocalProofSource reads them from local copies of the files,
+//   - FsProofSource reads them with a ranged download from a drive holding the files,
+//     e.g. the account the list was exported from,
+//   - with a nil ProofSource no proof code is sent, which aliyun drive refuses:
+//     every entry is then reported with ErrorNotRapid.
+//
+// Only those 8 bytes are read, the content is never uploaded by ImportRapidList.
+type ProofSource interface {
+	// Open returns the content of entry, or ErrorNotFound if it isn't available.
+	// The reader is closed after use if it is an io.Closer.
+	Open(ctx context.Context, entry RapidEntry) (io.ReaderAt, error)
+}
+
+type localProofSource struct {
+	root string
+}
+
+// LocalProofSource reads the content of entries from the files below the local folder root.
+func LocalProofSource(root string) ProofSource {
+	return localProofSource{root: root}
+}
+
+func (s localProofSource) Open(ctx context.Context, entry RapidEntry) (io.ReaderAt, error) {
+	f, err := os.Open(filepath.Join(s.root, filepath.FromSlash(entry.Path)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(ErrorNotFound, `"%s"`, entry.Path)
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	fi, err := f.Stat()
+	if err == nil && fi.Size() != entry.Size {
+		err = errors.Wrapf(ErrorNotFound, `"%s" has a different size`, entry.Path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+type fsProofSource struct {
+	fs   *PathFs
+	root string
+}
+
+// FsProofSource reads the content of entries with ranged downloads of the files below the folder root of fs.
+func FsProofSource(fs Fs, root string) ProofSource {
+	return fsProofSource{fs: NewPathFs(fs, 0), root: root}
+}
+
+func (s fsProofSource) Open(ctx context.Context, entry RapidEntry) (io.ReaderAt, error) {
+	node, err := s.fs.Stat(ctx, path.Join(s.root, entry.Path))
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDirectory() || node.Size != entry.Size || !strings.EqualFold(node.Hash, entry.Hash) {
+		return nil, errors.Wrapf(ErrorNotFound, `"%s" has a different content`, entry.Path)
+	}
+
+	return readerAtFunc(func(p []byte, off int64) (int, error) {
+		if off >= node.Size {
+			return 0, io.EOF
+		}
+
+		rd, err := s.fs.Fs().Open(ctx, node, map[string]string{
+			"Range": fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1),
+		})
+		if err != nil {
+			return 0, err
+		}
+		defer rd.Close()
+		return io.ReadFull(rd, p)
+	}), nil
+}
+
+type readerAtFunc func(p []byte, off int64) (int, error)
+
+func (f readerAtFunc) ReadAt(p []byte, off int64) (int, error) {
+	return f(p, off)
+}
+
+// RapidImportResult is the outcome of importing an entry, NodeId is set if the file was created.
+type RapidImportResult struct {
+	Entry  RapidEntry
+	NodeId string
+	// Err is ErrorNotRapid if the server wants the content, ErrorAlreadyExisted if the file exists.
+	Err error
+}
+
+// ImportRapidList creates the files of entries below the folder at fullPath with rapid upload,
+// creating missing folders. Content is never uploaded, entries which can't be rapid uploaded
+// are reported in the results, see ProofSource.
+//
+// The returned error is only set if the import could not go on, e.g. a folder can't be created,
+// it is ErrorNotSupported if fs isn't an Uploader.
+func ImportRapidList(ctx context.Context, fs Fs, fullPath string, entries []RapidEntry, source ProofSource) ([]RapidImportResult, error) {
+	uploader, ok := fs.(Uploader)
+	if !ok {
+		return nil, errors.Wrap(ErrorNotSupported, "rapid upload needs an Uploader")
+	}
+	p := NewPathFs(fs, 0)
+	results := make([]RapidImportResult, 0, len(entries))
+	for _, entry := range entries {
+		dir, name := path.Split(path.Join("/", fullPath, entry.Path))
+		parent, err := p.MkdirAll(ctx, dir)
+		if err != nil {
+			return results, err
+		}
+
+		result := RapidImportResult{Entry: entry}
+		result.NodeId, result.Err = importRapidEntry(ctx, fs, uploader, Node{ParentId: parent.NodeId, Name: name, Size: entry.Size}, entry, source)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func importRapidEntry(ctx context.Context, fs Fs, uploader Uploader, node Node, entry RapidEntry, source ProofSource) (string, error) {
+	proofCode := ""
+	if source != nil {
+		in, err := source.Open(ctx, entry)
+		if err != nil && !errors.Is(err, ErrorNotFound) {
+			return "", err
+		}
+
+		if c, ok := in.(io.Closer); ok {
+			defer c.Close()
+		}
+		if in != nil {
+			if proofCode, err = fs.CalcProof(entry.Size, in); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// the upload is abandoned if the server asks for the content, which leaves no file behind
+	upload, err := uploader.CreateUpload(ctx, node, entry.Hash, proofCode, len(makePartInfoList(entry.Size)))
+	if err != nil {
+		return "", err
+	}
+	if !upload.RapidUpload {
+		return "", ErrorNotRapid
+	}
+	return upload.FileId, nil
+}
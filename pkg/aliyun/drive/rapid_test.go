@@ -0,0 +1,83 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRapidEntry(t *testing.T) {
+	entry, err := ParseRapidEntry("a|b/c.txt|3f4d82d88a0624efd46d7a5fd06be2d430c00301|42")
+	require.NoError(t, err)
+	assert.Equal(t, RapidEntry{Path: "a|b/c.txt", Hash: "3F4D82D88A0624EFD46D7A5FD06BE2D430C00301", Size: 42}, entry)
+	assert.Equal(t, "a|b/c.txt|3F4D82D88A0624EFD46D7A5FD06BE2D430C00301|42", entry.String())
+
+	for _, line := range []string{"c.txt|42", "c.txt|abc|42", "c.txt|3f4d82d88a0624efd46d7a5fd06be2d430c00301|-1", "/|3f4d82d88a0624efd46d7a5fd06be2d430c00301|1"} {
+		_, err := ParseRapidEntry(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestRapidList(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	dir := srv.Mkdir(drivetest.RootId, "src")
+	srv.Put(dir, "a.txt", []byte("hello"))
+	srv.Put(srv.Mkdir(dir, "sub"), "b.txt", []byte("world"))
+
+	entries, err := ExportRapidList(ctx, drive, "/src")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, RapidEntry{Path: "a.txt", Hash: srv.Lookup("/src/a.txt").Hash(), Size: 5}, entries[0])
+	assert.Equal(t, "sub/b.txt", entries[1].Path)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteRapidList(&buf, entries))
+	read, err := ReadRapidList(bytes.NewReader(append([]byte("# comment\n\n"), buf.Bytes()...)))
+	require.NoError(t, err)
+	assert.Equal(t, entries, read)
+
+	// without the content, the proof code can't be computed
+	uploads := srv.Requests("/v2/file/complete")
+	results, err := ImportRapidList(ctx, drive, "/none", entries, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.ErrorIs(t, result.Err, ErrorNotRapid)
+	}
+	assert.Nil(t, srv.Lookup("/none/a.txt"))
+	assert.Equal(t, uploads, srv.Requests("/v2/file/complete"))
+
+	results, err = ImportRapidList(ctx, drive, "/copy", entries, FsProofSource(drive, "/src"))
+	require.NoError(t, err)
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+		assert.NotEmpty(t, result.NodeId)
+	}
+	assert.Equal(t, []byte("hello"), srv.ReadAll("/copy/a.txt"))
+	assert.Equal(t, []byte("world"), srv.ReadAll("/copy/sub/b.txt"))
+
+	local := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(local, "a.txt"), []byte("hello"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(local, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(local, "sub", "b.txt"), []byte("changed"), 0644))
+	results, err = ImportRapidList(ctx, drive, "/local", entries, LocalProofSource(local))
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, ErrorNotRapid)
+	assert.Equal(t, []byte("hello"), srv.ReadAll("/local/a.txt"))
+
+	results, err = ImportRapidList(ctx, drive, "/local", entries[:1], LocalProofSource(local))
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, ErrorAlreadyExisted)
+
+	_, err = ImportRapidList(ctx, struct{ Fs }{drive}, "/local", entries, nil)
+	assert.ErrorIs(t, err, ErrorNotSupported)
+}
@@ -0,0 +1,81 @@
+package drive
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Reader reads a file through Fs.Open, it implements io.ReadSeekCloser.
+//
+// The download starts on the first Read, seeking closes it and the next Read
+// reopens it at the new offset with a "Range" header, so http.ServeContent can serve ranges.
+type Reader struct {
+	ctx    context.Context
+	fs     Fs
+	node   *Node
+	offset int64
+	body   io.ReadCloser
+}
+
+func NewReader(ctx context.Context, fs Fs, node *Node) *Reader {
+	return &Reader{ctx: ctx, fs: fs, node: node}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.node.Size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		headers := map[string]string{}
+		if r.offset > 0 {
+			headers["Range"] = "bytes=" + strconv.FormatInt(r.offset, 10) + "-"
+		}
+
+		body, err := r.fs.Open(r.ctx, r.node, headers)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.node.Size
+	default:
+		return 0, errors.Errorf("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,94 @@
+package drive
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiListRevisions   = "https://api.aliyundrive.com/adrive/v1/revision/list"
+	apiRestoreRevision = "https://api.aliyundrive.com/adrive/v1/revision/restore"
+	apiDeleteRevision  = "https://api.aliyundrive.com/adrive/v1/revision/delete"
+)
+
+// Revision is a version of the content of a file, overwriting a file keeps its content as a revision.
+type Revision struct {
+	RevisionId string `json:"revision_id"`
+	FileId     string `json:"file_id"`
+	Size       int64  `json:"size"`
+	Hash       string `json:"content_hash,omitempty"` // sha1
+	// Latest is set for the current content of the file
+	Latest  bool      `json:"is_latest_version"`
+	Created time.Time `json:"created_at"`
+}
+
+// Node returns a copy of node, the current node of the file, with the content of the revision:
+// Open and NewReader read the content of the revision, ModTime is the time of the revision.
+func (r *Revision) Node(node Node) *Node {
+	node.Size = r.Size
+	node.Hash = r.Hash
+	node.Updated = formatTime(r.Created)
+	node.LocalCreated, node.LocalModified = time.Time{}, time.Time{}
+	node.revisionId = r.RevisionId
+	node.downloadUrl = nil
+	return &node
+}
+
+type ListRevisions struct {
+	Items      []Revision `json:"items"`
+	NextMarker string     `json:"next_marker"`
+}
+
+// ListRevisions returns the revisions of the file nodeId, the latest first.
+func (drive *Drive) ListRevisions(ctx context.Context, nodeId string) ([]Revision, error) {
+	body := map[string]interface{}{
+		"drive_id": drive.driveId,
+		"file_id":  nodeId,
+		"limit":    100,
+		"marker":   "",
+	}
+	var revisions []Revision
+	for {
+		var result ListRevisions
+		err := drive.jsonRequest(ctx, "POST", apiListRevisions, &body, &result)
+		if err != nil {
+			return nil, errors.Wrapf(err, `failed to list the revisions of "%s"`, nodeId)
+		}
+
+		revisions = append(revisions, result.Items...)
+		if result.NextMarker == "" {
+			return revisions, nil
+		}
+		body["marker"] = result.NextMarker
+	}
+}
+
+// RestoreRevision makes the revision revisionId the current content of the file nodeId.
+func (drive *Drive) RestoreRevision(ctx context.Context, nodeId string, revisionId string) error {
+	body := map[string]string{
+		"drive_id":    drive.driveId,
+		"file_id":     nodeId,
+		"revision_id": revisionId,
+	}
+	err := drive.jsonRequest(ctx, "POST", apiRestoreRevision, &body, nil)
+	if err != nil {
+		return errors.Wrapf(err, `failed to restore revision "%s" of "%s"`, revisionId, nodeId)
+	}
+	return nil
+}
+
+// DeleteRevision deletes an old revision of the file nodeId, the latest one can't be deleted.
+func (drive *Drive) DeleteRevision(ctx context.Context, nodeId string, revisionId string) error {
+	body := map[string]string{
+		"drive_id":    drive.driveId,
+		"file_id":     nodeId,
+		"revision_id": revisionId,
+	}
+	err := drive.jsonRequest(ctx, "POST", apiDeleteRevision, &body, nil)
+	if err != nil {
+		return errors.Wrapf(err, `failed to delete revision "%s" of "%s"`, revisionId, nodeId)
+	}
+	return nil
+}
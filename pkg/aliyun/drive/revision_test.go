@@ -0,0 +1,80 @@
+package drive
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevisions(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	put := func(data string, replace bool) string {
+		node := Node{ParentId: "root", Name: "config.yml", Size: int64(len(data))}
+		create := drive.CreateFile
+		if replace {
+			create = drive.ReplaceFile
+		}
+		nodeId, err := create(ctx, node, strings.NewReader(data))
+		require.NoError(t, err)
+		return nodeId
+	}
+	read := func(node *Node) string {
+		rd, err := drive.Open(ctx, node, nil)
+		require.NoError(t, err)
+		defer rd.Close()
+		b, err := ioutil.ReadAll(rd)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	nodeId := put("v1", false)
+	assert.Equal(t, nodeId, put("v2", true), "the file keeps its id")
+	assert.Equal(t, nodeId, put("v3!", true))
+	_, err := drive.CreateFile(ctx, Node{ParentId: "root", Name: "config.yml", Size: 2}, strings.NewReader("v4"))
+	assert.ErrorIs(t, err, ErrorAlreadyExisted)
+
+	revisions, err := drive.ListRevisions(ctx, nodeId)
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	assert.True(t, revisions[0].Latest)
+	assert.False(t, revisions[1].Latest)
+	assert.Equal(t, int64(3), revisions[0].Size)
+
+	node, err := drive.Get(ctx, nodeId)
+	require.NoError(t, err)
+	assert.Equal(t, "v3!", read(node))
+	first := revisions[2].Node(*node)
+	assert.Equal(t, int64(2), first.Size)
+	assert.Equal(t, "v1", read(first))
+	assert.Equal(t, "v2", read(revisions[1].Node(*node)))
+
+	require.NoError(t, drive.RestoreRevision(ctx, nodeId, revisions[2].RevisionId))
+	node, err = drive.Get(ctx, nodeId)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", read(node))
+	restored, err := drive.ListRevisions(ctx, nodeId)
+	require.NoError(t, err)
+	require.Len(t, restored, 3)
+	assert.Equal(t, revisions[2].RevisionId, restored[0].RevisionId)
+	assert.Equal(t, revisions[0].RevisionId, restored[1].RevisionId)
+
+	assert.Error(t, drive.DeleteRevision(ctx, nodeId, restored[0].RevisionId), "the latest revision can't be deleted")
+	require.NoError(t, drive.DeleteRevision(ctx, nodeId, revisions[1].RevisionId))
+	assert.Len(t, srv.File(nodeId).Revisions, 1)
+	_, err = drive.Open(ctx, revisions[1].Node(*node), nil)
+	assert.ErrorIs(t, err, ErrorNotFound)
+
+	// replacing through a PathFs keeps the old content too
+	p := NewPathFs(drive, 0)
+	replaced, err := p.Create(ctx, "/config.yml", strings.NewReader("v5"), 2)
+	require.NoError(t, err)
+	assert.Equal(t, nodeId, replaced.NodeId)
+	revisions, err = drive.ListRevisions(ctx, nodeId)
+	require.NoError(t, err)
+	assert.Len(t, revisions, 3)
+}
@@ -0,0 +1,394 @@
+package drive
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// shareUrl is followed by the id of a share link to make its url.
+const shareUrl = "https://www.aliyundrive.com/s/"
+
+// ShareIdFromUrl returns the id of a share link from its url, e.g. https://www.aliyundrive.com/s/<id>,
+// s is returned as is if it is not a url.
+func ShareIdFromUrl(s string) string {
+	if i := strings.Index(s, "/s/"); i >= 0 {
+		s = s[i+len("/s/"):]
+	}
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// shareExpiration formats the expiration of share links expiring in expiresIn seconds.
+func shareExpiration(expiresIn int64) string {
+	if expiresIn <= 0 {
+		return ""
+	}
+	return time.Now().UTC().Add(time.Duration(expiresIn) * time.Second).Format("2006-01-02T15:04:05.000Z")
+}
+
+// CreateShareLink shares nodes for expiresIn seconds, 0 for a share link which never expires.
+func (drive *Drive) CreateShareLink(ctx context.Context, node []Node, pwd string, expiresIn int64) (*ShareLink, error) {
+	var NodeIDs []string
+	for _, node := range node {
+		NodeIDs = append(NodeIDs, node.NodeId)
+	}
+
+	body := map[string]interface{}{
+		"share_pwd":    pwd,
+		"drive_id":     drive.driveId,
+		"file_id_list": NodeIDs,
+		"expiration":   shareExpiration(expiresIn),
+	}
+	var result ShareLink
+	err := drive.jsonRequest(ctx, "POST", apiCreateShareLink, &body, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to post create share link request")
+	}
+	return &result, nil
+}
+
+// GetShareInfo returns a share link of the user.
+func (drive *Drive) GetShareInfo(ctx context.Context, shareID string) (*ShareLink, error) {
+	body := map[string]string{
+		"share_id": shareID,
+	}
+	var result ShareLink
+	err := drive.jsonRequest(ctx, "POST", apiGetShareLinkByShareID, &body, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get share info by shareID")
+	}
+	return &result, nil
+}
+
+// GetShareToken returns the token granting access to the files of a share link,
+// pwd is empty for share links without password.
+//
+// How to use share_token: https://help.aliyun.com/document_detail/397603.html
+func (drive *Drive) GetShareToken(ctx context.Context, pwd string, shareID string) (*ShareToken, error) {
+	body := map[string]string{
+		"share_id": shareID,
+	}
+	if pwd != "" {
+		body["share_pwd"] = pwd
+	}
+	var result ShareToken
+	err := drive.jsonRequest(ctx, "POST", apiGetShareToken, &body, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get share_token")
+	}
+	if result.Token == "" {
+		return nil, errors.New("failed to get share_token: empty token")
+	}
+	return &result, nil
+}
+
+// CancelShareLink cancels a share link of the user, its files are kept.
+func (drive *Drive) CancelShareLink(ctx context.Context, shareID string) error {
+	body := map[string]string{
+		"share_id": shareID,
+	}
+	err := drive.jsonRequest(ctx, "POST", apiCancelShareLink, &body, nil) // No need to parse json response, so nil
+	if err != nil {
+		return errors.Wrap(err, "failed to post cancel share link request")
+	}
+	return nil
+}
+
+// ShareListOptions sorts and filters share links, the zero value lists the active share links by name.
+type ShareListOptions struct {
+	// OrderBy is "share_name" (default), "created_at", "updated_at" or "expiration".
+	OrderBy string
+	// Descending sorts in descending order.
+	Descending       bool
+	IncludeCancelled bool
+	// CreatorId only keeps the share links created by this user.
+	CreatorId string
+	// OnlyExpired and SkipExpired filter on expiration.
+	OnlyExpired bool
+	SkipExpired bool
+	// FileId only keeps the share links of this file.
+	FileId string
+	// Limit is the page size, 100 by default.
+	Limit int
+}
+
+// keep reports whether link matches the filters applied on the client side.
+func (opts *ShareListOptions) keep(link *ShareLink) bool {
+	expired := link.IsExpired()
+	if (opts.OnlyExpired && !expired) || (opts.SkipExpired && expired) {
+		return false
+	}
+	if opts.CreatorId != "" && link.CreatorId != opts.CreatorId {
+		return false
+	}
+	if opts.FileId == "" {
+		return true
+	}
+	for _, id := range link.FileIdList {
+		if id == opts.FileId {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the share link has expired, by the server or by its expiration.
+func (link *ShareLink) IsExpired() bool {
+	return link.Expired || (!link.Expiration.IsZero() && link.Expiration.Before(time.Now()))
+}
+
+// ShareLinkPager lists share links page by page, see Drive.ShareLinks.
+type ShareLinkPager interface {
+	Next() bool
+	ShareLinks(ctx context.Context) ([]ShareLink, error)
+}
+
+type shareLinkPager struct {
+	drive  *Drive
+	opts   ShareListOptions
+	param  map[string]interface{}
+	result *ListShareLinks
+}
+
+func (p *shareLinkPager) Next() bool {
+	return p.result == nil || p.result.NextMarker != ""
+}
+
+// ShareLinks returns the next page, it may be empty when the filters drop every share link of the page.
+func (p *shareLinkPager) ShareLinks(ctx context.Context) ([]ShareLink, error) {
+	p.result = nil
+	err := p.drive.jsonRequest(ctx, "POST", apiListShareLink, &p.param, &p.result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get share links")
+	}
+
+	p.param["marker"] = p.result.NextMarker
+	links := p.result.Items[:0]
+	for _, link := range p.result.Items {
+		if p.opts.keep(&link) {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+func (drive *Drive) ShareLinks(opts ShareListOptions) ShareLinkPager {
+	if opts.OrderBy == "" {
+		opts.OrderBy = "share_name"
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 100
+	}
+	direction := "ASC"
+	if opts.Descending {
+		direction = "DESC"
+	}
+
+	param := map[string]interface{}{
+		"limit":             opts.Limit,
+		"order_by":          opts.OrderBy,
+		"order_direction":   direction,
+		"include_cancelled": opts.IncludeCancelled,
+		"marker":            "",
+	}
+	if opts.CreatorId != "" {
+		param["creator"] = opts.CreatorId
+	}
+	return &shareLinkPager{drive: drive, opts: opts, param: param}
+}
+
+func (drive *Drive) ListShareLinks(ctx context.Context, opts ShareListOptions) ([]ShareLink, error) {
+	p := drive.ShareLinks(opts)
+	var links []ShareLink
+	for p.Next() {
+		page, err := p.ShareLinks(ctx)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, page...)
+	}
+	return links, nil
+}
+
+// ShareLinkUpdate lists the fields changed by UpdateShareLink, nil fields are kept.
+type ShareLinkUpdate struct {
+	// Password is empty to remove the password.
+	Password *string
+	// Expiration is zero for a share link which never expires.
+	Expiration  *time.Time
+	Description *string
+}
+
+func (drive *Drive) UpdateShareLink(ctx context.Context, shareID string, update ShareLinkUpdate) (*ShareLink, error) {
+	body := map[string]interface{}{
+		"share_id": shareID,
+	}
+	if update.Password != nil {
+		body["share_pwd"] = *update.Password
+	}
+	if update.Expiration != nil {
+		body["expiration"] = ""
+		if !update.Expiration.IsZero() {
+			body["expiration"] = update.Expiration.UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+	}
+	if update.Description != nil {
+		body["description"] = *update.Description
+	}
+
+	var result ShareLink
+	err := drive.jsonRequest(ctx, "POST", apiUpdateShareLink, &body, &result)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to update share link "%s"`, shareID)
+	}
+	return &result, nil
+}
+
+// ShareCleanupOptions selects the share links cancelled by CleanupShareLinks.
+type ShareCleanupOptions struct {
+	Expired bool
+	// Orphaned share links have lost all their files, see isOrphaned.
+	Orphaned bool
+	// DryRun only reports the share links which would be cancelled.
+	DryRun bool
+}
+
+// ShareCleanupResult is a share link cancelled by CleanupShareLinks.
+type ShareCleanupResult struct {
+	Link ShareLink
+	// Reason is "expired" or "orphaned".
+	Reason string
+	Err    error
+}
+
+// CleanupShareLinks cancels the expired or orphaned share links, with batch calls.
+func (drive *Drive) CleanupShareLinks(ctx context.Context, opts ShareCleanupOptions) ([]ShareCleanupResult, error) {
+	links, err := drive.ListShareLinks(ctx, ShareListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ShareCleanupResult
+	for _, link := range links {
+		switch {
+		case opts.Expired && link.IsExpired():
+			results = append(results, ShareCleanupResult{Link: link, Reason: "expired"})
+		case opts.Orphaned:
+			orphaned, err := drive.isOrphaned(ctx, &link)
+			if err != nil {
+				return nil, err
+			}
+			if orphaned {
+				results = append(results, ShareCleanupResult{Link: link, Reason: "orphaned"})
+			}
+		}
+	}
+	if opts.DryRun {
+		return results, nil
+	}
+
+	for start := 0; start < len(results); start += maxBatchRequests {
+		end := start + maxBatchRequests
+		if end > len(results) {
+			end = len(results)
+		}
+		if err := drive.cancelBatch(ctx, results[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// isOrphaned reports whether all the files of link were deleted, i.e. Get fails with ErrorNotFound:
+// the files in the recycle bin are still found, their share links are orphaned once the bin is emptied.
+// A link without known files is fetched again, and is not orphaned if they are still unknown.
+func (drive *Drive) isOrphaned(ctx context.Context, link *ShareLink) (bool, error) {
+	fileIds := link.FileIdList
+	if len(fileIds) == 0 {
+		info, err := drive.GetShareInfo(ctx, link.ShareId)
+		if err != nil {
+			return false, errors.Wrapf(err, `failed to check the files of share link "%s"`, link.ShareId)
+		}
+		fileIds = info.FileIdList
+	}
+	if len(fileIds) == 0 {
+		return false, nil
+	}
+
+	for _, fileId := range fileIds {
+		_, err := drive.Get(ctx, fileId)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, ErrorNotFound) {
+			return false, errors.Wrapf(err, `failed to check the files of share link "%s"`, link.ShareId)
+		}
+	}
+	return true, nil
+}
+
+func (drive *Drive) cancelBatch(ctx context.Context, results []ShareCleanupResult) error {
+	requests := make([]batchRequest, len(results))
+	for i := range results {
+		requests[i] = batchRequest{
+			Id:      strconv.Itoa(i),
+			Method:  "POST",
+			Url:     "/share_link/cancel",
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    map[string]string{"share_id": results[i].Link.ShareId},
+		}
+	}
+
+	body := map[string]interface{}{
+		"requests": requests,
+		"resource": "file",
+	}
+	var result struct {
+		Responses []batchResponse `json:"responses"`
+	}
+	if err := drive.jsonRequest(ctx, "POST", apiBatch, &body, &result); err != nil {
+		return errors.Wrap(err, "failed to cancel share links")
+	}
+
+	answered := make(map[int]bool)
+	for _, res := range result.Responses {
+		i, err := strconv.Atoi(res.Id)
+		if err != nil || i < 0 || i >= len(results) {
+			continue
+		}
+		answered[i] = true
+		if res.Status >= 400 {
+			results[i].Err = newAPIError("POST", apiBatch, res.Status, res.Body)
+		}
+	}
+	for i := range results {
+		if !answered[i] {
+			results[i].Err = errors.Errorf(`no response for "%s"`, results[i].Link.ShareId)
+		}
+	}
+	return nil
+}
+
+// GetShareLinkByAnonymous returns the public information of a share link: name, creator,
+// expiration and file list.
+func (drive *Drive) GetShareLinkByAnonymous(ctx context.Context, shareID string) (*ShareLink, error) {
+	body := map[string]string{
+		"share_id": shareID,
+	}
+	var result ShareLink
+	err := drive.jsonRequest(ctx, "POST", apiGetShareLinkByAnonymous, &body, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get share link by shareID")
+	}
+	result.ShareId = shareID
+	if result.Url == "" {
+		result.Url = shareUrl + shareID
+	}
+	return &result, nil
+}
@@ -0,0 +1,152 @@
+package drive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShareLinks(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	fileId := srv.Put(drivetest.RootId, "a.txt", []byte("hello"))
+
+	link, err := drive.CreateShareLink(ctx, []Node{{NodeId: fileId}}, "1234", 3600)
+	require.NoError(t, err)
+	assert.NotEmpty(t, link.ShareId)
+	assert.Equal(t, "https://www.aliyundrive.com/s/"+link.ShareId, link.Url)
+	assert.Equal(t, "1234", link.Password)
+	assert.Equal(t, "a.txt", link.Name)
+	assert.Equal(t, "enabled", link.Status)
+	assert.Equal(t, []string{fileId}, link.FileIdList)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), link.Expiration, time.Minute)
+	assert.False(t, link.Created.IsZero())
+
+	forever, err := drive.CreateShareLink(ctx, []Node{{NodeId: fileId}}, "", 0)
+	require.NoError(t, err)
+	assert.True(t, forever.Expiration.IsZero())
+
+	info, err := drive.GetShareInfo(ctx, link.ShareId)
+	require.NoError(t, err)
+	assert.Equal(t, link.ShareId, info.ShareId)
+	assert.Equal(t, link.Expiration, info.Expiration)
+
+	token, err := drive.GetShareToken(ctx, "1234", link.ShareId)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.Token)
+	assert.Equal(t, int64(7200), token.ExpiresIn)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), token.Expiration, time.Minute)
+	_, err = drive.GetShareToken(ctx, "wrong", link.ShareId)
+	assert.Error(t, err)
+	token, err = drive.GetShareToken(ctx, "", forever.ShareId)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.Token)
+
+	info, err = drive.GetShareInfo(ctx, link.ShareId)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), info.PreviewCount)
+
+	anonymous, err := drive.GetShareLinkByAnonymous(ctx, link.ShareId)
+	require.NoError(t, err)
+	assert.Equal(t, link.ShareId, anonymous.ShareId)
+	assert.Equal(t, link.Url, anonymous.Url)
+	assert.Equal(t, "tester", anonymous.CreatorName)
+	assert.Equal(t, []string{fileId}, anonymous.FileIdList)
+	assert.Equal(t, link.Expiration, anonymous.Expiration)
+
+	links, err := drive.ListShareLinks(ctx, ShareListOptions{})
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	assert.Equal(t, link.ShareId, links[0].ShareId)
+
+	require.NoError(t, drive.CancelShareLink(ctx, forever.ShareId))
+	links, err = drive.ListShareLinks(ctx, ShareListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+	_, err = drive.GetShareToken(ctx, "", forever.ShareId)
+	assert.ErrorIs(t, err, ErrorShareExpired)
+	_, err = drive.GetShareInfo(ctx, "missing")
+	assert.ErrorIs(t, err, ErrorNotFound)
+}
+
+func TestShareLinksManagement(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	aId := srv.Put(drivetest.RootId, "a.txt", []byte("a"))
+	bId := srv.Put(drivetest.RootId, "b.txt", []byte("b"))
+
+	var ids []string
+	for _, fileId := range []string{bId, aId, bId} {
+		link, err := drive.CreateShareLink(ctx, []Node{{NodeId: fileId}}, "", 0)
+		require.NoError(t, err)
+		ids = append(ids, link.ShareId)
+	}
+
+	shareIds := func(opts ShareListOptions) []string {
+		links, err := drive.ListShareLinks(ctx, opts)
+		require.NoError(t, err)
+		var ids []string
+		for _, link := range links {
+			ids = append(ids, link.ShareId)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{ids[1], ids[0], ids[2]}, shareIds(ShareListOptions{}))
+	assert.Equal(t, []string{ids[2], ids[0], ids[1]}, shareIds(ShareListOptions{Descending: true}))
+	assert.Equal(t, []string{ids[0], ids[2]}, shareIds(ShareListOptions{FileId: bId}))
+	assert.Equal(t, ids, shareIds(ShareListOptions{OrderBy: "created_at", CreatorId: drivetest.UserId}))
+	assert.Empty(t, shareIds(ShareListOptions{CreatorId: "someone"}))
+
+	// pages are fetched one at a time
+	p := drive.ShareLinks(ShareListOptions{Limit: 2})
+	var pages int
+	for p.Next() {
+		links, err := p.ShareLinks(ctx)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(links), 2)
+		pages++
+	}
+	assert.Equal(t, 2, pages)
+
+	pwd, description := "abcd", "holiday photos"
+	updated, err := drive.UpdateShareLink(ctx, ids[0], ShareLinkUpdate{Password: &pwd, Description: &description})
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", updated.Password)
+	assert.Equal(t, "holiday photos", updated.Description)
+	assert.True(t, updated.Expiration.IsZero())
+	assert.False(t, updated.Updated.Before(updated.Created))
+
+	past := time.Now().Add(-time.Hour)
+	updated, err = drive.UpdateShareLink(ctx, ids[0], ShareLinkUpdate{Expiration: &past})
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", updated.Password)
+	assert.True(t, updated.IsExpired())
+	assert.Equal(t, []string{ids[0]}, shareIds(ShareListOptions{OnlyExpired: true}))
+	assert.Equal(t, []string{ids[1], ids[2]}, shareIds(ShareListOptions{SkipExpired: true}))
+	_, err = drive.UpdateShareLink(ctx, "missing", ShareLinkUpdate{Description: &description})
+	assert.ErrorIs(t, err, ErrorNotFound)
+
+	require.NoError(t, drive.Remove(ctx, aId))
+	// links without known files are not orphaned
+	srv.ModifyShare(ids[2], func(sh *drivetest.Share) { sh.FileIds = nil })
+	results, err := drive.CleanupShareLinks(ctx, ShareCleanupOptions{Expired: true, Orphaned: true, DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Len(t, shareIds(ShareListOptions{}), 3)
+
+	results, err = drive.CleanupShareLinks(ctx, ShareCleanupOptions{Expired: true, Orphaned: true})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	reasons := map[string]string{}
+	for _, res := range results {
+		assert.NoError(t, res.Err)
+		reasons[res.Link.ShareId] = res.Reason
+	}
+	assert.Equal(t, map[string]string{ids[0]: "expired", ids[1]: "orphaned"}, reasons)
+	assert.Equal(t, []string{ids[2]}, shareIds(ShareListOptions{}))
+	assert.True(t, srv.Share(ids[0]).Cancelled)
+}
@@ -0,0 +1,284 @@
+package drive
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiListByShare          = "https://api.aliyundrive.com/adrive/v2/file/list_by_share"
+	apiGetByShare           = "https://api.aliyundrive.com/adrive/v2/file/get_by_share"
+	apiGetShareDownloadUrl  = "https://api.aliyundrive.com/v2/file/get_share_link_download_url"
+	shareTokenRefreshMargin = time.Minute
+)
+
+// ShareFs is a read-only Fs of the files of a share link, usually someone else's, see Drive.OpenShare.
+//
+// Its root is the folder holding the shared files. Methods writing to the drive return ErrorReadOnly,
+// the ones the share API can't serve return ErrorNotSupported. Downloads go through the share
+// download urls, the share token is renewed when it expires.
+type ShareFs struct {
+	drive    *Drive
+	shareId  string
+	password string
+	rootNode Node
+
+	// mutex guards token
+	mutex sync.Mutex
+	token *ShareToken
+}
+
+var (
+	_ Fs                = (*ShareFs)(nil)
+	_ LimitReporter     = (*ShareFs)(nil)
+	_ DownloadUrlGetter = (*ShareFs)(nil)
+)
+
+// OpenShare returns a read-only view of the files of a share link, pwd is empty for
+// share links without password.
+func (drive *Drive) OpenShare(ctx context.Context, shareID string, pwd string) (*ShareFs, error) {
+	s := &ShareFs{drive: drive, shareId: shareID, password: pwd}
+	if _, err := s.shareToken(ctx); err != nil {
+		return nil, err
+	}
+
+	link, err := drive.GetShareLinkByAnonymous(ctx, shareID)
+	if err != nil {
+		return nil, err
+	}
+	s.rootNode = Node{NodeId: "root", Type: FolderKind, Name: link.Name}
+	return s, nil
+}
+
+// ShareId returns the id of the share link.
+func (s *ShareFs) ShareId() string {
+	return s.shareId
+}
+
+// shareToken returns the share token, a new one is requested when it is about to expire.
+func (s *ShareFs) shareToken(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != nil && time.Now().Add(shareTokenRefreshMargin).Before(s.token.Expiration) {
+		return s.token.Token, nil
+	}
+
+	token, err := s.drive.GetShareToken(ctx, s.password, s.shareId)
+	if err != nil {
+		return "", errors.Wrapf(err, `failed to open share "%s"`, s.shareId)
+	}
+	s.token = token
+	return token.Token, nil
+}
+
+// jsonRequest is Drive.jsonRequest with the share token.
+func (s *ShareFs) jsonRequest(ctx context.Context, url string, request interface{}, response interface{}) error {
+	token, err := s.shareToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.drive.refreshToken(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.drive.createDeviceSession(ctx); err != nil {
+		return err
+	}
+
+	headers := s.drive.authHeaders()
+	headers["x-share-token"] = token
+	return s.drive.jsonRequestNoExpireCheck(ctx, "POST", url, headers, request, response)
+}
+
+func (s *ShareFs) Get(ctx context.Context, nodeId string) (*Node, error) {
+	if nodeId == s.rootNode.NodeId {
+		root := s.rootNode
+		return &root, nil
+	}
+
+	data := map[string]interface{}{
+		"share_id": s.shareId,
+		"file_id":  nodeId,
+		"fields":   "*",
+	}
+	var node Node
+	err := s.jsonRequest(ctx, apiGetByShare, &data, &node)
+	if err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+// GetByPath lists the folders of fullPath, the share API has no lookup by path.
+func (s *ShareFs) GetByPath(ctx context.Context, fullPath string, kind string) (*Node, error) {
+	fullPath = normalizePath(fullPath)
+	node := s.rootNode
+	if fullPath == "/" {
+		return &node, nil
+	}
+
+	names := strings.Split(fullPath[1:], "/")
+	for i, name := range names {
+		nodeKind := FolderKind
+		if i == len(names)-1 {
+			nodeKind = kind
+		}
+
+		nodes, err := s.ListAll(ctx, node.NodeId)
+		if err != nil {
+			return nil, findNodeError(err, fullPath)
+		}
+		found := false
+		for _, n := range nodes {
+			if n.Name == name && (nodeKind == AnyKind || n.Type == nodeKind) {
+				node, found = n, true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Wrapf(ErrorNotFound, `can't find "%s", kind: "%s" in share "%s"`, fullPath, kind, s.shareId)
+		}
+	}
+	return &node, nil
+}
+
+type sharePager struct {
+	share  *ShareFs
+	param  map[string]interface{}
+	lNodes *ListNodes
+}
+
+func (p *sharePager) Next() bool {
+	return p.lNodes == nil || p.lNodes.NextMarker != ""
+}
+
+func (p *sharePager) Nodes(ctx context.Context) ([]Node, error) {
+	p.lNodes = nil
+	err := p.share.jsonRequest(ctx, apiListByShare, &p.param, &p.lNodes)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	p.param["marker"] = p.lNodes.NextMarker
+	return p.lNodes.Items, nil
+}
+
+func (s *ShareFs) List(nodeId string) Pager {
+	param := map[string]interface{}{
+		"share_id":       s.shareId,
+		"parent_file_id": nodeId,
+		"limit":          100,
+		"marker":         "",
+	}
+	return &sharePager{share: s, param: param}
+}
+
+func (s *ShareFs) ListAll(ctx context.Context, nodeId string) ([]Node, error) {
+	p := s.List(nodeId)
+	var nodes []Node
+	for p.Next() {
+		data, err := p.Nodes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, data...)
+	}
+	return nodes, nil
+}
+
+// GetDownloadUrl returns the share download url of a file, cached in node until it expires.
+func (s *ShareFs) GetDownloadUrl(ctx context.Context, node *Node) (*DownloadUrl, error) {
+	unlock := s.drive.downloadMutex.Lock(s.shareId + "/" + node.NodeId)
+	defer unlock()
+
+	if s.drive.needUpdateNodeDownloadUrl(node) {
+		data := map[string]interface{}{
+			"share_id":   s.shareId,
+			"file_id":    node.NodeId,
+			"expire_sec": 600,
+		}
+		var downloadUrl DownloadUrl
+		err := s.jsonRequest(ctx, apiGetShareDownloadUrl, &data, &downloadUrl)
+		if err != nil {
+			return nil, errors.Wrapf(err, `failed to get the download url of "%s"`, node.NodeId)
+		}
+		node.downloadUrl = &downloadUrl
+	}
+
+	return node.downloadUrl, nil
+}
+
+func (s *ShareFs) Open(ctx context.Context, node *Node, headers map[string]string) (io.ReadCloser, error) {
+	if node == nil {
+		return nil, errors.New("node is nil")
+	}
+
+	if node.Type == FolderKind {
+		return nil, errors.New("can't open folder")
+	}
+
+	downloadUrl, err := s.GetDownloadUrl(ctx, node)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+
+	return s.drive.openUrl(ctx, node, downloadUrl, headers)
+}
+
+func (s *ShareFs) CalcProof(fileSize int64, in io.ReaderAt) (string, error) {
+	return s.drive.CalcProof(fileSize, in)
+}
+
+func (s *ShareFs) LimiterState() LimiterState {
+	return s.drive.LimiterState()
+}
+
+func (s *ShareFs) About(ctx context.Context) (*PersonalSpaceInfo, error) {
+	return nil, errors.Wrap(ErrorNotSupported, "share links have no space info")
+}
+
+func (s *ShareFs) Search(ctx context.Context, name string) ([]Node, error) {
+	return nil, errors.Wrap(ErrorNotSupported, "share links can't be searched")
+}
+
+func (s *ShareFs) CreateFolder(ctx context.Context, node Node) (string, error) {
+	return "", errors.WithStack(ErrorReadOnly)
+}
+
+func (s *ShareFs) Move(ctx context.Context, nodeId string, dstParentNodeId string, dstName string) (string, error) {
+	return "", errors.WithStack(ErrorReadOnly)
+}
+
+func (s *ShareFs) Remove(ctx context.Context, nodeId string) error {
+	return errors.WithStack(ErrorReadOnly)
+}
+
+func (s *ShareFs) CreateFile(ctx context.Context, node Node, in io.Reader) (string, error) {
+	return "", errors.WithStack(ErrorReadOnly)
+}
+
+func (s *ShareFs) CreateFileWithProof(ctx context.Context, node Node, in io.Reader, sha1Code string, proofCode string) (string, error) {
+	return "", errors.WithStack(ErrorReadOnly)
+}
+
+func (s *ShareFs) Copy(ctx context.Context, nodeId string, dstParentNodeId string, dstName string) (string, error) {
+	return "", errors.WithStack(ErrorReadOnly)
+}
+
+func (s *ShareFs) CreateFolderRecursively(ctx context.Context, fullPath string) (string, error) {
+	if normalizePath(fullPath) == "/" {
+		return s.rootNode.NodeId, nil
+	}
+	return "", errors.WithStack(ErrorReadOnly)
+}
+
+func (s *ShareFs) Update(ctx context.Context, node Node) (string, error) {
+	return "", errors.WithStack(ErrorReadOnly)
+}
@@ -0,0 +1,152 @@
+package drive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShareFs(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	docs := srv.Mkdir(drivetest.RootId, "docs")
+	srv.Put(srv.Mkdir(docs, "sub"), "b.txt", []byte("hello world"))
+	a := srv.Put(drivetest.RootId, "a.txt", []byte("a"))
+	private := srv.Put(drivetest.RootId, "private.txt", []byte("private"))
+	link, err := drive.CreateShareLink(ctx, []Node{{NodeId: docs}, {NodeId: a}}, "1234", 0)
+	require.NoError(t, err)
+
+	_, err = drive.OpenShare(ctx, link.ShareId, "wrong")
+	assert.Error(t, err)
+	share, err := drive.OpenShare(ctx, link.ShareId, "1234")
+	require.NoError(t, err)
+	assert.Equal(t, link.ShareId, share.ShareId())
+
+	root, err := share.GetByPath(ctx, "/", FolderKind)
+	require.NoError(t, err)
+	assert.Equal(t, "docs", root.Name)
+	nodes, err := share.ListAll(ctx, root.NodeId)
+	require.NoError(t, err)
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	assert.ElementsMatch(t, []string{"docs", "a.txt"}, names)
+
+	// the share can be used like a drive through PathFs
+	p := NewPathFs(share, 0)
+	node, err := p.Stat(ctx, "/docs/sub/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), node.Size)
+	got, err := share.Get(ctx, node.NodeId)
+	require.NoError(t, err)
+	assert.Equal(t, "b.txt", got.Name)
+
+	rd, err := p.OpenFile(ctx, "/docs/sub/b.txt", map[string]string{"Range": "bytes=6-"})
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	assert.Equal(t, "world", string(b))
+	assert.Equal(t, 1, srv.Share(link.ShareId).DownloadCount)
+	assert.Zero(t, srv.Requests("/v2/file/get_download_url"), "downloads go through the share")
+
+	// files outside the share can't be read
+	_, err = share.Get(ctx, private)
+	assert.ErrorIs(t, err, ErrorNotFound)
+	_, err = p.Stat(ctx, "/private.txt")
+	assert.ErrorIs(t, err, ErrorNotFound)
+
+	// the share is read-only
+	_, err = share.CreateFolder(ctx, Node{ParentId: root.NodeId, Name: "new"})
+	assert.ErrorIs(t, err, ErrorReadOnly)
+	assert.ErrorIs(t, share.Remove(ctx, a), os.ErrPermission)
+	_, err = p.Create(ctx, "/new.txt", nil, 0)
+	assert.ErrorIs(t, err, ErrorReadOnly)
+
+	// the share token is renewed before it expires
+	tokens := srv.Requests("/v2/share_link/get_share_token")
+	share.token.Expiration = time.Now()
+	_, err = share.ListAll(ctx, root.NodeId)
+	require.NoError(t, err)
+	assert.Equal(t, tokens+1, srv.Requests("/v2/share_link/get_share_token"))
+
+	require.NoError(t, drive.CancelShareLink(ctx, link.ShareId))
+	_, err = share.ListAll(ctx, root.NodeId)
+	assert.ErrorIs(t, err, ErrorShareExpired)
+}
+
+func TestShareFsSave(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	docs := srv.Mkdir(drivetest.RootId, "docs")
+	srv.Put(srv.Mkdir(docs, "sub"), "b.txt", []byte("world"))
+	srv.Put(drivetest.RootId, "a.txt", []byte("shared"))
+	link, err := drive.CreateShareLink(ctx, []Node{{NodeId: docs}, {NodeId: srv.Lookup("/a.txt").FileId}}, "", 0)
+	require.NoError(t, err)
+	saved := srv.Mkdir(drivetest.RootId, "saved")
+	srv.Put(saved, "a.txt", []byte("mine"))
+
+	share, err := drive.OpenShare(ctx, link.ShareId, "")
+	require.NoError(t, err)
+	nodes, err := share.ListAll(ctx, "root")
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	save := func(mode string, nodes ...Node) []SaveResult {
+		results, err := share.Save(ctx, nodes, saved, SaveOptions{CheckNameMode: mode, PollInterval: time.Millisecond})
+		require.NoError(t, err)
+		require.Len(t, results, len(nodes))
+		return results
+	}
+	byName := func(name string) Node {
+		for _, node := range nodes {
+			if node.Name == name {
+				return node
+			}
+		}
+		t.Fatalf("%s not found", name)
+		return Node{}
+	}
+
+	// folders are saved by asynchronous tasks
+	results := save("", byName("docs"), byName("a.txt"))
+	for _, result := range results {
+		require.NoError(t, result.Err, result.Node.Name)
+		assert.NotEmpty(t, result.NodeId)
+	}
+	assert.Equal(t, []byte("world"), srv.ReadAll("/saved/docs/sub/b.txt"))
+	assert.Equal(t, results[0].NodeId, srv.Lookup("/saved/docs").FileId)
+	assert.Equal(t, []byte("shared"), srv.ReadAll("/saved/a(1).txt"))
+	assert.Equal(t, []byte("mine"), srv.ReadAll("/saved/a.txt"))
+	assert.GreaterOrEqual(t, srv.Requests("/v2/async_task/get"), 2)
+
+	results = save(CheckNameRefuse, byName("a.txt"))
+	assert.ErrorIs(t, results[0].Err, ErrorAlreadyExisted)
+	assert.Equal(t, []byte("mine"), srv.ReadAll("/saved/a.txt"))
+
+	results = save(CheckNameOverwrite, byName("a.txt"))
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, []byte("shared"), srv.ReadAll("/saved/a.txt"))
+	assert.Equal(t, results[0].NodeId, srv.Lookup("/saved/a.txt").FileId)
+	assert.Equal(t, 3, srv.Share(link.ShareId).SaveCount)
+	entries, err := drive.ListAll(ctx, saved)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "the overwritten file is gone")
+
+	// the existing file is kept when the copy fails
+	srv.Put(saved, "missing", []byte("mine"))
+	results = save(CheckNameOverwrite, Node{NodeId: "missing", Name: "missing"})
+	assert.ErrorIs(t, results[0].Err, ErrorNotFound)
+	assert.Equal(t, []byte("mine"), srv.ReadAll("/saved/missing"))
+
+	// each node has its own result
+	results = save("", Node{NodeId: "missing", Name: "missing"}, byName("a.txt"))
+	assert.ErrorIs(t, results[0].Err, ErrorNotFound)
+	assert.NoError(t, results[1].Err)
+}
@@ -0,0 +1,229 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiGetAsyncTask = "https://api.aliyundrive.com/v2/async_task/get"
+
+	// maxBatchRequests is the number of requests accepted by a single batch call
+	maxBatchRequests = 100
+)
+
+// The name conflict modes of ShareFs.Save, when a file with the same name exists in the target folder.
+const (
+	// CheckNameAutoRename saves the file with a new name, e.g. "a(1).txt"
+	CheckNameAutoRename = "auto_rename"
+	// CheckNameRefuse keeps the existing file, the file is not saved
+	CheckNameRefuse = "refuse"
+	// CheckNameOverwrite moves the existing file to the recycle bin once the file is saved
+	CheckNameOverwrite = "overwrite"
+)
+
+type SaveOptions struct {
+	// CheckNameMode is one of the CheckName* modes, CheckNameAutoRename by default.
+	CheckNameMode string
+	// PollInterval is the delay between checks of the server side copies of large folders, 1s by default.
+	PollInterval time.Duration
+}
+
+// SaveResult is the outcome of saving a node of a share link.
+type SaveResult struct {
+	Node Node
+	// NodeId is the id of the saved copy in the drive
+	NodeId string
+	// Err is ErrorAlreadyExisted for the nodes refused by CheckNameRefuse
+	Err error
+}
+
+type batchRequest struct {
+	Id      string            `json:"id"`
+	Method  string            `json:"method"`
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    interface{}       `json:"body"`
+}
+
+type batchResponse struct {
+	Id     string          `json:"id"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+type asyncTask struct {
+	State   string `json:"state"` // Running | Succeed | Failed
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Save copies nodes of the share link to the folder dstParentId of the drive, on the server side.
+//
+// The copies of large folders run as asynchronous tasks on the server, Save waits for them.
+// The returned results follow the order of nodes, err is only set when Save couldn't run.
+func (s *ShareFs) Save(ctx context.Context, nodes []Node, dstParentId string, opts SaveOptions) ([]SaveResult, error) {
+	if opts.CheckNameMode == "" {
+		opts.CheckNameMode = CheckNameAutoRename
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	results := make([]SaveResult, len(nodes))
+	for i, node := range nodes {
+		results[i].Node = node
+	}
+
+	// the batch API only renames on conflicts, the other modes are applied here:
+	// overwritten nodes are saved with a new name, then replace the existing ones
+	replaced := make(map[int]Node)
+	if opts.CheckNameMode != CheckNameAutoRename {
+		existing, err := s.drive.ListAll(ctx, dstParentId)
+		if err != nil {
+			return nil, errors.Wrapf(err, `failed to list "%s"`, dstParentId)
+		}
+		byName := make(map[string]Node, len(existing))
+		for _, node := range existing {
+			byName[node.Name] = node
+		}
+
+		for i := range results {
+			old, ok := byName[results[i].Node.Name]
+			switch {
+			case !ok:
+			case opts.CheckNameMode == CheckNameRefuse:
+				results[i].Err = errors.Wrapf(ErrorAlreadyExisted, `"%s"`, old.Name)
+			case opts.CheckNameMode == CheckNameOverwrite:
+				replaced[i] = old
+			default:
+				return nil, errors.Errorf(`unknown check name mode "%s"`, opts.CheckNameMode)
+			}
+		}
+	}
+
+	var pending []int
+	for i := range results {
+		if results[i].Err == nil {
+			pending = append(pending, i)
+		}
+	}
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > maxBatchRequests {
+			n = maxBatchRequests
+		}
+		if err := s.saveBatch(ctx, results, pending[:n], dstParentId, opts); err != nil {
+			return nil, err
+		}
+		pending = pending[n:]
+	}
+
+	for i, old := range replaced {
+		if results[i].Err == nil {
+			results[i].Err = s.replace(ctx, old, results[i].NodeId)
+		}
+	}
+	return results, nil
+}
+
+// replace moves old to the recycle bin and gives its name to the saved copy nodeId.
+func (s *ShareFs) replace(ctx context.Context, old Node, nodeId string) error {
+	if err := s.drive.Remove(ctx, old.NodeId); err != nil {
+		return errors.Wrapf(err, `failed to overwrite "%s"`, old.Name)
+	}
+	if _, err := s.drive.UpdateNode(ctx, nodeId, NodeUpdate{Name: &old.Name}); err != nil {
+		return errors.Wrapf(err, `failed to rename the saved copy of "%s"`, old.Name)
+	}
+	return nil
+}
+
+// saveBatch copies the nodes of results at indexes with a single batch call.
+func (s *ShareFs) saveBatch(ctx context.Context, results []SaveResult, indexes []int, dstParentId string, opts SaveOptions) error {
+	requests := make([]batchRequest, len(indexes))
+	for i, index := range indexes {
+		requests[i] = batchRequest{
+			Id:      strconv.Itoa(index),
+			Method:  "POST",
+			Url:     "/file/copy",
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body: map[string]interface{}{
+				"file_id":           results[index].Node.NodeId,
+				"share_id":          s.shareId,
+				"auto_rename":       true,
+				"to_parent_file_id": dstParentId,
+				"to_drive_id":       s.drive.driveId,
+			},
+		}
+	}
+
+	body := map[string]interface{}{
+		"requests": requests,
+		"resource": "file",
+	}
+	var result struct {
+		Responses []batchResponse `json:"responses"`
+	}
+	if err := s.jsonRequest(ctx, apiBatch, &body, &result); err != nil {
+		return errors.Wrap(err, "failed to save share files")
+	}
+
+	answered := make(map[int]bool)
+	for _, res := range result.Responses {
+		index, err := strconv.Atoi(res.Id)
+		if err != nil || index < 0 || index >= len(results) {
+			continue
+		}
+		answered[index] = true
+		results[index].NodeId, results[index].Err = s.saveResult(ctx, res, opts.PollInterval)
+	}
+	for _, index := range indexes {
+		if !answered[index] {
+			results[index].Err = errors.Errorf(`no response for "%s"`, results[index].Node.Name)
+		}
+	}
+	return nil
+}
+
+// saveResult returns the id of the copy made for res, waiting for its asynchronous task if any.
+func (s *ShareFs) saveResult(ctx context.Context, res batchResponse, pollInterval time.Duration) (string, error) {
+	if res.Status >= 400 {
+		return "", newAPIError("POST", apiBatch, res.Status, res.Body)
+	}
+
+	var copied struct {
+		FileId      string `json:"file_id"`
+		AsyncTaskId string `json:"async_task_id"`
+	}
+	if err := json.Unmarshal(res.Body, &copied); err != nil {
+		return "", errors.Wrapf(err, `failed to parse response "%s"`, string(res.Body))
+	}
+	if copied.AsyncTaskId == "" {
+		return copied.FileId, nil
+	}
+
+	for {
+		var task asyncTask
+		body := map[string]string{"async_task_id": copied.AsyncTaskId}
+		if err := s.drive.jsonRequest(ctx, "POST", apiGetAsyncTask, &body, &task); err != nil {
+			return copied.FileId, errors.Wrapf(err, `failed to get task "%s"`, copied.AsyncTaskId)
+		}
+
+		switch task.State {
+		case "Succeed":
+			return copied.FileId, nil
+		case "Failed":
+			return copied.FileId, errors.Errorf(`task "%s" failed: %s %s`, copied.AsyncTaskId, task.Status, task.Message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return copied.FileId, errors.WithStack(ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package drive
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// starredQuery is the search query of the starred nodes.
+const starredQuery = "starred = true"
+
+// NodeUpdate lists the fields changed by UpdateNode, nil fields are kept.
+type NodeUpdate struct {
+	Name *string
+	Meta *string
+	// Starred nodes are listed by Starred, whatever their folder.
+	Starred     *bool
+	Description *string
+	// Labels replaces the custom labels of the node, an empty slice removes them.
+	Labels *[]string
+	Hidden *bool
+	// LocalCreated and LocalModified are the times of the local file, see Node.LocalModified.
+	LocalCreated  *time.Time
+	LocalModified *time.Time
+}
+
+// UpdateNode only changes the fields set in update, starred, description, labels and hidden included.
+func (drive *Drive) UpdateNode(ctx context.Context, nodeId string, update NodeUpdate) (*Node, error) {
+	if err := drive.checkRoot(nodeId); err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"drive_id": drive.driveId,
+		"file_id":  nodeId,
+	}
+	if update.Name != nil {
+		if *update.Name == "" {
+			return nil, errors.Wrap(ErrorMissingFields, "the name of a node can't be empty")
+		}
+		body["name"] = *update.Name
+	}
+	if update.Meta != nil {
+		body["meta"] = *update.Meta
+	}
+	if update.Starred != nil {
+		body["starred"] = *update.Starred
+	}
+	if update.Description != nil {
+		body["description"] = *update.Description
+	}
+	if update.Labels != nil {
+		labels := *update.Labels
+		if labels == nil {
+			labels = []string{}
+		}
+		body["labels"] = labels
+	}
+	if update.Hidden != nil {
+		body["hidden"] = *update.Hidden
+	}
+	if update.LocalCreated != nil {
+		body["local_created_at"] = formatTime(*update.LocalCreated)
+	}
+	if update.LocalModified != nil {
+		body["local_modified_at"] = formatTime(*update.LocalModified)
+	}
+
+	var node Node
+	err := drive.jsonRequest(ctx, "POST", apiUpdate, &body, &node)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to update "%s"`, nodeId)
+	}
+	return &node, nil
+}
+
+// Update renames node and sets its meta and local times, the empty ones are kept,
+// use UpdateNode to clear them.
+func (drive *Drive) Update(ctx context.Context, node Node) (string, error) {
+	var update NodeUpdate
+	if node.Meta != "" {
+		update.Meta = &node.Meta
+	}
+	if node.Name != "" {
+		update.Name = &node.Name
+	}
+	if !node.LocalCreated.IsZero() {
+		update.LocalCreated = &node.LocalCreated
+	}
+	if !node.LocalModified.IsZero() {
+		update.LocalModified = &node.LocalModified
+	}
+
+	updated, err := drive.UpdateNode(ctx, node.NodeId, update)
+	if err != nil {
+		return "", err
+	}
+	return updated.NodeId, nil
+}
+
+type starredPager struct {
+	drive  *Drive
+	param  map[string]interface{}
+	lNodes *ListNodes
+}
+
+func (p *starredPager) Next() bool {
+	return p.lNodes == nil || p.lNodes.NextMarker != ""
+}
+
+func (p *starredPager) Nodes(ctx context.Context) ([]Node, error) {
+	p.lNodes = nil
+	err := p.drive.jsonRequest(ctx, "POST", apiSearch, &p.param, &p.lNodes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list starred files")
+	}
+
+	p.param["marker"] = p.lNodes.NextMarker
+	return p.lNodes.Items, nil
+}
+
+// Starred lists the starred files and folders of the drive, the most recently updated first.
+func (drive *Drive) Starred() Pager {
+	param := map[string]interface{}{
+		"drive_id": drive.driveId,
+		"limit":    100,
+		"query":    starredQuery,
+		"order_by": "updated_at DESC",
+		"marker":   "",
+	}
+	return &starredPager{drive: drive, param: param}
+}
+
+func (drive *Drive) ListStarred(ctx context.Context) ([]Node, error) {
+	p := drive.Starred()
+	var nodes []Node
+	for p.Next() {
+		data, err := p.Nodes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, data...)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,67 @@
+package drive
+
+import (
+	"context"
+	"testing"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateNode(t *testing.T) {
+	ctx := context.Background()
+	drive, srv := newTestDrive(t, Config{})
+	aId := srv.Put(drivetest.RootId, "a.txt", []byte("a"))
+	bId := srv.Put(drivetest.RootId, "b.txt", []byte("b"))
+	srv.Modify(aId, func(f *drivetest.File) { f.Meta = "meta" })
+
+	starred, description, labels := true, "keep forever", []string{"config", "backup"}
+	node, err := drive.UpdateNode(ctx, aId, NodeUpdate{Starred: &starred, Description: &description, Labels: &labels})
+	require.NoError(t, err)
+	assert.True(t, node.Starred)
+	assert.Equal(t, description, node.Description)
+	assert.Equal(t, labels, node.Labels)
+	a := srv.File(aId)
+	assert.Equal(t, "a.txt", a.Name, "only the given fields are changed")
+	assert.Equal(t, "meta", a.Meta)
+	assert.Equal(t, labels, a.Labels)
+
+	hidden, none := true, []string{}
+	node, err = drive.UpdateNode(ctx, aId, NodeUpdate{Hidden: &hidden, Labels: &none})
+	require.NoError(t, err)
+	assert.True(t, node.Hidden)
+	assert.True(t, node.Starred)
+	assert.Empty(t, node.Labels)
+
+	empty := ""
+	_, err = drive.UpdateNode(ctx, aId, NodeUpdate{Name: &empty})
+	assert.ErrorIs(t, err, ErrorMissingFields)
+
+	// Update doesn't rename nodes without name
+	_, err = drive.Update(ctx, Node{NodeId: bId, Meta: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, "b.txt", srv.File(bId).Name)
+	assert.Equal(t, "b", srv.File(bId).Meta)
+	_, err = drive.Update(ctx, Node{NodeId: bId, Name: "c.txt"})
+	require.NoError(t, err)
+	assert.Equal(t, "b", srv.File(bId).Meta, "the meta is kept")
+	_, err = drive.UpdateNode(ctx, bId, NodeUpdate{Meta: &empty})
+	require.NoError(t, err)
+	assert.Empty(t, srv.File(bId).Meta)
+
+	_, err = drive.UpdateNode(ctx, bId, NodeUpdate{Starred: &starred})
+	require.NoError(t, err)
+	nodes, err := drive.ListStarred(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, []string{bId, aId}, []string{nodes[0].NodeId, nodes[1].NodeId}, "the most recently updated first")
+
+	unstarred := false
+	_, err = drive.UpdateNode(ctx, aId, NodeUpdate{Starred: &unstarred})
+	require.NoError(t, err)
+	nodes, err = drive.ListStarred(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	assert.Equal(t, "c.txt", nodes[0].Name)
+}
@@ -0,0 +1,184 @@
+package drive
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const apiGetUploadUrl = "https://api.aliyundrive.com/v2/file/get_upload_url"
+
+// Upload is a file being uploaded part by part, see CreateUpload.
+type Upload struct {
+	FileId   string
+	UploadId string
+	// RapidUpload is true if the content was already on the server,
+	// the file is created and there is nothing left to upload.
+	RapidUpload bool
+
+	mutex sync.Mutex
+	parts map[int]PartInfo
+}
+
+// CreateUpload starts uploading a file of partCount parts, it is the first step of CreateFileWithProof.
+//
+// sha1Code and proofCode may be empty, then rapid upload is not attempted.
+// partCount may be 0 if it isn't known yet, upload urls are requested by UploadPart as needed.
+//
+// required Node fields: ParentId, Name.
+//
+// may return ErrorMissingFields if required fields are missing,
+// ErrorAlreadyExisted if a file with the same name already exists.
+func (drive *Drive) CreateUpload(ctx context.Context, node Node, sha1Code string, proofCode string, partCount int) (*Upload, error) {
+	return drive.createUpload(ctx, node, sha1Code, proofCode, partCount, CheckNameRefuse)
+}
+
+// ReplaceUpload starts uploading a file over the file of the same name, which keeps its content
+// until the upload is completed.
+func (drive *Drive) ReplaceUpload(ctx context.Context, node Node, sha1Code string, proofCode string, partCount int) (*Upload, error) {
+	return drive.createUpload(ctx, node, sha1Code, proofCode, partCount, CheckNameOverwrite)
+}
+
+// createUpload is CreateUpload with the checkNameMode of an existing file of the same name.
+func (drive *Drive) createUpload(ctx context.Context, node Node, sha1Code string, proofCode string, partCount int, checkNameMode string) (*Upload, error) {
+	if err := createCheck(node); err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(strings.ToLower(node.Name), ".livp") {
+		return nil, ErrorLivpUpload
+	}
+
+	partInfoList := make([]*PartInfo, partCount)
+	for i := range partInfoList {
+		partInfoList[i] = &PartInfo{PartNumber: i + 1}
+	}
+
+	proof := &FileProof{
+		DriveID:         drive.driveId,
+		PartInfoList:    partInfoList,
+		ParentFileID:    node.ParentId,
+		Name:            node.Name,
+		Type:            "file",
+		CheckNameMode:   checkNameMode,
+		Size:            node.Size,
+		ContentHash:     sha1Code,
+		ContentHashName: "sha1",
+		ProofCode:       proofCode,
+		ProofVersion:    "v1",
+		Meta:            node.Meta,
+		LocalCreatedAt:  formatTime(node.LocalCreated),
+		LocalModifiedAt: formatTime(node.LocalModified),
+	}
+	var proofResult ProofResult
+	err := drive.jsonRequest(ctx, "POST", apiCreateFileWithProof, proof, &proofResult)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to post create file request`)
+	}
+
+	if proofResult.Exist {
+		return nil, ErrorAlreadyExisted
+	}
+
+	upload := &Upload{
+		FileId:      proofResult.FileId,
+		UploadId:    proofResult.UploadId,
+		RapidUpload: proofResult.RapidUpload,
+		parts:       make(map[int]PartInfo),
+	}
+	for _, part := range proofResult.PartInfoList {
+		upload.parts[part.PartNumber] = part
+	}
+	return upload, nil
+}
+
+func (drive *Drive) getUploadUrl(ctx context.Context, upload *Upload, partNumber int) (*PartInfo, error) {
+	body := map[string]interface{}{
+		"drive_id":       drive.driveId,
+		"file_id":        upload.FileId,
+		"upload_id":      upload.UploadId,
+		"part_info_list": []PartInfo{{PartNumber: partNumber}},
+	}
+	var result ProofResult
+	err := drive.jsonRequest(ctx, "POST", apiGetUploadUrl, &body, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get upload url")
+	}
+
+	for _, part := range result.PartInfoList {
+		if part.PartNumber == partNumber {
+			return &part, nil
+		}
+	}
+	return nil, errors.Errorf("no upload url for part %d", partNumber)
+}
+
+// UploadPart uploads part partNumber (starting at 1) of upload.
+//
+// Parts must be uploaded in order, each part is at most MaxPartSize.
+func (drive *Drive) UploadPart(ctx context.Context, upload *Upload, partNumber int, in io.Reader) error {
+	upload.mutex.Lock()
+	part, ok := upload.parts[partNumber]
+	upload.mutex.Unlock()
+	if !ok {
+		p, err := drive.getUploadUrl(ctx, upload, partNumber)
+		if err != nil {
+			return err
+		}
+
+		part = *p
+		upload.mutex.Lock()
+		upload.parts[partNumber] = part
+		upload.mutex.Unlock()
+	}
+
+	uploadUrl := part.UploadUrl
+	if drive.config.UseInternalUrl {
+		uploadUrl = part.InternalUploadURL
+	}
+
+	if err := drive.uploadSlots.Acquire(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	defer drive.uploadSlots.Release()
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadUrl, in)
+	if err != nil {
+		return errors.Wrap(err, "failed to create upload request")
+	}
+	resp, err := drive.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to upload file")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return errors.Wrapf(newAPIError("PUT", uploadUrl, resp.StatusCode, b), "failed to upload part %d", partNumber)
+	}
+	return nil
+}
+
+// CompleteUpload finishes upload after all its parts are uploaded, and returns the id of the file.
+func (drive *Drive) CompleteUpload(ctx context.Context, upload *Upload) (string, error) {
+	if upload.RapidUpload {
+		return upload.FileId, nil
+	}
+
+	body := map[string]interface{}{
+		"drive_id":  drive.driveId,
+		"file_id":   upload.FileId,
+		"upload_id": upload.UploadId,
+	}
+	var result NodeId
+	err := drive.jsonRequest(ctx, "POST", apiCompleteUpload, &body, &result)
+	if err != nil {
+		return "", errors.Wrap(err, `failed to post upload complete request`)
+	}
+	return result.NodeId, nil
+}
@@ -0,0 +1,285 @@
+// Package iofs exposes an aliyun drive as an io/fs file system.
+//
+// It can be used with standard library tools like fs.WalkDir, fs.Glob,
+// http.FS or template.ParseFS:
+//
+//	fsys := iofs.New(ctx, driveFs)
+//	http.Handle("/", http.FileServer(http.FS(fsys)))
+package iofs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+// FS implements fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS on top of a drive.Fs.
+type FS struct {
+	ctx context.Context
+	fs  drive.Fs
+}
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// New creates a FS, ctx is used for all the requests made by the FS and its files.
+func New(ctx context.Context, fsys drive.Fs) *FS {
+	return &FS{ctx: ctx, fs: fsys}
+}
+
+func (f *FS) get(op string, name string) (*drive.Node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	fullPath := "/" + name
+	if name == "." {
+		fullPath = "/"
+	}
+
+	node, err := f.fs.GetByPath(f.ctx, fullPath, drive.AnyKind)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	if name == "." {
+		node.Name = "."
+	}
+	return node, nil
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	node, err := f.get("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.IsDirectory() {
+		return &dir{fs: f, node: node, name: name}, nil
+	}
+
+	return &file{Reader: drive.NewReader(f.ctx, f.fs, node), node: node, name: name}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	node, err := f.get("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return FileInfo(node), nil
+}
+
+func (f *FS) readDir(name string, node *drive.Node) ([]fs.DirEntry, error) {
+	nodes, err := f.fs.ListAll(f.ctx, node.NodeId)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	entries := make([]fs.DirEntry, len(nodes))
+	for i := range nodes {
+		entries[i] = fs.FileInfoToDirEntry(FileInfo(&nodes[i]))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := f.get("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !node.IsDirectory() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return f.readDir(name, node)
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	node, err := f.get("read", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.IsDirectory() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+
+	if node.Size == 0 {
+		return []byte{}, nil
+	}
+
+	rd, err := f.fs.Open(f.ctx, node, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	defer rd.Close()
+
+	return ioutil.ReadAll(rd)
+}
+
+type fileInfo struct {
+	node *drive.Node
+}
+
+// FileInfo maps node to a fs.FileInfo, Sys returns node.
+//
+// The permission bits are parsed from node.Meta as an octal number (e.g. "644"),
+// 0755 for folders and 0644 for files are used if Meta isn't set.
+func FileInfo(node *drive.Node) fs.FileInfo {
+	return fileInfo{node: node}
+}
+
+func (fi fileInfo) Name() string {
+	return fi.node.Name
+}
+
+func (fi fileInfo) Size() int64 {
+	return fi.node.Size
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(0644)
+	if fi.node.IsDirectory() {
+		mode = 0755
+	}
+
+	if fi.node.Meta != "" {
+		if perm, err := strconv.ParseUint(fi.node.Meta, 8, 32); err == nil {
+			mode = fs.FileMode(perm) & fs.ModePerm
+		}
+	}
+
+	if fi.node.IsDirectory() {
+		mode |= fs.ModeDir
+	}
+	return mode
+}
+
+func (fi fileInfo) ModTime() time.Time {
+	return fi.node.ModTime()
+}
+
+func (fi fileInfo) IsDir() bool {
+	return fi.node.IsDirectory()
+}
+
+func (fi fileInfo) Sys() interface{} {
+	return fi.node
+}
+
+// file is a regular file, its content is downloaded lazily from the current offset.
+type file struct {
+	*drive.Reader
+	node   *drive.Node
+	name   string
+	closed bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return FileInfo(f.node), nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	n, err := f.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	n, err := f.Reader.Seek(offset, whence)
+	if err != nil {
+		err = &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return n, err
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.closed = true
+	return f.Reader.Close()
+}
+
+type dir struct {
+	fs      *FS
+	node    *drive.Node
+	name    string
+	entries []fs.DirEntry
+	read    bool
+	closed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return FileInfo(d.node), nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	if !d.read {
+		entries, err := d.fs.readDir(d.name, d.node)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,50 @@
+package iofs
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	srv := drivetest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	fsys, err := drive.NewFs(ctx, &drive.Config{RefreshToken: "token", DeviceId: "device", HttpClient: srv.Client()})
+	require.NoError(t, err)
+
+	docs := srv.Mkdir(drivetest.RootId, "docs")
+	srv.Put(drivetest.RootId, "README.md", []byte("# readme\n"))
+	srv.Put(docs, "a.txt", []byte("hello world"))
+	srv.Put(docs, "empty.txt", nil)
+	srv.Mkdir(srv.Mkdir(docs, "sub"), "deeper")
+
+	f := New(ctx, fsys)
+	require.NoError(t, fstest.TestFS(f, "README.md", "docs/a.txt", "docs/empty.txt", "docs/sub/deeper"))
+
+	b, err := fs.ReadFile(f, "docs/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+
+	matches, err := fs.Glob(f, "docs/*.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs/a.txt", "docs/empty.txt"}, matches)
+
+	_, err = f.Stat("missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = f.Open("../x")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+}
+
+func TestFileInfoMode(t *testing.T) {
+	assert.Equal(t, fs.ModeDir|0700, FileInfo(&drive.Node{Type: drive.FolderKind, Meta: "700"}).Mode())
+	assert.Equal(t, fs.FileMode(0600), FileInfo(&drive.Node{Type: drive.FileKind, Meta: "600"}).Mode())
+	assert.Equal(t, fs.FileMode(0644), FileInfo(&drive.Node{Type: drive.FileKind, Meta: "not a mode"}).Mode())
+	assert.Equal(t, fs.ModeDir|0755, FileInfo(&drive.Node{Type: drive.FolderKind}).Mode())
+}
@@ -0,0 +1,204 @@
+// Package proxy serves an aliyun drive folder tree over plain HTTP.
+//
+// Folders are rendered as browsable directory listings, files are streamed
+// through the server with full Range, If-Range and ETag support, the ETag being
+// the sha1 of the content (Node.Hash).
+//
+// In redirect mode files are not streamed, clients get a 302 to the signed download url
+// returned by drive.DownloadUrlGetter instead. Aliyun only serves these urls to requests with the
+// "Referer: https://www.aliyundrive.com/" header, so redirect mode is meant for
+// clients which can set it (e.g. mpv --referrer, aria2c --referer), browsers can't.
+package proxy
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+// Referer is the Referer header required by signed download urls.
+const Referer = "https://www.aliyundrive.com/"
+
+type Options struct {
+	// Redirect sends clients to the signed download url instead of streaming files.
+	Redirect bool
+	// UseInternalUrl redirects to the internal url, reachable from aliyun ECS only.
+	UseInternalUrl bool
+	// CacheTTL is how long file metadata is cached, 0 means drive.DefaultCacheTTL.
+	CacheTTL time.Duration
+}
+
+// Handler serves the drive, GET and HEAD are the only methods allowed.
+type Handler struct {
+	fs      *drive.PathFs
+	options Options
+}
+
+func NewHandler(fs drive.Fs, options Options) *Handler {
+	return &Handler{fs: drive.NewPathFs(fs, options.CacheTTL), options: options}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	p := path.Clean("/" + r.URL.Path)
+	node, err := h.fs.Stat(ctx, p)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if node.IsDirectory() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, (&url.URL{Path: path.Base(p) + "/"}).String(), http.StatusMovedPermanently)
+			return
+		}
+		h.serveDir(w, r, p)
+		return
+	}
+
+	if h.options.Redirect {
+		h.serveRedirect(w, r, node)
+		return
+	}
+
+	h.serveFile(w, r, node)
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, drive.ErrorNotFound):
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case errors.Is(err, drive.ErrorForbidden):
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+	case errors.Is(err, drive.ErrorRateLimited):
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "429 too many requests", http.StatusTooManyRequests)
+	default:
+		http.Error(w, "502 bad gateway", http.StatusBadGateway)
+	}
+}
+
+// ETag returns the strong ETag of a file, derived from its sha1.
+func ETag(node *drive.Node) string {
+	if node.Hash == "" {
+		return ""
+	}
+
+	return strconv.Quote(strings.ToLower(node.Hash))
+}
+
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, node *drive.Node) {
+	if etag := ETag(node); etag != "" {
+		// http.ServeContent handles If-Match, If-None-Match and If-Range with it
+		w.Header().Set("ETag", etag)
+	}
+
+	modTime := node.ModTime()
+	rd := drive.NewReader(r.Context(), h.fs.Fs(), node)
+	defer rd.Close()
+	http.ServeContent(w, r, node.Name, modTime, rd)
+}
+
+func (h *Handler) serveRedirect(w http.ResponseWriter, r *http.Request, node *drive.Node) {
+	getter, ok := h.fs.Fs().(drive.DownloadUrlGetter)
+	if !ok {
+		// e.g. encrypted files, which have to be decrypted by the proxy
+		h.serveFile(w, r, node)
+		return
+	}
+	downloadUrl, err := getter.GetDownloadUrl(r.Context(), node)
+	if errors.Is(err, drive.ErrorNotSupported) {
+		h.serveFile(w, r, node)
+		return
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	u := downloadUrl.Url
+	if h.options.UseInternalUrl && downloadUrl.InternalUrl != "" {
+		u = downloadUrl.InternalUrl
+	}
+	if u == "" {
+		// e.g. live photos, which only have stream urls, have to go through the proxy
+		h.serveFile(w, r, node)
+		return
+	}
+
+	if etag := ETag(node); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+type entry struct {
+	Name    string
+	Href    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+var listing = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>Index of {{.Path}}</title>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{if not .ModTime.IsZero}}{{.ModTime.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (h *Handler) serveDir(w http.ResponseWriter, r *http.Request, p string) {
+	nodes, err := h.fs.ReadDir(r.Context(), p)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	entries := make([]entry, 0, len(nodes))
+	for _, node := range nodes {
+		href := (&url.URL{Path: node.Name}).String()
+		if node.IsDirectory() {
+			href += "/"
+		}
+		modTime := node.ModTime()
+		entries = append(entries, entry{
+			Name:    node.Name,
+			Href:    href,
+			IsDir:   node.IsDirectory(),
+			Size:    node.Size,
+			ModTime: modTime,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	_ = listing.Execute(w, map[string]interface{}{"Path": p, "Entries": entries})
+}
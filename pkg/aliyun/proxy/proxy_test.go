@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	srv := drivetest.NewServer()
+	defer srv.Close()
+	fs, err := drive.NewFs(context.Background(), &drive.Config{RefreshToken: "token", DeviceId: "device", HttpClient: srv.Client()})
+	require.NoError(t, err)
+	videos := srv.Mkdir(drivetest.RootId, "videos")
+	srv.Put(videos, "<movie>.mp4", []byte("0123456789"))
+	etag := `"` + strings.ToLower(srv.Lookup("/videos/<movie>.mp4").Hash()) + `"`
+
+	proxy := httptest.NewServer(NewHandler(fs, Options{}))
+	defer proxy.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(p string, headers ...string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", proxy.URL+p, nil)
+		require.NoError(t, err)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(b)
+	}
+
+	res, _ := get("/videos")
+	assert.Equal(t, http.StatusMovedPermanently, res.StatusCode)
+	assert.Equal(t, "/videos/", res.Header.Get("Location"))
+	srv.Mkdir(drivetest.RootId, "50% off#1?")
+	res, _ = get("/50%25%20off%231%3F")
+	assert.Equal(t, http.StatusMovedPermanently, res.StatusCode)
+	assert.Equal(t, "/50%25%20off%231%3F/", res.Header.Get("Location"))
+
+	res, body := get("/videos/")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, body, `<a href="%3Cmovie%3E.mp4">&lt;movie&gt;.mp4</a>`)
+
+	res, body = get("/videos/%3Cmovie%3E.mp4", "Range", "bytes=2-4")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "234", body)
+	assert.Equal(t, etag, res.Header.Get("ETag"))
+
+	res, body = get("/videos/%3Cmovie%3E.mp4", "Range", "bytes=2-4", "If-Range", etag)
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "234", body)
+
+	res, body = get("/videos/%3Cmovie%3E.mp4", "Range", "bytes=2-4", "If-Range", `"stale"`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "0123456789", body)
+
+	res, _ = get("/videos/%3Cmovie%3E.mp4", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	res, _ = get("/missing")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	redirect := httptest.NewServer(NewHandler(fs, Options{Redirect: true}))
+	defer redirect.Close()
+	res, err = client.Get(redirect.URL + "/videos/%3Cmovie%3E.mp4")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Location"), "https://download.test/"))
+
+	// files without download url are streamed
+	for _, fs := range []drive.Fs{struct{ drive.Fs }{fs}, noDownloadUrlFs{fs}} {
+		streamed := httptest.NewServer(NewHandler(fs, Options{Redirect: true}))
+		res, err = client.Get(streamed.URL + "/videos/%3Cmovie%3E.mp4")
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		streamed.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "0123456789", string(b))
+	}
+}
+
+// noDownloadUrlFs is a drive.Fs whose files have no download url.
+type noDownloadUrlFs struct {
+	drive.Fs
+}
+
+func (noDownloadUrlFs) GetDownloadUrl(ctx context.Context, node *drive.Node) (*drive.DownloadUrl, error) {
+	return nil, drive.ErrorNotSupported
+}
@@ -0,0 +1,240 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	signV4Algorithm = "AWS4-HMAC-SHA256"
+	amzDateLayout   = "20060102T150405Z"
+	streamingSha256 = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	maxClockSkew    = 15 * time.Minute
+)
+
+var (
+	errAccessDenied       = errors.New("AccessDenied")
+	errSignatureMismatch  = errors.New("SignatureDoesNotMatch")
+	errInvalidAccessKeyId = errors.New("InvalidAccessKeyId")
+	errRequestTimeTooSkew = errors.New("RequestTimeTooSkewed")
+)
+
+type authorization struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+}
+
+// parseAuthorization parses
+// "AWS4-HMAC-SHA256 Credential=AKID/20130524/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=abc"
+func parseAuthorization(s string) (*authorization, error) {
+	if !strings.HasPrefix(s, signV4Algorithm+" ") {
+		return nil, errAccessDenied
+	}
+
+	auth := &authorization{}
+	for _, field := range strings.Split(strings.TrimPrefix(s, signV4Algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, errAccessDenied
+		}
+
+		switch kv[0] {
+		case "Credential":
+			scope := strings.Split(kv[1], "/")
+			if len(scope) != 5 || scope[4] != "aws4_request" {
+				return nil, errAccessDenied
+			}
+			auth.accessKey, auth.date, auth.region, auth.service = scope[0], scope[1], scope[2], scope[3]
+		case "SignedHeaders":
+			auth.signedHeaders = strings.Split(kv[1], ";")
+		case "Signature":
+			auth.signature = kv[1]
+		}
+	}
+
+	if auth.accessKey == "" || auth.signature == "" || len(auth.signedHeaders) == 0 {
+		return nil, errAccessDenied
+	}
+	return auth, nil
+}
+
+// uriEncode escapes s as required by the canonical request, "/" is kept in paths.
+func uriEncode(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, h := range signedHeaders {
+		// Values returns the slice of the request, the values are trimmed in a copy
+		v := append([]string(nil), r.Header.Values(h)...)
+		if h == "host" {
+			v = []string{r.Host}
+		}
+		for i := range v {
+			v[i] = strings.Join(strings.Fields(v[i]), " ")
+		}
+		headers.WriteString(h + ":" + strings.Join(v, ",") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, true),
+		canonicalQuery(r.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func signingKey(secretKey string, date string, region string, service string) []byte {
+	k := hmacSha256([]byte("AWS4"+secretKey), date)
+	k = hmacSha256(k, region)
+	k = hmacSha256(k, service)
+	return hmacSha256(k, "aws4_request")
+}
+
+// verify checks the AWS signature version 4 of r.
+//
+// Only the Authorization header is supported, not presigned urls. The payload hash is
+// the signed x-amz-content-sha256 header, the body itself is not checked against it.
+func (g *Gateway) verify(r *http.Request) error {
+	if g.options.AccessKey == "" {
+		return nil
+	}
+
+	auth, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(auth.accessKey), []byte(g.options.AccessKey)) != 1 {
+		return errInvalidAccessKeyId
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse(amzDateLayout, amzDate)
+	if err != nil {
+		return errAccessDenied
+	}
+	if d := time.Since(t); d > maxClockSkew || d < -maxClockSkew {
+		return errRequestTimeTooSkew
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		return errAccessDenied
+	}
+
+	scope := strings.Join([]string{auth.date, auth.region, auth.service, "aws4_request"}, "/")
+	hash := sha256.Sum256([]byte(canonicalRequest(r, auth.signedHeaders, payloadHash)))
+	stringToSign := strings.Join([]string{signV4Algorithm, amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+	signature := hex.EncodeToString(hmacSha256(signingKey(g.options.SecretKey, auth.date, auth.region, auth.service), stringToSign))
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(auth.signature)) != 1 {
+		return errSignatureMismatch
+	}
+	return nil
+}
+
+// body returns the payload of r, decoding "aws-chunked" bodies sent by streaming uploads.
+//
+// Chunk signatures are not verified.
+func body(r *http.Request) (io.Reader, int64, error) {
+	if r.Header.Get("X-Amz-Content-Sha256") != streamingSha256 {
+		return r.Body, r.ContentLength, nil
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil {
+		return nil, 0, errors.New("missing x-amz-decoded-content-length")
+	}
+	return &chunkedReader{r: bufio.NewReader(r.Body)}, size, nil
+}
+
+// chunkedReader decodes "hex-size;chunk-signature=...\r\n<data>\r\n" chunks, ending with a 0 sized chunk.
+type chunkedReader struct {
+	r      *bufio.Reader
+	remain int64
+	eof    bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.remain == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to read chunk header")
+		}
+
+		sizeHex := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid chunk size %q", sizeHex)
+		}
+
+		if size == 0 {
+			c.eof = true
+			return 0, io.EOF
+		}
+		c.remain = size
+	}
+
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.r.Read(p)
+	c.remain -= int64(n)
+	if c.remain == 0 && err == nil {
+		// skip the "\r\n" ending the chunk data
+		if _, err := c.r.Discard(2); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,348 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+// multipartUpload maps a S3 multipart upload onto a drive.Upload.
+//
+// The drive requires parts to be uploaded in order while S3 clients send them concurrently,
+// parts received out of order are spooled to temporary files until the parts before them are uploaded.
+// The file is uploaded over the key, an existing file keeps its content until completion,
+// then the old content is kept as a revision.
+//
+// Uploads are kept in memory, they are lost if the gateway restarts. Uploads unused for
+// Options.UploadExpiry are aborted.
+type multipartUpload struct {
+	id     string
+	bucket string
+	key    string
+	upload *drive.Upload
+	// uploader is the drive.Uploader of the gateway Fs
+	uploader drive.Uploader
+	// ctx is the context of the parts uploaded to the drive, which outlives the requests
+	// flushing them, cancel is called once the upload is completed, aborted or expired
+	ctx    context.Context
+	cancel context.CancelFunc
+	expiry *time.Timer
+
+	// flushMutex is held while parts are uploaded to the drive
+	flushMutex sync.Mutex
+
+	mutex   sync.Mutex
+	next    int
+	spooled map[int]*os.File
+	etags   map[int]string
+	err     error
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadId string   `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func errNoSuchUpload() error {
+	return newError(http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+}
+
+// getUpload returns the upload uploadId of bucket and key, and postpones its expiry.
+func (g *Gateway) getUpload(uploadId string, bucket string, key string) (*multipartUpload, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	u, ok := g.uploads[uploadId]
+	if !ok || u.bucket != bucket || u.key != key {
+		return nil, errNoSuchUpload()
+	}
+	u.expiry.Reset(g.uploadExpiry())
+	return u, nil
+}
+
+func (g *Gateway) uploadExpiry() time.Duration {
+	if g.options.UploadExpiry > 0 {
+		return g.options.UploadExpiry
+	}
+	return DefaultUploadExpiry
+}
+
+// removeUpload removes uploadId from the uploads, it returns nil if it was removed already.
+func (g *Gateway) removeUpload(uploadId string) *multipartUpload {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	u, ok := g.uploads[uploadId]
+	if !ok {
+		return nil
+	}
+	delete(g.uploads, uploadId)
+	u.expiry.Stop()
+	return u
+}
+
+// discard drops the spooled parts of a removed upload and stops the parts being uploaded.
+func (u *multipartUpload) discard() {
+	u.cancel()
+	u.flushMutex.Lock()
+	defer u.flushMutex.Unlock()
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for _, tmp := range u.spooled {
+		closeTemp(tmp)
+	}
+	u.spooled = nil
+	u.err = errNoSuchUpload()
+}
+
+// createMultipartUpload starts the drive upload, its size is unknown so rapid upload isn't possible.
+func (g *Gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	ctx := r.Context()
+	if _, err := g.fs.Stat(ctx, "/"+bucket); err != nil {
+		return newError(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+	}
+	uploader, ok := g.fs.Fs().(drive.Uploader)
+	if !ok {
+		// e.g. encrypted files, which are encrypted as a whole
+		return newError(http.StatusNotImplemented, "NotImplemented", "Multipart uploads are not supported by this drive.")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return errors.WithStack(err)
+	}
+	id := hex.EncodeToString(b)
+
+	parentPath, name := path.Split("/" + bucket + "/" + key)
+	parent, err := g.fs.MkdirAll(ctx, parentPath)
+	if err != nil {
+		return err
+	}
+
+	upload, err := uploader.ReplaceUpload(ctx, drive.Node{ParentId: parent.NodeId, Name: name}, "", "", 0)
+	if err != nil {
+		return err
+	}
+
+	u := &multipartUpload{
+		id:       id,
+		bucket:   bucket,
+		key:      key,
+		upload:   upload,
+		uploader: uploader,
+		next:     1,
+		spooled:  make(map[int]*os.File),
+		etags:    make(map[int]string),
+	}
+	u.ctx, u.cancel = context.WithCancel(context.Background())
+	g.mutex.Lock()
+	u.expiry = time.AfterFunc(g.uploadExpiry(), func() {
+		if u := g.removeUpload(id); u != nil {
+			u.discard()
+		}
+	})
+	g.uploads[id] = u
+	g.mutex.Unlock()
+
+	writeXML(w, http.StatusOK, &initiateMultipartUploadResult{Xmlns: xmlns, Bucket: bucket, Key: key, UploadId: id})
+	return nil
+}
+
+// uploadPart spools the part, then uploads to the drive the parts which are next in order.
+// A part uploaded already can be sent again with the same content, e.g. after a timeout.
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, bucket string, key string, uploadId string) error {
+	u, err := g.getUpload(uploadId, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		return newError(http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000.")
+	}
+
+	in, size, err := body(r)
+	if err != nil {
+		return newError(http.StatusBadRequest, "InvalidRequest", err.Error())
+	}
+	if size > drive.MaxPartSize {
+		return newError(http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.")
+	}
+
+	h := md5.New()
+	tmp, err := g.spool(io.TeeReader(in, h), size)
+	if err != nil {
+		return err
+	}
+	etag := strconv.Quote(hex.EncodeToString(h.Sum(nil)))
+
+	u.mutex.Lock()
+	resent := false
+	switch {
+	case u.err != nil:
+		err = u.err
+	case partNumber < u.next && u.etags[partNumber] == etag:
+		resent = true
+	case partNumber < u.next:
+		err = newError(http.StatusBadRequest, "InvalidPart", "Part "+strconv.Itoa(partNumber)+" was already uploaded and can't be replaced.")
+	default:
+		if old, ok := u.spooled[partNumber]; ok {
+			closeTemp(old)
+		}
+		u.spooled[partNumber] = tmp
+		u.etags[partNumber] = etag
+	}
+	u.mutex.Unlock()
+	if err != nil || resent {
+		closeTemp(tmp)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := g.flush(u); err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", etag)
+	return nil
+}
+
+func closeTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// flush uploads the spooled parts following the last uploaded one.
+func (g *Gateway) flush(u *multipartUpload) error {
+	u.flushMutex.Lock()
+	defer u.flushMutex.Unlock()
+
+	for {
+		u.mutex.Lock()
+		err := u.err
+		tmp, ok := u.spooled[u.next]
+		partNumber := u.next
+		u.mutex.Unlock()
+		if err != nil || !ok {
+			return err
+		}
+
+		err = u.uploader.UploadPart(u.ctx, u.upload, partNumber, tmp)
+		closeTemp(tmp)
+
+		u.mutex.Lock()
+		delete(u.spooled, partNumber)
+		if err != nil {
+			// the drive can't resume from a failed part, the upload has to be started again
+			u.err = err
+		} else {
+			u.next++
+		}
+		u.mutex.Unlock()
+	}
+}
+
+func (g *Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string, uploadId string) error {
+	ctx := r.Context()
+	u, err := g.getUpload(uploadId, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return newError(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+	}
+
+	if err := g.flush(u); err != nil {
+		return err
+	}
+
+	u.mutex.Lock()
+	next := u.next
+	etags := u.etags
+	u.mutex.Unlock()
+	if len(req.Parts) != next-1 {
+		return newError(http.StatusBadRequest, "InvalidPart", "The parts must be numbered consecutively from 1.")
+	}
+	for i, part := range req.Parts {
+		if part.PartNumber != i+1 {
+			return newError(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+		}
+		if part.ETag != "" && part.ETag != etags[part.PartNumber] && strconv.Quote(part.ETag) != etags[part.PartNumber] {
+			return newError(http.StatusBadRequest, "InvalidPart", "The ETag of part "+strconv.Itoa(part.PartNumber)+" does not match.")
+		}
+	}
+
+	fileId, err := u.uploader.CompleteUpload(ctx, u.upload)
+	if err != nil {
+		return err
+	}
+	// the upload can be completed again until it succeeds
+	if u := g.removeUpload(uploadId); u != nil {
+		u.cancel()
+	}
+	g.fs.Invalidate(path.Dir("/" + bucket + "/" + key))
+
+	node, err := g.fs.Fs().Get(ctx, fileId)
+	if err != nil {
+		return err
+	}
+
+	writeXML(w, http.StatusOK, &completeMultipartUploadResult{
+		Xmlns:    xmlns,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     ETag(node),
+	})
+	return nil
+}
+
+// abortMultipartUpload drops the spooled parts, the drive keeps no file for an upload which isn't completed.
+func (g *Gateway) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string, uploadId string) error {
+	if _, err := g.getUpload(uploadId, bucket, key); err != nil {
+		return err
+	}
+	u := g.removeUpload(uploadId)
+	if u == nil {
+		return errNoSuchUpload()
+	}
+	u.discard()
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,542 @@
+// Package s3 serves an aliyun drive over a subset of the Amazon S3 REST API.
+//
+// The top level folders of the drive are the buckets, the paths below them the object keys,
+// keys ending with "/" are folders. Only path style requests are supported
+// (e.g. "http://localhost:8080/bucket/key"), clients must be configured accordingly.
+//
+// Supported operations: ListBuckets, CreateBucket, HeadBucket, ListObjectsV2 (with prefix,
+// delimiter "/", start-after and continuation tokens), GetObject (with Range),
+// HeadObject, PutObject, DeleteObject and multipart uploads. ETags are the sha1 of the
+// content (Node.Hash), not md5 as on S3, except for the ETags of uploaded parts.
+//
+// Requests are authenticated with AWS signature version 4 if Options.AccessKey is set.
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+const (
+	xmlns          = "http://s3.amazonaws.com/doc/2006-03-01/"
+	timeLayout     = "2006-01-02T15:04:05.000Z"
+	defaultMaxKeys = 1000
+)
+
+type Options struct {
+	// AccessKey and SecretKey are the credentials clients sign requests with,
+	// requests aren't authenticated if AccessKey is empty.
+	AccessKey string
+	SecretKey string
+	// CacheTTL is how long file metadata is cached, 0 means drive.DefaultCacheTTL.
+	CacheTTL time.Duration
+	// TempDir is where uploads are spooled, "" means os.TempDir().
+	TempDir string
+	// UploadExpiry is how long a multipart upload is kept without requests, 0 means DefaultUploadExpiry.
+	UploadExpiry time.Duration
+}
+
+// DefaultUploadExpiry is the default Options.UploadExpiry.
+const DefaultUploadExpiry = 24 * time.Hour
+
+// Gateway is a http.Handler translating S3 requests to drive.Fs calls.
+type Gateway struct {
+	fs      *drive.PathFs
+	options Options
+
+	mutex   sync.Mutex
+	uploads map[string]*multipartUpload
+}
+
+func NewGateway(fs drive.Fs, options Options) *Gateway {
+	return &Gateway{
+		fs:      drive.NewPathFs(fs, options.CacheTTL),
+		options: options,
+		uploads: make(map[string]*multipartUpload),
+	}
+}
+
+// s3Error is an error response, Code is one of the S3 error codes.
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource,omitempty"`
+	status   int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newError(status int, code string, message string) *s3Error {
+	return &s3Error{Code: code, Message: message, status: status}
+}
+
+// toError maps drive and auth errors to S3 errors, notFound is the code used for ErrorNotFound.
+func toError(err error, notFound string) *s3Error {
+	var e *s3Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, drive.ErrorNotFound):
+		return newError(http.StatusNotFound, notFound, "The specified key does not exist.")
+	case errors.Is(err, drive.ErrorAlreadyExisted):
+		return newError(http.StatusConflict, "OperationAborted", err.Error())
+	case errors.Is(err, drive.ErrorForbidden):
+		return newError(http.StatusForbidden, "AccessDenied", "Access Denied")
+	case errors.Is(err, drive.ErrorQuotaExceeded):
+		return newError(http.StatusForbidden, "QuotaExceeded", "The drive is full.")
+	case errors.Is(err, drive.ErrorRateLimited):
+		return newError(http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+	case errors.Is(err, errSignatureMismatch):
+		return newError(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+	case errors.Is(err, errInvalidAccessKeyId):
+		return newError(http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records.")
+	case errors.Is(err, errRequestTimeTooSkew):
+		return newError(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large.")
+	case errors.Is(err, errAccessDenied):
+		return newError(http.StatusForbidden, "AccessDenied", "Access Denied")
+	default:
+		return newError(http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err *s3Error) {
+	err.Resource = r.URL.Path
+	if r.Method == http.MethodHead {
+		w.WriteHeader(err.status)
+		return
+	}
+	writeXML(w, err.status, err)
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+// ETag returns the ETag of a file, the quoted lower case sha1 of its content.
+func ETag(node *drive.Node) string {
+	return strconv.Quote(strings.ToLower(node.Hash))
+}
+
+// splitPath returns the bucket and the key of a path style request.
+func splitPath(p string) (string, string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+func validBucket(bucket string) bool {
+	return bucket != "" && bucket != "." && bucket != ".."
+}
+
+func validKey(key string) bool {
+	for _, name := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if name == "" || name == "." || name == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := g.verify(r); err != nil {
+		writeError(w, r, toError(err, ""))
+		return
+	}
+
+	bucket, key := splitPath(r.URL.Path)
+	query := r.URL.Query()
+	var err error
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		err = g.listBuckets(w, r)
+	case bucket == "":
+		err = newError(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	case !validBucket(bucket):
+		err = newError(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	case key == "":
+		err = g.serveBucket(w, r, bucket)
+	case !validKey(key):
+		err = newError(http.StatusBadRequest, "InvalidArgument", "The specified key is not valid.")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		err = g.getObject(w, r, bucket, key)
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		err = g.uploadPart(w, r, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		err = newError(http.StatusNotImplemented, "NotImplemented", "CopyObject is not implemented.")
+	case r.Method == http.MethodPut:
+		err = g.putObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		err = g.abortMultipartUpload(w, r, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodDelete:
+		err = g.deleteObject(w, r, bucket, key)
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		err = g.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		err = g.completeMultipartUpload(w, r, bucket, key, query.Get("uploadId"))
+	default:
+		err = newError(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+	}
+
+	if err != nil {
+		writeError(w, r, toError(err, "NoSuchKey"))
+	}
+}
+
+func (g *Gateway) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	ctx := r.Context()
+	if r.Method == http.MethodPut {
+		if _, err := g.fs.MkdirAll(ctx, "/"+bucket); err != nil {
+			return err
+		}
+		w.Header().Set("Location", "/"+bucket)
+		return nil
+	}
+
+	node, err := g.fs.Stat(ctx, "/"+bucket)
+	if err != nil || !node.IsDirectory() {
+		return newError(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		return nil
+	case http.MethodGet:
+		return g.listObjects(w, r, bucket)
+	}
+	return newError(http.StatusNotImplemented, "NotImplemented", "The bucket operation is not implemented.")
+}
+
+type bucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name     `xml:"ListAllMyBucketsResult"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Owner   owner        `xml:"Owner"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	nodes, err := g.fs.ReadDir(r.Context(), "/")
+	if err != nil {
+		return err
+	}
+
+	result := listAllMyBucketsResult{Xmlns: xmlns, Owner: owner{ID: "aliyundrive", DisplayName: "aliyundrive"}}
+	for _, node := range nodes {
+		if !node.IsDirectory() {
+			continue
+		}
+		t, _ := node.GetTime()
+		result.Buckets = append(result.Buckets, bucketInfo{Name: node.Name, CreationDate: t.UTC().Format(timeLayout)})
+	}
+
+	writeXML(w, http.StatusOK, &result)
+	return nil
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// listEntry is an object or, if node is nil, a common prefix.
+type listEntry struct {
+	key  string
+	node *drive.Node
+}
+
+// walk lists the entries of folder dir ("" or ending with "/") matching prefix,
+// recursively unless delimiter is "/".
+func (g *Gateway) walk(ctx context.Context, bucket string, dir string, prefix string, delimiter string, entries []listEntry) ([]listEntry, error) {
+	nodes, err := g.fs.ReadDir(ctx, "/"+bucket+"/"+dir)
+	if err != nil {
+		if errors.Is(err, drive.ErrorNotFound) {
+			return entries, nil
+		}
+		return nil, err
+	}
+
+	for i := range nodes {
+		node := &nodes[i]
+		key := dir + node.Name
+		if !node.IsDirectory() {
+			if strings.HasPrefix(key, prefix) {
+				entries = append(entries, listEntry{key: key, node: node})
+			}
+			continue
+		}
+
+		key += "/"
+		switch {
+		case delimiter == "/" && strings.HasPrefix(key, prefix):
+			entries = append(entries, listEntry{key: key})
+		case delimiter == "/":
+		case strings.HasPrefix(key, prefix) || strings.HasPrefix(prefix, key):
+			if entries, err = g.walk(ctx, bucket, key, prefix, delimiter, entries); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// listObjects implements ListObjectsV2, the continuation token is the last key returned.
+// Only "/" is supported as delimiter.
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	query := r.URL.Query()
+	result := listBucketResult{
+		Xmlns:             xmlns,
+		Name:              bucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           defaultMaxKeys,
+	}
+
+	if result.Delimiter != "" && result.Delimiter != "/" {
+		return newError(http.StatusNotImplemented, "NotImplemented", `Only "/" is supported as delimiter.`)
+	}
+
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return newError(http.StatusBadRequest, "InvalidArgument", "Invalid max-keys.")
+		}
+		if n < result.MaxKeys {
+			result.MaxKeys = n
+		}
+	}
+
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return newError(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect.")
+		}
+		after = string(b)
+	}
+
+	dir := result.Prefix[:strings.LastIndex(result.Prefix, "/")+1]
+	entries, err := g.walk(r.Context(), bucket, dir, result.Prefix, result.Delimiter, nil)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	for _, entry := range entries {
+		if entry.key <= after {
+			continue
+		}
+
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+
+		result.KeyCount++
+		if entry.node == nil {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry.key})
+			after = entry.key
+			continue
+		}
+
+		t := entry.node.ModTime()
+		result.Contents = append(result.Contents, object{
+			Key:          entry.key,
+			LastModified: t.UTC().Format(timeLayout),
+			ETag:         ETag(entry.node),
+			Size:         entry.node.Size,
+			StorageClass: "STANDARD",
+		})
+		after = entry.key
+	}
+
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(after))
+	}
+
+	writeXML(w, http.StatusOK, &result)
+	return nil
+}
+
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	node, err := g.fs.Stat(r.Context(), "/"+bucket+"/"+key)
+	if err != nil {
+		return err
+	}
+
+	if node.IsDirectory() != strings.HasSuffix(key, "/") {
+		return newError(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+
+	modTime := node.ModTime()
+	if node.IsDirectory() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Content-Type", "application/x-directory")
+		return nil
+	}
+
+	// http.ServeContent handles Range, If-Match, If-None-Match and If-Range with it
+	w.Header().Set("ETag", ETag(node))
+	ctype := mime.TypeByExtension(path.Ext(node.Name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	rd := drive.NewReader(r.Context(), g.fs.Fs(), node)
+	defer rd.Close()
+	http.ServeContent(w, r, node.Name, modTime, rd)
+	return nil
+}
+
+// spool copies in to a temporary file, the drive needs the size and sha1 of a file before uploading it.
+func (g *Gateway) spool(in io.Reader, size int64) (*os.File, error) {
+	tmp, err := ioutil.TempFile(g.options.TempDir, "aliyundrive-s3-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	n, err := io.Copy(tmp, in)
+	if err == nil && size >= 0 && n != size {
+		err = newError(http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header.")
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	ctx := r.Context()
+	in, size, err := body(r)
+	if err != nil {
+		return newError(http.StatusBadRequest, "InvalidRequest", err.Error())
+	}
+
+	if _, err := g.fs.Stat(ctx, "/"+bucket); err != nil {
+		return newError(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+	}
+
+	if strings.HasSuffix(key, "/") {
+		if size > 0 {
+			return newError(http.StatusBadRequest, "InvalidRequest", "Folder objects must be empty.")
+		}
+		_, err := g.fs.MkdirAll(ctx, "/"+bucket+"/"+key)
+		return err
+	}
+
+	tmp, err := g.spool(in, size)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	fi, err := tmp.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	node, err := g.fs.Create(ctx, "/"+bucket+"/"+key, tmp, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", ETag(node))
+	return nil
+}
+
+// deleteObject succeeds even if the key does not exist, like S3 does.
+// Folders are only removed if they are empty, deleting "a/" must not delete "a/b".
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	ctx := r.Context()
+	fullPath := "/" + bucket + "/" + key
+	node, err := g.fs.Stat(ctx, fullPath)
+	switch {
+	case errors.Is(err, drive.ErrorNotFound):
+	case err != nil:
+		return err
+	case node.IsDirectory() != strings.HasSuffix(key, "/"):
+	case node.IsDirectory():
+		nodes, err := g.fs.ReadDir(ctx, fullPath)
+		if err != nil {
+			return err
+		}
+		if len(nodes) == 0 {
+			err = g.fs.Remove(ctx, fullPath)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		if err := g.fs.Remove(ctx, fullPath); err != nil {
+			return err
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,688 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrorRootMissing is returned by Bisync when a synced folder has gone, e.g. moved, while its state has
+	// entries: syncing would delete the files of the other side. Remove the state file to sync it again.
+	ErrorRootMissing = errors.New("synced folder missing")
+	// ErrorTooManyDeletes is returned by Bisync when a side would lose more than BisyncOptions.MaxDelete of its files.
+	ErrorTooManyDeletes = errors.New("too many deletions")
+)
+
+// DefaultMaxDelete is the default BisyncOptions.MaxDelete.
+const DefaultMaxDelete = 50
+
+// ConflictPolicy decides what Bisync does with a file changed on both sides since the last sync.
+type ConflictPolicy int
+
+const (
+	// NewestWins keeps the most recently modified side, comparing the local
+	// modification time with the remote update time.
+	NewestWins ConflictPolicy = iota
+	// KeepBoth renames the local file to "name (conflict <time>).ext" and syncs both versions.
+	KeepBoth
+	// Manual reports the conflict with ActionConflict and leaves both sides as they are.
+	Manual
+)
+
+// BisyncOptions configures Bisync, Include and Exclude are as in Options.
+type BisyncOptions struct {
+	Policy ConflictPolicy
+	// StatePath is the state file, DefaultStateName in the local folder if empty.
+	StatePath string
+	Include   []string
+	Exclude   []string
+	// DryRun only computes the plan, the state is not saved either.
+	DryRun bool
+	// MaxDelete is the percentage of the synced files and folders a run may delete on either side,
+	// DefaultMaxDelete if 0, 100 disables the check.
+	MaxDelete int
+}
+
+// fileStatus is how a side changed since the last sync.
+type fileStatus int
+
+const (
+	statusAbsent fileStatus = iota
+	statusUnchanged
+	statusNew
+	statusChanged
+	statusDeleted
+)
+
+func (s fileStatus) modified() bool {
+	return s == statusNew || s == statusChanged
+}
+
+type localFile struct {
+	isDir   bool
+	size    int64
+	modTime time.Time
+	// hash is computed on demand
+	hash string
+}
+
+type bisyncer struct {
+	tree
+	opts      BisyncOptions
+	filter    Options
+	statePath string
+	state     *State
+	locals    map[string]*localFile
+	remotes   map[string]*drive.Node
+	// keptLocal and keptRemote are the paths, and their folders, which exist on each side after the plan is applied.
+	keptLocal  map[string]bool
+	keptRemote map[string]bool
+	plan       Plan
+}
+
+// Bisync mirrors the changes made to the local folder and to the drive folder remote
+// since the last sync, creating remote if needed.
+//
+// The state file records both sides after each sync. Files are compared with it by size and
+// modification time, then sha1, locally and by sha1 remotely, which tells
+// new, changed and deleted files apart: a change on one side is copied to the other,
+// winning over a deletion, and a deletion of an unchanged file is propagated, to the
+// recycle bin remotely. Renames are detected remotely by file id, and locally by size and sha1,
+// and replayed as moves instead of transfers. A file changed differently on both sides,
+// or a file on one side and a folder on the other, is a conflict resolved by opts.Policy.
+//
+// Without a state file, e.g. on the first run, both folders are merged and nothing is deleted.
+// Nothing is changed if remote has gone while the state has entries, see ErrorRootMissing, or if a side
+// would lose more than opts.MaxDelete percent of the synced paths, see ErrorTooManyDeletes.
+// Failures are reported as by Push, paths which could not be synced are retried by the next run.
+func Bisync(ctx context.Context, fs drive.Fs, local string, remote string, opts BisyncOptions) (*Plan, error) {
+	b := &bisyncer{
+		tree:       newTree(fs, local, remote),
+		opts:       opts,
+		filter:     Options{Include: opts.Include, Exclude: opts.Exclude},
+		statePath:  opts.StatePath,
+		keptLocal:  map[string]bool{},
+		keptRemote: map[string]bool{},
+	}
+	if b.statePath == "" {
+		b.statePath = filepath.Join(local, DefaultStateName)
+	}
+
+	fi, err := os.Stat(local)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf(`"%s" is not a folder`, local)
+	}
+
+	if b.state, err = LoadState(b.statePath); err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(local); err == nil {
+		b.local = abs
+	}
+	if b.state.Local != b.local || b.state.Remote != b.remote {
+		// the state of other folders is meaningless here
+		b.state = &State{Entries: map[string]*Entry{}}
+	}
+
+	if b.locals, err = b.scanLocal(); err != nil {
+		return nil, err
+	}
+	root, err := b.fs.Stat(ctx, b.remote)
+	switch {
+	case err == nil && !root.IsDirectory():
+		return nil, errors.Errorf(`"%s" is not a folder`, b.remote)
+	case err == nil:
+		b.remotes, err = b.scanRemote(ctx, root.NodeId)
+	case errors.Is(err, drive.ErrorNotFound) && len(b.state.Entries) > 0:
+		return nil, errors.Wrapf(ErrorRootMissing, `"%s" was synced with "%s"`, b.remote, b.statePath)
+	case errors.Is(err, drive.ErrorNotFound):
+		b.plan.add(Op{Action: ActionMkdir, Path: ""})
+		b.remotes, err = map[string]*drive.Node{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.planRemoteMoves()
+	if err := b.planLocalMoves(); err != nil {
+		return nil, err
+	}
+	if err := b.planFiles(); err != nil {
+		return nil, err
+	}
+	b.planFolders()
+	if err := b.checkDeletes(); err != nil {
+		return &b.plan, err
+	}
+
+	if opts.DryRun {
+		return &b.plan, nil
+	}
+
+	for i := range b.plan.Ops {
+		b.plan.Ops[i].Err = b.apply(ctx, &b.plan.Ops[i])
+	}
+	if err := b.saveState(ctx); err != nil {
+		return &b.plan, err
+	}
+	if failed := b.plan.Failed(); len(failed) > 0 {
+		return &b.plan, errors.Errorf("%d of %d operations failed, first: %v", len(failed), len(b.plan.Ops), failed[0])
+	}
+	return &b.plan, nil
+}
+
+// checkDeletes refuses plans deleting more than opts.MaxDelete percent of the synced paths on a side.
+func (b *bisyncer) checkDeletes() error {
+	max := b.opts.MaxDelete
+	if max == 0 {
+		max = DefaultMaxDelete
+	}
+	synced := len(b.state.Entries)
+	if max >= 100 || synced == 0 {
+		return nil
+	}
+
+	var remote, local int
+	for _, op := range b.plan.Ops {
+		switch op.Action {
+		case ActionDelete:
+			remote++
+		case ActionDeleteLocal:
+			local++
+		}
+	}
+	for _, side := range []struct {
+		name    string
+		deletes int
+	}{{"remotely", remote}, {"locally", local}} {
+		if side.deletes*100 > synced*max {
+			return errors.Wrapf(ErrorTooManyDeletes, "%d of %d synced paths would be deleted %s, over %d%%", side.deletes, synced, side.name, max)
+		}
+	}
+	return nil
+}
+
+func (b *bisyncer) scanLocal() (map[string]*localFile, error) {
+	stateName, _ := filepath.Abs(b.statePath)
+	files := map[string]*localFile{}
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		infos, err := ioutil.ReadDir(b.localPath(rel))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, fi := range infos {
+			child := path.Join(rel, fi.Name())
+			if (!fi.IsDir() && !fi.Mode().IsRegular()) || strings.HasSuffix(fi.Name(), tempSuffix) || b.filter.skip(child, fi.IsDir()) {
+				continue
+			}
+			if name, _ := filepath.Abs(b.localPath(child)); name == stateName || name == stateName+".tmp" {
+				continue
+			}
+
+			files[child] = &localFile{isDir: fi.IsDir(), size: fi.Size(), modTime: fi.ModTime()}
+			if fi.IsDir() {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return files, walk("")
+}
+
+func (b *bisyncer) scanRemote(ctx context.Context, rootId string) (map[string]*drive.Node, error) {
+	nodes := map[string]*drive.Node{}
+	var walk func(nodeId string, rel string) error
+	walk = func(nodeId string, rel string) error {
+		children, err := b.fs.Fs().ListAll(ctx, nodeId)
+		if err != nil {
+			return err
+		}
+
+		for i := range children {
+			node := &children[i]
+			child := path.Join(rel, node.Name)
+			if b.filter.skip(child, node.IsDirectory()) {
+				continue
+			}
+
+			nodes[child] = node
+			if node.IsDirectory() {
+				if err := walk(node.NodeId, child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return nodes, walk(rootId, "")
+}
+
+// hash returns the sha1 of the local file rel, reusing the state if the file looks unchanged.
+func (b *bisyncer) hash(rel string, f *localFile) (string, error) {
+	if f.hash != "" {
+		return f.hash, nil
+	}
+
+	if entry := b.state.Entries[rel]; entry != nil && !entry.IsDir && entry.Size == f.size && entry.ModTime.Equal(f.modTime) {
+		f.hash = entry.Hash
+		return f.hash, nil
+	}
+
+	hash, err := sha1File(b.localPath(rel))
+	if err != nil {
+		return "", err
+	}
+	f.hash = strings.ToUpper(hash)
+	return f.hash, nil
+}
+
+func (b *bisyncer) localStatus(rel string, f *localFile, entry *Entry) (fileStatus, error) {
+	switch {
+	case f == nil && entry == nil:
+		return statusAbsent, nil
+	case f == nil:
+		return statusDeleted, nil
+	case entry == nil || entry.IsDir:
+		return statusNew, nil
+	case entry.Size != f.size:
+		return statusChanged, nil
+	case entry.ModTime.Equal(f.modTime):
+		return statusUnchanged, nil
+	}
+
+	// touched, but maybe with the same content
+	hash, err := b.hash(rel, f)
+	if err != nil {
+		return 0, err
+	}
+	if hash != entry.Hash {
+		return statusChanged, nil
+	}
+	return statusUnchanged, nil
+}
+
+func remoteStatus(node *drive.Node, entry *Entry) fileStatus {
+	switch {
+	case node == nil && entry == nil:
+		return statusAbsent
+	case node == nil:
+		return statusDeleted
+	case entry == nil || entry.IsDir:
+		return statusNew
+	case strings.EqualFold(node.Hash, entry.Hash):
+		return statusUnchanged
+	}
+	return statusChanged
+}
+
+func (b *bisyncer) add(op Op, keptLocal []string, keptRemote []string) {
+	b.plan.add(op)
+	markKept(b.keptLocal, keptLocal)
+	markKept(b.keptRemote, keptRemote)
+}
+
+func (b *bisyncer) keep(p string) {
+	markKept(b.keptLocal, []string{p})
+	markKept(b.keptRemote, []string{p})
+}
+
+func markKept(kept map[string]bool, paths []string) {
+	for _, p := range paths {
+		for ; p != "." && p != "/" && !kept[p]; p = path.Dir(p) {
+			kept[p] = true
+		}
+	}
+}
+
+// rebasePath returns p moved from oldPath to newPath, if it is oldPath or below.
+func rebasePath(p string, oldPath string, newPath string) (string, bool) {
+	if p == oldPath || strings.HasPrefix(p, oldPath+"/") {
+		return path.Join(newPath, strings.TrimPrefix(p, oldPath)), true
+	}
+	return "", false
+}
+
+// moveLocal moves oldPath and below to newPath in the local snapshot.
+func (b *bisyncer) moveLocal(oldPath string, newPath string) {
+	moved := map[string]*localFile{}
+	for p, f := range b.locals {
+		if q, ok := rebasePath(p, oldPath, newPath); ok {
+			delete(b.locals, p)
+			moved[q] = f
+		}
+	}
+	for p, f := range moved {
+		b.locals[p] = f
+	}
+}
+
+// moveRemote moves oldPath and below to newPath in the remote snapshot.
+func (b *bisyncer) moveRemote(oldPath string, newPath string) {
+	moved := map[string]*drive.Node{}
+	for p, node := range b.remotes {
+		if q, ok := rebasePath(p, oldPath, newPath); ok {
+			delete(b.remotes, p)
+			moved[q] = node
+		}
+	}
+	for p, node := range moved {
+		b.remotes[p] = node
+	}
+}
+
+func sortedKeys(m map[string]*Entry) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// planRemoteMoves replays the files and folders moved remotely, found by file id.
+func (b *bisyncer) planRemoteMoves() {
+	paths := map[string]string{}
+	for p, node := range b.remotes {
+		paths[node.NodeId] = p
+	}
+
+	for _, p := range sortedKeys(b.state.Entries) {
+		entry := b.state.Entries[p]
+		if entry == nil {
+			// moved along with its folder
+			continue
+		}
+		q, ok := paths[entry.NodeId]
+		if !ok || q == p || b.state.Entries[q] != nil {
+			continue
+		}
+
+		f := b.locals[p]
+		if f != nil && f.isDir == entry.IsDir && b.locals[q] == nil {
+			b.plan.add(Op{Action: ActionMoveLocal, From: p, Path: q, Reason: "moved remotely"})
+			b.moveLocal(p, q)
+		}
+		b.state.rebase(p, q)
+	}
+}
+
+// planLocalMoves replays the files moved locally, found by size and sha1, if their remote copy is unchanged.
+func (b *bisyncer) planLocalMoves() error {
+	type content struct {
+		size int64
+		hash string
+	}
+
+	missing := map[content][]string{}
+	sizes := map[int64]bool{}
+	for _, p := range sortedKeys(b.state.Entries) {
+		entry := b.state.Entries[p]
+		node := b.remotes[p]
+		if entry.IsDir || b.locals[p] != nil || remoteStatus(node, entry) != statusUnchanged {
+			continue
+		}
+		c := content{size: entry.Size, hash: entry.Hash}
+		missing[c] = append(missing[c], p)
+		sizes[entry.Size] = true
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var paths []string
+	for p, f := range b.locals {
+		if !f.isDir && sizes[f.size] && b.state.Entries[p] == nil && b.remotes[p] == nil {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, q := range paths {
+		hash, err := b.hash(q, b.locals[q])
+		if err != nil {
+			return err
+		}
+		c := content{size: b.locals[q].size, hash: hash}
+		if len(missing[c]) == 0 {
+			continue
+		}
+
+		p := missing[c][0]
+		missing[c] = missing[c][1:]
+		b.plan.add(Op{Action: ActionMove, From: p, Path: q, Reason: "moved locally"})
+		b.moveRemote(p, q)
+		b.state.rebase(p, q)
+		// the local file was not modified by the move
+		b.state.Entries[q].ModTime = b.locals[q].modTime
+	}
+	return nil
+}
+
+// planFiles plans the files, and the conflicts between files and folders.
+func (b *bisyncer) planFiles() error {
+	seen := map[string]bool{}
+	var paths []string
+	for p := range b.locals {
+		paths = append(paths, p)
+		seen[p] = true
+	}
+	for p := range b.remotes {
+		if !seen[p] {
+			paths = append(paths, p)
+			seen[p] = true
+		}
+	}
+	for p := range b.state.Entries {
+		if !seen[p] {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		f, node, entry := b.locals[p], b.remotes[p], b.state.Entries[p]
+		if f != nil && node != nil && f.isDir != node.IsDirectory() {
+			b.add(Op{Action: ActionConflict, Path: p, Reason: "local " + kind(f.isDir) + ", remote " + kind(node.IsDirectory())}, []string{p}, []string{p})
+			continue
+		}
+		if (f != nil && f.isDir) || (node != nil && node.IsDirectory()) || (f == nil && node == nil && entry != nil && entry.IsDir) {
+			continue
+		}
+		if entry != nil && entry.IsDir {
+			entry = nil
+		}
+
+		if err := b.planFile(p, f, node, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bisyncer) planFile(p string, f *localFile, node *drive.Node, entry *Entry) error {
+	ls, err := b.localStatus(p, f, entry)
+	if err != nil {
+		return err
+	}
+	rs := remoteStatus(node, entry)
+
+	switch {
+	case ls.modified() && rs.modified():
+		hash, err := b.hash(p, f)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(hash, node.Hash) {
+			b.keep(p)
+			return nil
+		}
+		b.conflict(p, f, node)
+	case ls.modified():
+		action, reason := ActionUpload, "new locally"
+		if node != nil {
+			action, reason = ActionUpdate, "changed locally"
+		} else if rs == statusDeleted {
+			reason = "changed locally, deleted remotely"
+		}
+		b.add(Op{Action: action, Path: p, Size: f.size, Reason: reason, hash: f.hash}, []string{p}, []string{p})
+	case rs.modified():
+		reason := "changed remotely"
+		if f == nil {
+			reason = "new remotely"
+			if ls == statusDeleted {
+				reason = "changed remotely, deleted locally"
+			}
+		}
+		b.add(Op{Action: ActionDownload, Path: p, Size: node.Size, Reason: reason}, []string{p}, []string{p})
+	case ls == statusDeleted && rs == statusUnchanged:
+		b.add(Op{Action: ActionDelete, Path: p, Size: node.Size, Reason: "deleted locally"}, nil, nil)
+	case ls == statusUnchanged && rs == statusDeleted:
+		b.add(Op{Action: ActionDeleteLocal, Path: p, Size: f.size, Reason: "deleted remotely"}, nil, nil)
+	case ls == statusUnchanged && rs == statusUnchanged:
+		b.keep(p)
+	}
+	return nil
+}
+
+func (b *bisyncer) conflict(p string, f *localFile, node *drive.Node) {
+	switch b.opts.Policy {
+	case NewestWins:
+		if f.modTime.After(node.ModTime()) {
+			b.add(Op{Action: ActionUpdate, Path: p, Size: f.size, Reason: "changed on both sides, newer locally", hash: f.hash}, []string{p}, []string{p})
+		} else {
+			b.add(Op{Action: ActionDownload, Path: p, Size: node.Size, Reason: "changed on both sides, newer remotely"}, []string{p}, []string{p})
+		}
+	case KeepBoth:
+		q := b.conflictName(p, f.modTime)
+		b.add(Op{Action: ActionMoveLocal, From: p, Path: q, Reason: "changed on both sides"}, []string{q}, nil)
+		b.add(Op{Action: ActionUpload, Path: q, Size: f.size, Reason: "changed on both sides, local version", hash: f.hash}, nil, []string{q})
+		b.add(Op{Action: ActionDownload, Path: p, Size: node.Size, Reason: "changed on both sides, remote version"}, []string{p}, []string{p})
+	default:
+		b.add(Op{Action: ActionConflict, Path: p, Reason: "changed on both sides"}, []string{p}, []string{p})
+	}
+}
+
+// conflictName returns a free "name (conflict <time>).ext" path for the local version of p.
+func (b *bisyncer) conflictName(p string, modTime time.Time) string {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext) + " (conflict " + modTime.Format("2006-01-02 150405") + ")"
+	q := base + ext
+	for i := 2; b.locals[q] != nil || b.remotes[q] != nil; i++ {
+		q = fmt.Sprintf("%s %d%s", base, i, ext)
+	}
+	return q
+}
+
+// planFolders plans the folders once their content is planned, deepest first so that
+// a folder deleted on one side is deleted on the other only if nothing below it is kept.
+func (b *bisyncer) planFolders() {
+	var paths []string
+	for p, f := range b.locals {
+		if f.isDir && b.remotes[p] == nil {
+			paths = append(paths, p)
+		}
+	}
+	for p, node := range b.remotes {
+		if node.IsDirectory() && b.locals[p] == nil {
+			paths = append(paths, p)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	var mkdirs []Op
+	for _, p := range paths {
+		entry := b.state.Entries[p]
+		if b.locals[p] != nil {
+			switch {
+			case entry != nil && entry.IsDir && !b.keptLocal[p]:
+				b.add(Op{Action: ActionDeleteLocal, Path: p, Reason: "deleted remotely"}, nil, nil)
+			case !b.keptRemote[p]:
+				mkdirs = append(mkdirs, Op{Action: ActionMkdir, Path: p, Reason: "new locally"})
+			}
+			markKept(b.keptLocal, []string{p})
+			continue
+		}
+
+		switch {
+		case entry != nil && entry.IsDir && !b.keptRemote[p]:
+			b.add(Op{Action: ActionDelete, Path: p, Reason: "deleted locally"}, nil, nil)
+		case !b.keptLocal[p]:
+			mkdirs = append(mkdirs, Op{Action: ActionMkdirLocal, Path: p, Reason: "new remotely"})
+		}
+		markKept(b.keptRemote, []string{p})
+	}
+
+	for i := len(mkdirs) - 1; i >= 0; i-- {
+		b.plan.add(mkdirs[i])
+	}
+}
+
+// saveState records the paths which are equal on both sides after the sync.
+func (b *bisyncer) saveState(ctx context.Context) error {
+	locals, err := b.scanLocal()
+	if err != nil {
+		return err
+	}
+	root, err := b.fs.Fs().GetByPath(ctx, b.remote, drive.FolderKind)
+	if err != nil {
+		return err
+	}
+	remotes, err := b.scanRemote(ctx, root.NodeId)
+	if err != nil {
+		return err
+	}
+
+	state := &State{Local: b.local, Remote: b.remote, Entries: map[string]*Entry{}}
+	// failed operations are retried by the next run, keeping their entries
+	// tells e.g. a failed deletion from a new file
+	for _, op := range b.plan.Failed() {
+		for _, p := range []string{op.Path, op.From} {
+			if entry := b.state.Entries[p]; entry != nil && p != "" {
+				state.Entries[p] = entry
+			}
+		}
+	}
+	for p, f := range locals {
+		node := remotes[p]
+		if node == nil || f.isDir != node.IsDirectory() {
+			continue
+		}
+		if f.isDir {
+			state.Entries[p] = &Entry{NodeId: node.NodeId, IsDir: true}
+			continue
+		}
+
+		// hashes of files untouched by the sync are known already
+		if old := b.locals[p]; old != nil && old.size == f.size && old.modTime.Equal(f.modTime) {
+			f.hash = old.hash
+		}
+		if f.size != node.Size {
+			continue
+		}
+		hash, err := b.hash(p, f)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(hash, node.Hash) {
+			state.Entries[p] = &Entry{NodeId: node.NodeId, Hash: hash, Size: f.size, ModTime: f.modTime, Updated: node.Updated}
+		}
+	}
+	return state.Save(b.statePath)
+}
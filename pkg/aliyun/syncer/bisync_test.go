@@ -0,0 +1,184 @@
+package syncer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestBisync(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	remote := drive.NewPathFs(fs, 0)
+	local := t.TempDir()
+	dir := srv.Mkdir(drivetest.RootId, "sync")
+	writeFile(t, filepath.Join(local, "a.txt"), "hello")
+	writeFile(t, filepath.Join(local, "same.txt"), "same")
+	require.NoError(t, os.Mkdir(filepath.Join(local, "empty"), 0755))
+	srv.Put(dir, "b.txt", []byte("remote"))
+	srv.Put(dir, "same.txt", []byte("same"))
+
+	// the first run merges both sides
+	plan, err := Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"upload a.txt", "download b.txt", "mkdir empty"}, actions(plan))
+	assert.Equal(t, []byte("hello"), srv.ReadAll("/sync/a.txt"))
+	assert.Equal(t, "remote", readFile(t, filepath.Join(local, "b.txt")))
+	assert.NotNil(t, srv.Lookup("/sync/empty"))
+	assert.FileExists(t, filepath.Join(local, DefaultStateName))
+	assert.Nil(t, srv.Lookup("/sync/"+DefaultStateName), "the state is not synced")
+
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, plan.Ops, "nothing changed")
+
+	// changes and deletions on both sides
+	writeFile(t, filepath.Join(local, "a.txt"), "hello again")
+	require.NoError(t, os.Remove(filepath.Join(local, "same.txt")))
+	require.NoError(t, remote.Remove(ctx, "/sync/b.txt"))
+	srv.Put(dir, "c.txt", []byte("new remotely"))
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update a.txt", "delete-local b.txt", "download c.txt", "delete same.txt"}, actions(plan))
+	assert.Equal(t, []byte("hello again"), srv.ReadAll("/sync/a.txt"))
+	assert.NoFileExists(t, filepath.Join(local, "b.txt"))
+	assert.Equal(t, "new remotely", readFile(t, filepath.Join(local, "c.txt")))
+	assert.Nil(t, srv.Lookup("/sync/same.txt"))
+
+	// renames on both sides are replayed without transfers
+	uploads := srv.Requests("/v2/file/complete")
+	fileId := srv.Lookup("/sync/a.txt").FileId
+	require.NoError(t, os.Mkdir(filepath.Join(local, "moved"), 0755))
+	require.NoError(t, os.Rename(filepath.Join(local, "a.txt"), filepath.Join(local, "moved", "a2.txt")))
+	require.NoError(t, remote.Rename(ctx, "/sync/c.txt", "/sync/c2.txt"))
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"move-local c2.txt", "move moved/a2.txt"}, actions(plan))
+	assert.Equal(t, "c.txt", plan.Ops[0].From)
+	assert.Equal(t, fileId, srv.Lookup("/sync/moved/a2.txt").FileId)
+	assert.Nil(t, srv.Lookup("/sync/a.txt"))
+	assert.Equal(t, "new remotely", readFile(t, filepath.Join(local, "c2.txt")))
+	assert.Equal(t, uploads, srv.Requests("/v2/file/complete"))
+
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, plan.Ops)
+
+	// a dry run changes nothing, the state included
+	require.NoError(t, os.Remove(filepath.Join(local, "c2.txt")))
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"delete c2.txt"}, actions(plan))
+	assert.NotNil(t, srv.Lookup("/sync/c2.txt"))
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"delete c2.txt"}, actions(plan))
+}
+
+func TestBisyncConflicts(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	remote := drive.NewPathFs(fs, 0)
+	local := t.TempDir()
+	dir := srv.Mkdir(drivetest.RootId, "sync")
+	writeFile(t, filepath.Join(local, "a.txt"), "base")
+	_, err := Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	require.NoError(t, err)
+
+	edit := func(localData string, remoteData string) {
+		writeFile(t, filepath.Join(local, "a.txt"), localData)
+		require.NoError(t, remote.Remove(ctx, "/sync/a.txt"))
+		srv.Put(dir, "a.txt", []byte(remoteData))
+	}
+
+	edit("local", "remote")
+	for i := 0; i < 2; i++ {
+		plan, err := Bisync(ctx, fs, local, "/sync", BisyncOptions{Policy: Manual})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"conflict a.txt"}, actions(plan), "conflicts stay until resolved")
+		assert.Equal(t, "local", readFile(t, filepath.Join(local, "a.txt")))
+		assert.Equal(t, []byte("remote"), srv.ReadAll("/sync/a.txt"))
+	}
+
+	plan, err := Bisync(ctx, fs, local, "/sync", BisyncOptions{Policy: KeepBoth})
+	require.NoError(t, err)
+	require.Len(t, plan.Ops, 3)
+	conflict := plan.Ops[0].Path
+	assert.True(t, strings.HasPrefix(conflict, "a (conflict "), conflict)
+	assert.Equal(t, []string{"move-local " + conflict, "upload " + conflict, "download a.txt"}, actions(plan))
+	assert.Equal(t, "remote", readFile(t, filepath.Join(local, "a.txt")))
+	assert.Equal(t, "local", readFile(t, filepath.Join(local, conflict)))
+	assert.Equal(t, []byte("local"), srv.ReadAll("/sync/"+conflict))
+
+	// the remote file was updated after the local one
+	edit("older", "newer")
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(local, "a.txt"), old, old))
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{Policy: NewestWins})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"download a.txt"}, actions(plan))
+	assert.Equal(t, "newer", readFile(t, filepath.Join(local, "a.txt")))
+
+	// changed the same way on both sides
+	edit("same", "same")
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{Policy: Manual})
+	require.NoError(t, err)
+	assert.Empty(t, plan.Ops)
+
+	// a file and a folder
+	require.NoError(t, os.Mkdir(filepath.Join(local, "b"), 0755))
+	srv.Put(dir, "b", []byte("file"))
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"conflict b"}, actions(plan))
+}
+
+func TestBisyncSafety(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	remote := drive.NewPathFs(fs, 0)
+	local := t.TempDir()
+	srv.Mkdir(drivetest.RootId, "sync")
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		writeFile(t, filepath.Join(local, name), name)
+	}
+	_, err := Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	require.NoError(t, err)
+
+	// a moved remote folder doesn't delete the local files
+	require.NoError(t, remote.Rename(ctx, "/sync", "/moved"))
+	_, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	assert.ErrorIs(t, err, ErrorRootMissing)
+	assert.FileExists(t, filepath.Join(local, "a.txt"))
+	assert.Nil(t, srv.Lookup("/sync"))
+	require.NoError(t, remote.Rename(ctx, "/moved", "/sync"))
+
+	// most of the files deleted on a side
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.NoError(t, remote.Remove(ctx, "/sync/"+name))
+	}
+	plan, err := Bisync(ctx, fs, local, "/sync", BisyncOptions{})
+	assert.ErrorIs(t, err, ErrorTooManyDeletes)
+	assert.Len(t, plan.Ops, 3)
+	assert.FileExists(t, filepath.Join(local, "a.txt"))
+
+	plan, err = Bisync(ctx, fs, local, "/sync", BisyncOptions{MaxDelete: 100})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"delete-local a.txt", "delete-local b.txt", "delete-local c.txt"}, actions(plan))
+	assert.NoFileExists(t, filepath.Join(local, "a.txt"))
+}
@@ -0,0 +1,160 @@
+//go:build linux
+// +build linux
+
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_ATTRIB
+
+// inotify watches folders with the inotify API, folders are not watched recursively.
+type inotify struct {
+	fd     int
+	epfd   int
+	events chan string
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	// mutex guards dirs, the folders by watch descriptor
+	mutex sync.Mutex
+	dirs  map[int32]string
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(os.NewSyscallError("inotify_init1", err), "failed to watch")
+	}
+
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err == nil {
+		err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)})
+		if err != nil {
+			syscall.Close(epfd)
+		}
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrap(os.NewSyscallError("epoll", err), "failed to watch")
+	}
+
+	n := &inotify{
+		fd:     fd,
+		epfd:   epfd,
+		events: make(chan string, 64),
+		done:   make(chan struct{}),
+		dirs:   make(map[int32]string),
+	}
+	n.wg.Add(1)
+	go n.read()
+	return n, nil
+}
+
+func (n *inotify) Add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+	if err != nil {
+		return errors.Wrapf(os.NewSyscallError("inotify_add_watch", err), `failed to watch "%s"`, dir)
+	}
+
+	n.mutex.Lock()
+	n.dirs[int32(wd)] = dir
+	n.mutex.Unlock()
+	return nil
+}
+
+func (n *inotify) Events() <-chan string {
+	return n.events
+}
+
+func (n *inotify) Close() error {
+	close(n.done)
+	n.wg.Wait()
+	syscall.Close(n.epfd)
+	return errors.WithStack(syscall.Close(n.fd))
+}
+
+func (n *inotify) send(name string) bool {
+	select {
+	case n.events <- name:
+		return true
+	case <-n.done:
+		return false
+	}
+}
+
+func (n *inotify) read() {
+	defer n.wg.Done()
+	defer close(n.events)
+
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	ready := make([]syscall.EpollEvent, 1)
+	for {
+		select {
+		case <-n.done:
+			return
+		default:
+		}
+
+		// the timeout lets Close stop the loop
+		count, err := syscall.EpollWait(n.epfd, ready, 200)
+		if err == syscall.EINTR || count == 0 {
+			continue
+		}
+		if err != nil {
+			n.send("")
+			return
+		}
+
+		size, err := syscall.Read(n.fd, buf[:])
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil || size < syscall.SizeofInotifyEvent {
+			// the watched folders have to be scanned
+			n.send("")
+			continue
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= size; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				if !n.send("") {
+					return
+				}
+				continue
+			}
+
+			n.mutex.Lock()
+			dir, ok := n.dirs[event.Wd]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(n.dirs, event.Wd)
+			}
+			n.mutex.Unlock()
+			if !ok || event.Len == 0 {
+				continue
+			}
+
+			name := buf[nameStart:offset]
+			for i, c := range name {
+				if c == 0 {
+					name = name[:i]
+					break
+				}
+			}
+			if !n.send(filepath.Join(dir, string(name))) {
+				return
+			}
+		}
+	}
+}
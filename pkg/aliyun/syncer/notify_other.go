@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package syncer
+
+import "github.com/pkg/errors"
+
+func newNotifier() (notifier, error) {
+	return nil, errors.New("watching folders is only supported on linux")
+}
@@ -0,0 +1,182 @@
+package syncer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+type pusher struct {
+	tree
+	opts Options
+	plan Plan
+}
+
+// Push makes the drive folder remote mirror the local folder, creating remote if needed.
+//
+// The plan is computed first, then applied in order unless opts.DryRun is set.
+// Failed operations don't stop the sync, they are reported by Plan.Failed and
+// the returned error, which is also set if the plan could not be computed.
+func Push(ctx context.Context, fs drive.Fs, local string, remote string, opts Options) (*Plan, error) {
+	p := &pusher{tree: newTree(fs, local, remote), opts: opts}
+
+	fi, err := os.Stat(local)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf(`"%s" is not a folder`, local)
+	}
+
+	node, err := p.fs.Stat(ctx, p.remote)
+	switch {
+	case err == nil && !node.IsDirectory():
+		return nil, errors.Errorf(`"%s" is not a folder`, p.remote)
+	case err == nil:
+		err = p.walk(ctx, "", true)
+	case errors.Is(err, drive.ErrorNotFound):
+		p.plan.add(Op{Action: ActionMkdir, Path: ""})
+		err = p.walk(ctx, "", false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		return &p.plan, nil
+	}
+
+	for i := range p.plan.Ops {
+		p.plan.Ops[i].Err = p.apply(ctx, &p.plan.Ops[i])
+	}
+	if failed := p.plan.Failed(); len(failed) > 0 {
+		return &p.plan, errors.Errorf("%d of %d operations failed, first: %v", len(failed), len(p.plan.Ops), failed[0])
+	}
+	return &p.plan, nil
+}
+
+// walk plans the folder rel, remoteExists is false if the remote folder is still to be created.
+func (p *pusher) walk(ctx context.Context, rel string, remoteExists bool) error {
+	infos, err := ioutil.ReadDir(p.localPath(rel))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	remoteNodes := map[string]*drive.Node{}
+	if remoteExists {
+		nodes, err := p.fs.ReadDir(ctx, p.remotePath(rel))
+		if err != nil {
+			return err
+		}
+		for i := range nodes {
+			remoteNodes[nodes[i].Name] = &nodes[i]
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, fi := range infos {
+		name := fi.Name()
+		child := path.Join(rel, name)
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			// sockets, devices and symbolic links
+			continue
+		}
+		if p.opts.skip(child, fi.IsDir()) {
+			continue
+		}
+
+		seen[name] = true
+		node := remoteNodes[name]
+		if node != nil && node.IsDirectory() != fi.IsDir() {
+			p.plan.add(Op{Action: ActionDelete, Path: child, Reason: "replaced by a " + kind(fi.IsDir())})
+			node = nil
+		}
+
+		if fi.IsDir() {
+			if node == nil {
+				p.plan.add(Op{Action: ActionMkdir, Path: child})
+			}
+			if err := p.walk(ctx, child, node != nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if node == nil {
+			p.plan.add(Op{Action: ActionUpload, Path: child, Size: fi.Size()})
+			continue
+		}
+
+		reason, hash, err := p.changed(fi, child, node)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			p.plan.add(Op{Action: ActionUpdate, Path: child, Size: fi.Size(), Reason: reason, hash: hash})
+		}
+	}
+
+	if !p.opts.Delete {
+		return nil
+	}
+
+	names := make([]string, 0, len(remoteNodes))
+	for name := range remoteNodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node := remoteNodes[name]
+		child := path.Join(rel, name)
+		if seen[name] || p.opts.skip(child, node.IsDirectory()) {
+			continue
+		}
+		p.plan.add(Op{Action: ActionDelete, Path: child, Size: node.Size, Reason: "not found locally"})
+	}
+	return nil
+}
+
+func kind(isDir bool) string {
+	if isDir {
+		return drive.FolderKind
+	}
+	return drive.FileKind
+}
+
+// changed returns why the local file differs from node, "" if it doesn't, and its sha1 if computed.
+func (p *pusher) changed(fi os.FileInfo, rel string, node *drive.Node) (string, string, error) {
+	if fi.Size() != node.Size {
+		return "size changed", "", nil
+	}
+
+	if p.opts.Compare == CompareModTime {
+		// the server keeps the local modification time in milliseconds
+		if !node.LocalModified.IsZero() {
+			if !fi.ModTime().Truncate(time.Millisecond).Equal(node.LocalModified) {
+				return "modified", "", nil
+			}
+			return "", "", nil
+		}
+		updated, err := node.GetTime()
+		if err != nil || fi.ModTime().After(updated) {
+			return "modified", "", nil
+		}
+		return "", "", nil
+	}
+
+	hash, err := sha1File(p.localPath(rel))
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.EqualFold(hash, node.Hash) {
+		return "content changed", hash, nil
+	}
+	return "", hash, nil
+}
@@ -0,0 +1,108 @@
+package syncer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFs(t *testing.T) (drive.Fs, *drivetest.Server) {
+	srv := drivetest.NewServer()
+	t.Cleanup(srv.Close)
+	fs, err := drive.NewFs(context.Background(), &drive.Config{RefreshToken: "token", DeviceId: "device", HttpClient: srv.Client()})
+	require.NoError(t, err)
+	return fs, srv
+}
+
+func writeFile(t *testing.T, name string, data string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+	require.NoError(t, ioutil.WriteFile(name, []byte(data), 0644))
+}
+
+func actions(plan *Plan) []string {
+	var ops []string
+	for _, op := range plan.Ops {
+		ops = append(ops, op.Action.String()+" "+op.Path)
+	}
+	return ops
+}
+
+func TestPush(t *testing.T) {
+	ctx := context.Background()
+	fs, srv := newTestFs(t)
+	local := t.TempDir()
+	writeFile(t, filepath.Join(local, "a.txt"), "hello")
+	writeFile(t, filepath.Join(local, "sub", "b.txt"), "world")
+	writeFile(t, filepath.Join(local, "sub", "debug.log"), "noise")
+
+	opts := Options{Exclude: []string{"*.log"}, DryRun: true}
+	plan, err := Push(ctx, fs, local, "/backup", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mkdir ", "upload a.txt", "mkdir sub", "upload sub/b.txt"}, actions(plan))
+	assert.Nil(t, srv.Lookup("/backup"), "dry run changes nothing")
+
+	opts.DryRun = false
+	_, err = Push(ctx, fs, local, "/backup", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), srv.ReadAll("/backup/a.txt"))
+	assert.Equal(t, []byte("world"), srv.ReadAll("/backup/sub/b.txt"))
+	assert.Nil(t, srv.Lookup("/backup/sub/debug.log"))
+
+	plan, err = Push(ctx, fs, local, "/backup", opts)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Ops, "nothing changed")
+
+	// same size, different content
+	writeFile(t, filepath.Join(local, "a.txt"), "HELLO")
+	srv.Put(srv.Lookup("/backup").FileId, "extra.txt", []byte("extra"))
+	plan, err = Push(ctx, fs, local, "/backup", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update a.txt"}, actions(plan))
+	assert.Equal(t, []byte("HELLO"), srv.ReadAll("/backup/a.txt"))
+	assert.Len(t, srv.Lookup("/backup/a.txt").Revisions, 1, "the old content is kept as a revision")
+	assert.NotNil(t, srv.Lookup("/backup/extra.txt"))
+
+	opts.Delete = true
+	plan, err = Push(ctx, fs, local, "/backup", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"delete extra.txt"}, actions(plan))
+	assert.Nil(t, srv.Lookup("/backup/extra.txt"))
+
+	// the modification time of uploads is kept, same size and modification time are considered unchanged
+	b := filepath.Join(local, "sub", "b.txt")
+	fi, err := os.Stat(b)
+	require.NoError(t, err)
+	uploaded := srv.Lookup("/backup/sub/b.txt").LocalModified
+	assert.True(t, fi.ModTime().Truncate(time.Millisecond).Equal(uploaded))
+	writeFile(t, b, "WORLD")
+	require.NoError(t, os.Chtimes(b, uploaded, uploaded))
+	opts.Compare = CompareModTime
+	plan, err = Push(ctx, fs, local, "/backup", opts)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Ops)
+
+	// an older modification time is a change too
+	old := time.Now().Add(-24 * time.Hour)
+	require.NoError(t, os.Chtimes(b, old, old))
+	opts.DryRun = true
+	plan, err = Push(ctx, fs, local, "/backup", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update sub/b.txt"}, actions(plan))
+	opts.DryRun = false
+
+	opts.Compare = CompareHash
+	opts.Include = []string{"sub/*.txt"}
+	plan, err = Push(ctx, fs, local, "/backup", opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update sub/b.txt"}, actions(plan), "a.txt is neither synced nor deleted")
+	assert.Equal(t, []byte("WORLD"), srv.ReadAll("/backup/sub/b.txt"))
+	assert.NotNil(t, srv.Lookup("/backup/a.txt"))
+}
@@ -0,0 +1,86 @@
+package syncer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultStateName is the state file kept in the local folder by Bisync, unless BisyncOptions.StatePath is set.
+const DefaultStateName = ".aliyundrive-sync.json"
+
+// Entry is a path as it was on both sides after the last sync.
+type Entry struct {
+	NodeId string `json:"file_id"`
+	IsDir  bool   `json:"is_dir,omitempty"`
+	Hash   string `json:"hash,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	// ModTime is the local modification time, Updated the remote update time.
+	ModTime time.Time `json:"mod_time"`
+	Updated string    `json:"updated_at"`
+}
+
+// State is the state database of Bisync, a JSON file mapping slash separated relative paths to entries.
+type State struct {
+	Local   string            `json:"local"`
+	Remote  string            `json:"remote"`
+	Entries map[string]*Entry `json:"entries"`
+}
+
+// LoadState reads the state at name, a missing file is an empty state.
+func LoadState(name string) (*State, error) {
+	state := &State{Entries: map[string]*Entry{}}
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, errors.Wrapf(err, `failed to parse sync state "%s"`, name)
+	}
+	if state.Entries == nil {
+		state.Entries = map[string]*Entry{}
+	}
+	return state, nil
+}
+
+// Save writes the state to name, replacing it atomically.
+func (s *State) Save(name string) error {
+	return writeJSON(name, s)
+}
+
+// writeJSON writes v to name through a temporary file, so that name is never left half written.
+func writeJSON(name string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, name))
+}
+
+// rebase moves the entries of oldPath and below to newPath.
+func (s *State) rebase(oldPath string, newPath string) {
+	moved := map[string]*Entry{}
+	for p, entry := range s.Entries {
+		if p == oldPath || strings.HasPrefix(p, oldPath+"/") {
+			delete(s.Entries, p)
+			moved[path.Join(newPath, strings.TrimPrefix(p, oldPath))] = entry
+		}
+	}
+	for p, entry := range moved {
+		s.Entries[p] = entry
+	}
+}
@@ -0,0 +1,159 @@
+// Package syncer synchronizes a local folder with a drive folder.
+//
+// Push makes a drive folder mirror a local folder, for backups. Files are compared by size and
+// sha1 (Node.Hash) or by size and modification time, only missing and changed files are uploaded,
+// with rapid upload whenever the content is already on the server.
+//
+// Bisync mirrors changes both ways, using a state database of the last sync to tell
+// edits, renames and deletions apart and to detect conflicts.
+//
+// Watch keeps uploading the new and modified files of a local drop folder.
+package syncer
+
+import (
+	"path"
+	"strings"
+)
+
+// Compare selects how a local file and a remote file are found equal, sizes are always compared.
+type Compare int
+
+const (
+	// CompareHash compares the sha1 of the content, local files of the same size are hashed.
+	CompareHash Compare = iota
+	// CompareModTime considers a file changed if its modification time differs from the one kept by the
+	// upload, or if it was modified after the remote file was updated when the server has none.
+	CompareModTime
+)
+
+type Options struct {
+	Compare Compare
+	// Delete moves the remote files and folders which don't exist locally to the recycle bin.
+	Delete bool
+	// Include and Exclude are path.Match patterns, matched against the slash separated path
+	// relative to the synced folders and against the base name, e.g. "*.log" or "build/tmp".
+	// If Include is set only the files matching it are synced, Exclude applies to files and folders.
+	Include []string
+	Exclude []string
+	// DryRun only plans the operations, nothing is changed.
+	DryRun bool
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skip reports whether rel is filtered out by the Include and Exclude patterns.
+func (o *Options) skip(rel string, isDir bool) bool {
+	if matchAny(o.Exclude, rel) {
+		return true
+	}
+	return !isDir && len(o.Include) > 0 && !matchAny(o.Include, rel)
+}
+
+type Action int
+
+const (
+	ActionMkdir Action = iota
+	ActionUpload
+	ActionUpdate
+	ActionDelete
+	ActionMove
+	ActionDownload
+	ActionMkdirLocal
+	ActionDeleteLocal
+	ActionMoveLocal
+	// ActionConflict is a conflict left for the user to resolve, nothing is changed.
+	ActionConflict
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionMkdir:
+		return "mkdir"
+	case ActionUpload:
+		return "upload"
+	case ActionUpdate:
+		return "update"
+	case ActionDelete:
+		return "delete"
+	case ActionMove:
+		return "move"
+	case ActionDownload:
+		return "download"
+	case ActionMkdirLocal:
+		return "mkdir-local"
+	case ActionDeleteLocal:
+		return "delete-local"
+	case ActionMoveLocal:
+		return "move-local"
+	case ActionConflict:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+// Op is a planned operation, Err is set if it failed.
+type Op struct {
+	Action Action
+	// Path is slash separated, relative to the synced folders.
+	Path string
+	// From is the previous path of moves.
+	From   string
+	Size   int64
+	Reason string
+	Err    error
+
+	// hash is the sha1 of the local file if it was computed while planning
+	hash string
+}
+
+func (op Op) String() string {
+	s := op.Action.String() + " " + op.Path
+	if op.From != "" {
+		s = op.Action.String() + " " + op.From + " -> " + op.Path
+	}
+	if op.Reason != "" {
+		s += " (" + op.Reason + ")"
+	}
+	if op.Err != nil {
+		s += ": " + op.Err.Error()
+	}
+	return s
+}
+
+// Plan is the list of operations of a sync, in the order they are applied.
+type Plan struct {
+	Ops []Op
+}
+
+func (p *Plan) add(op Op) {
+	p.Ops = append(p.Ops, op)
+}
+
+// Failed returns the operations which failed.
+func (p *Plan) Failed() []Op {
+	var ops []Op
+	for _, op := range p.Ops {
+		if op.Err != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func (p *Plan) String() string {
+	var b strings.Builder
+	for _, op := range p.Ops {
+		b.WriteString(op.String() + "\n")
+	}
+	return b.String()
+}
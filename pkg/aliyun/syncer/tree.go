@@ -0,0 +1,191 @@
+package syncer
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+// tempSuffix ends the names of partial downloads, which are never synced.
+const tempSuffix = ".aliyundrive-tmp"
+
+// tree is a local folder synced with a drive folder.
+type tree struct {
+	fs     *drive.PathFs
+	local  string
+	remote string
+}
+
+func newTree(fs drive.Fs, local string, remote string) tree {
+	return tree{fs: drive.NewPathFs(fs, 0), local: local, remote: path.Clean("/" + remote)}
+}
+
+func (t *tree) localPath(rel string) string {
+	return filepath.Join(t.local, filepath.FromSlash(rel))
+}
+
+func (t *tree) remotePath(rel string) string {
+	return path.Join(t.remote, rel)
+}
+
+func (t *tree) apply(ctx context.Context, op *Op) error {
+	remotePath := t.remotePath(op.Path)
+	switch op.Action {
+	case ActionMkdir:
+		_, err := t.fs.MkdirAll(ctx, remotePath)
+		return err
+	case ActionDelete:
+		err := t.fs.Remove(ctx, remotePath)
+		if errors.Is(err, drive.ErrorNotFound) {
+			return nil
+		}
+		return err
+	case ActionMove:
+		if _, err := t.fs.MkdirAll(ctx, path.Dir(remotePath)); err != nil {
+			return err
+		}
+		return t.fs.Rename(ctx, t.remotePath(op.From), remotePath)
+	case ActionDownload:
+		return t.download(ctx, op.Path)
+	case ActionMkdirLocal:
+		return errors.WithStack(os.MkdirAll(t.localPath(op.Path), 0755))
+	case ActionDeleteLocal:
+		err := os.Remove(t.localPath(op.Path))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WithStack(err)
+	case ActionMoveLocal:
+		localPath := t.localPath(op.Path)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(os.Rename(t.localPath(op.From), localPath))
+	case ActionConflict:
+		return nil
+	case ActionUpdate:
+		_, err := upload(ctx, t.fs, t.localPath(op.Path), remotePath, op.hash, true)
+		return err
+	}
+
+	_, err := upload(ctx, t.fs, t.localPath(op.Path), remotePath, op.hash, false)
+	return err
+}
+
+// download replaces the local file rel with the remote one, whose update time becomes the modification time.
+// The content is written to a temporary file first, so an interrupted download leaves the local file intact.
+func (t *tree) download(ctx context.Context, rel string) error {
+	node, err := t.fs.Stat(ctx, t.remotePath(rel))
+	if err != nil {
+		return err
+	}
+	if node.IsDirectory() {
+		return errors.Errorf(`"%s" is a folder`, t.remotePath(rel))
+	}
+
+	name := t.localPath(rel)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*"+tempSuffix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	rd, err := t.fs.Fs().Open(ctx, node, nil)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	_, err = io.Copy(tmp, rd)
+	rd.Close()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, `failed to download "%s"`, t.remotePath(rel))
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return errors.WithStack(err)
+	}
+	if t := node.ModTime(); !t.IsZero() {
+		return errors.WithStack(os.Chtimes(name, t, t))
+	}
+	return nil
+}
+
+func sha1File(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+
+	_, hash, err := drive.CalcSha1(f)
+	return hash, err
+}
+
+// upload creates the file at remotePath from the local file, which must not exist remotely unless
+// replace is set, then the remote content is kept as a revision.
+// The sha1 and proof code are sent for rapid upload, hash is computed if it is empty.
+func upload(ctx context.Context, fs *drive.PathFs, localPath string, remotePath string, hash string, replace bool) (*drive.Node, error) {
+	replacer, ok := fs.Fs().(drive.Replacer)
+	if replace && !ok {
+		return nil, errors.Wrapf(drive.ErrorNotSupported, `can't replace "%s"`, remotePath)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// ReplaceFile computes the sha1 and proof code of f itself
+	var proof string
+	if !replace {
+		if hash == "" {
+			if _, hash, err = drive.CalcSha1(f); err != nil {
+				return nil, err
+			}
+		}
+		if proof, err = fs.Fs().CalcProof(fi.Size(), f); err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	dir, name := path.Split(remotePath)
+	parent, err := fs.MkdirAll(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	node := drive.Node{ParentId: parent.NodeId, Name: name, Size: fi.Size(), LocalModified: fi.ModTime()}
+	var nodeId string
+	if replace {
+		nodeId, err = replacer.ReplaceFile(ctx, node, f)
+	} else {
+		nodeId, err = fs.Fs().CreateFileWithProof(ctx, node, f, hash, proof)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to upload "%s"`, localPath)
+	}
+
+	fs.Invalidate(remotePath)
+	return fs.Fs().Get(ctx, nodeId)
+}
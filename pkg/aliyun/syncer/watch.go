@@ -0,0 +1,519 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+)
+
+// DefaultQueueName is the queue file kept in the local folder by Watch, unless WatchOptions.QueuePath is set.
+const DefaultQueueName = ".aliyundrive-upload.json"
+
+const maxRetryDelay = time.Hour
+
+// WatchOptions configures Watch, Include and Exclude are as in Options.
+type WatchOptions struct {
+	Include []string
+	Exclude []string
+	// Debounce is how long a file must keep the same size and modification time
+	// before it is uploaded, 2 seconds if 0.
+	Debounce time.Duration
+	// Poll scans the folder every PollInterval instead of using inotify,
+	// which is also the fallback on other systems. PollInterval is 10 seconds if 0.
+	Poll         bool
+	PollInterval time.Duration
+	// QueuePath is the queue file, DefaultQueueName in the local folder if empty.
+	QueuePath string
+	// RetryDelay is the delay before retrying a failed upload, 10 seconds if 0,
+	// doubled after each failure up to an hour. Uploads are retried forever,
+	// unless MaxAttempts is set.
+	RetryDelay  time.Duration
+	MaxAttempts int
+	// OnUpload is called after each upload attempt, from the goroutine running Watch.
+	OnUpload func(UploadResult)
+}
+
+// UploadResult is the outcome of an upload by Watch.
+type UploadResult struct {
+	// Path is slash separated, relative to the watched folder.
+	Path    string
+	Node    *drive.Node
+	Attempt int
+	// Skipped is true if the remote file already had the same content.
+	Skipped bool
+	Err     error
+}
+
+// startNotifier is newNotifier and readDir is ioutil.ReadDir, replaced by tests.
+var (
+	startNotifier = newNotifier
+	readDir       = ioutil.ReadDir
+)
+
+// notifier reports the paths changed in the folders added to it, "" if anything may have changed.
+type notifier interface {
+	Add(dir string) error
+	Events() <-chan string
+	Close() error
+}
+
+// queueItem is a file waiting for upload.
+type queueItem struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Changed is when the size or modification time last changed, the file is stable Debounce after.
+	Changed   time.Time `json:"changed"`
+	Attempts  int       `json:"attempts,omitempty"`
+	NotBefore time.Time `json:"not_before,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// doneItem is an uploaded file, or one which failed MaxAttempts times.
+type doneItem struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// queue is the persistent state of Watch.
+type queue struct {
+	Local   string                `json:"local"`
+	Remote  string                `json:"remote"`
+	Pending map[string]*queueItem `json:"pending"`
+	Done    map[string]*doneItem  `json:"done"`
+}
+
+type watcher struct {
+	tree
+	opts      WatchOptions
+	filter    Options
+	queuePath string
+	queue     *queue
+	dirty     bool
+	notifier  notifier
+}
+
+type watchUpload struct {
+	path   string
+	item   queueItem
+	result UploadResult
+	// changed is true if the file changed during the upload, which doesn't count as an attempt
+	changed bool
+}
+
+// Watch uploads the new and modified files of the local folder to the drive folder remote,
+// until ctx is done. It is meant for drop folders, e.g. of cameras or scanners:
+// files are never deleted and remote changes are ignored.
+//
+// Changes are seen with inotify on linux, by scanning the folder every opts.PollInterval otherwise.
+// Files are uploaded with CreateFile once their size and modification time are stable for
+// opts.Debounce, so that files still being written are not uploaded. Failed uploads are retried.
+//
+// The queue of files to upload and the list of uploaded files are kept in the queue file,
+// so that a restarted Watch resumes the pending uploads, and uploads the files changed meanwhile.
+//
+// Watch returns ctx.Err() once ctx is done, or the error which prevents it from going on.
+func Watch(ctx context.Context, fs drive.Fs, local string, remote string, opts WatchOptions) error {
+	if opts.Debounce <= 0 {
+		opts.Debounce = 2 * time.Second
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 10 * time.Second
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 10 * time.Second
+	}
+
+	w := &watcher{
+		tree:      newTree(fs, local, remote),
+		opts:      opts,
+		filter:    Options{Include: opts.Include, Exclude: opts.Exclude},
+		queuePath: opts.QueuePath,
+	}
+	if w.queuePath == "" {
+		w.queuePath = filepath.Join(local, DefaultQueueName)
+	}
+	if abs, err := filepath.Abs(local); err == nil {
+		w.local = abs
+	}
+
+	fi, err := os.Stat(w.local)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !fi.IsDir() {
+		return errors.Errorf(`"%s" is not a folder`, local)
+	}
+
+	if err := w.load(); err != nil {
+		return err
+	}
+
+	if !opts.Poll {
+		// without inotify, the folder is polled
+		if n, nerr := startNotifier(); nerr == nil {
+			w.notifier = n
+			defer func() {
+				if w.notifier != nil {
+					w.notifier.Close()
+				}
+			}()
+		}
+	}
+
+	if err := w.scan(""); err != nil {
+		return err
+	}
+	if err := w.save(); err != nil {
+		return err
+	}
+
+	tick := opts.Debounce / 4
+	if tick > time.Second {
+		tick = time.Second
+	}
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	uploads := make(chan watchUpload, 1)
+	busy := false
+	lastScan := time.Now()
+	for {
+		if !busy {
+			if p, item := w.next(time.Now()); p != "" {
+				busy = true
+				go func() {
+					uploads <- w.upload(ctx, p, item)
+				}()
+			}
+		}
+
+		var events <-chan string
+		if w.notifier != nil {
+			events = w.notifier.Events()
+		}
+
+		select {
+		case <-ctx.Done():
+			if busy {
+				w.finish(ctx, <-uploads)
+			}
+			if err := w.save(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case name, ok := <-events:
+			switch {
+			case !ok:
+				// the notifier failed, poll instead
+				w.notifier.Close()
+				w.notifier = nil
+				err = w.scan("")
+			case name == "":
+				err = w.scan("")
+			default:
+				err = w.touch(name)
+			}
+		case u := <-uploads:
+			busy = false
+			w.finish(ctx, u)
+		case now := <-ticker.C:
+			if w.notifier == nil && now.Sub(lastScan) >= opts.PollInterval {
+				lastScan = now
+				err = w.scan("")
+			}
+			if err == nil && w.dirty {
+				err = w.save()
+			}
+		}
+		if err != nil {
+			if busy {
+				w.finish(ctx, <-uploads)
+			}
+			return err
+		}
+	}
+}
+
+func (w *watcher) load() error {
+	w.queue = &queue{}
+	b, err := ioutil.ReadFile(w.queuePath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, w.queue); err != nil {
+			return errors.Wrapf(err, `failed to parse upload queue "%s"`, w.queuePath)
+		}
+	}
+
+	if w.queue.Local != w.local || w.queue.Remote != w.remote {
+		w.queue = &queue{Local: w.local, Remote: w.remote}
+	}
+	if w.queue.Pending == nil {
+		w.queue.Pending = map[string]*queueItem{}
+	}
+	if w.queue.Done == nil {
+		w.queue.Done = map[string]*doneItem{}
+	}
+	return nil
+}
+
+func (w *watcher) save() error {
+	w.dirty = false
+	return writeJSON(w.queuePath, w.queue)
+}
+
+// ignored reports whether the local file rel is never uploaded.
+func (w *watcher) ignored(rel string, isDir bool) bool {
+	if strings.HasSuffix(rel, tempSuffix) || w.filter.skip(rel, isDir) {
+		return true
+	}
+
+	queueName, _ := filepath.Abs(w.queuePath)
+	name := w.localPath(rel)
+	return name == queueName || name == queueName+".tmp"
+}
+
+// scan walks the folder rel, watching its folders and queueing its new and modified files.
+// Scanning the whole folder also forgets the files which don't exist anymore.
+// Only the watched folder must be readable, the folders below it which vanish or can't be read
+// during the walk are skipped, the files known below the unreadable ones are kept.
+func (w *watcher) scan(rel string) error {
+	seen := map[string]bool{}
+	var unreadable []string
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		if w.notifier != nil {
+			if err := w.notifier.Add(w.localPath(rel)); err != nil {
+				// e.g. too many watches, poll instead
+				w.notifier.Close()
+				w.notifier = nil
+			}
+		}
+
+		infos, err := readDir(w.localPath(rel))
+		switch {
+		case err != nil && rel == "":
+			return errors.WithStack(err)
+		case os.IsNotExist(err):
+			// removed meanwhile
+			return nil
+		case err != nil:
+			unreadable = append(unreadable, rel)
+			return nil
+		}
+		for _, fi := range infos {
+			child := path.Join(rel, fi.Name())
+			if (!fi.IsDir() && !fi.Mode().IsRegular()) || w.ignored(child, fi.IsDir()) {
+				continue
+			}
+
+			if fi.IsDir() {
+				if err := walk(child); err != nil {
+					return err
+				}
+				continue
+			}
+			seen[child] = true
+			w.enqueue(child, fi)
+		}
+		return nil
+	}
+
+	if err := walk(rel); err != nil || rel != "" {
+		return err
+	}
+
+	for _, dir := range unreadable {
+		for p := range w.queue.Pending {
+			if strings.HasPrefix(p, dir+"/") {
+				seen[p] = true
+			}
+		}
+		for p := range w.queue.Done {
+			if strings.HasPrefix(p, dir+"/") {
+				seen[p] = true
+			}
+		}
+	}
+	for p := range w.queue.Pending {
+		if !seen[p] {
+			delete(w.queue.Pending, p)
+			w.dirty = true
+		}
+	}
+	for p := range w.queue.Done {
+		if !seen[p] {
+			delete(w.queue.Done, p)
+			w.dirty = true
+		}
+	}
+	return nil
+}
+
+// touch handles an event of the notifier.
+func (w *watcher) touch(name string) error {
+	rel, err := filepath.Rel(w.local, name)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+
+	fi, err := os.Stat(name)
+	switch {
+	case os.IsNotExist(err):
+		if _, ok := w.queue.Pending[rel]; ok {
+			delete(w.queue.Pending, rel)
+			w.dirty = true
+		}
+		return nil
+	case err != nil:
+		return errors.WithStack(err)
+	case w.ignored(rel, fi.IsDir()):
+		return nil
+	case fi.IsDir():
+		// files may have been created before the folder is watched
+		return w.scan(rel)
+	case fi.Mode().IsRegular():
+		w.enqueue(rel, fi)
+	}
+	return nil
+}
+
+// enqueue queues the file rel unless it is already uploaded, or restarts its debounce if it changed.
+func (w *watcher) enqueue(rel string, fi os.FileInfo) {
+	if done := w.queue.Done[rel]; done != nil && done.Size == fi.Size() && done.ModTime.Equal(fi.ModTime()) {
+		return
+	}
+
+	item := w.queue.Pending[rel]
+	if item != nil && item.Size == fi.Size() && item.ModTime.Equal(fi.ModTime()) {
+		return
+	}
+
+	// new content, failures of the previous one don't count
+	w.queue.Pending[rel] = &queueItem{Size: fi.Size(), ModTime: fi.ModTime(), Changed: time.Now()}
+	w.dirty = true
+}
+
+// next returns the first file ready for upload, "" if there is none.
+func (w *watcher) next(now time.Time) (string, queueItem) {
+	paths := make([]string, 0, len(w.queue.Pending))
+	for p := range w.queue.Pending {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		item := w.queue.Pending[p]
+		if now.Before(item.NotBefore) || now.Sub(item.Changed) < w.opts.Debounce {
+			continue
+		}
+
+		// the notifier may miss changes, e.g. when polling
+		fi, err := os.Stat(w.localPath(p))
+		if err != nil || !fi.Mode().IsRegular() {
+			delete(w.queue.Pending, p)
+			w.dirty = true
+			continue
+		}
+		if fi.Size() != item.Size || !fi.ModTime().Equal(item.ModTime) {
+			item.Size, item.ModTime, item.Changed = fi.Size(), fi.ModTime(), now
+			w.dirty = true
+			continue
+		}
+		return p, *item
+	}
+	return "", queueItem{}
+}
+
+// upload runs in its own goroutine, it only reads the watcher fields which don't change.
+func (w *watcher) upload(ctx context.Context, rel string, item queueItem) watchUpload {
+	u := watchUpload{path: rel, item: item, result: UploadResult{Path: rel, Attempt: item.Attempts + 1}}
+	f, err := os.Open(w.localPath(rel))
+	if err != nil {
+		u.result.Err = errors.WithStack(err)
+		return u
+	}
+	defer f.Close()
+
+	stable := func() bool {
+		fi, err := f.Stat()
+		return err == nil && fi.Size() == item.Size && fi.ModTime().Equal(item.ModTime)
+	}
+	if !stable() {
+		u.changed = true
+		return u
+	}
+
+	remotePath := w.remotePath(rel)
+	if node, err := w.fs.Stat(ctx, remotePath); err == nil && !node.IsDirectory() && node.Size == item.Size {
+		hash, err := sha1File(w.localPath(rel))
+		if err == nil && strings.EqualFold(hash, node.Hash) {
+			u.result.Node, u.result.Skipped = node, true
+			return u
+		}
+	}
+
+	u.result.Node, u.result.Err = w.fs.Create(ctx, remotePath, f, item.Size)
+	if u.result.Err == nil && !stable() {
+		// uploaded while being written, the final content is uploaded once stable
+		u.changed = true
+	}
+	return u
+}
+
+// finish records the outcome of an upload.
+func (w *watcher) finish(ctx context.Context, u watchUpload) {
+	w.dirty = true
+	item := w.queue.Pending[u.path]
+	if item == nil || item.Size != u.item.Size || !item.ModTime.Equal(u.item.ModTime) {
+		// changed or removed during the upload, the queue is up to date
+		return
+	}
+
+	switch {
+	case u.changed:
+		item.Changed = time.Now()
+		return
+	case u.result.Err != nil && ctx.Err() != nil:
+		// interrupted, not a failure
+		return
+	case u.result.Err != nil:
+		item.Attempts++
+		item.Error = u.result.Err.Error()
+		delay := w.opts.RetryDelay
+		for i := 1; i < item.Attempts && delay < maxRetryDelay; i++ {
+			delay *= 2
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		item.NotBefore = time.Now().Add(delay)
+
+		if w.opts.MaxAttempts > 0 && item.Attempts >= w.opts.MaxAttempts {
+			delete(w.queue.Pending, u.path)
+			w.queue.Done[u.path] = &doneItem{Size: item.Size, ModTime: item.ModTime, Error: item.Error}
+		}
+	default:
+		delete(w.queue.Pending, u.path)
+		w.queue.Done[u.path] = &doneItem{Size: item.Size, ModTime: item.ModTime}
+	}
+
+	if w.opts.OnUpload != nil {
+		w.opts.OnUpload(u.result)
+	}
+}
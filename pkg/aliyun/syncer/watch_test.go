@@ -0,0 +1,175 @@
+package syncer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startWatch runs Watch until stop is called, results returns the uploads reported so far.
+func startWatch(t *testing.T, fs drive.Fs, local string, opts WatchOptions) (results func() []UploadResult, stop func()) {
+	var mutex sync.Mutex
+	var reported []UploadResult
+	opts.OnUpload = func(result UploadResult) {
+		mutex.Lock()
+		reported = append(reported, result)
+		mutex.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, fs, local, "/drop", opts)
+	}()
+	results = func() []UploadResult {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]UploadResult(nil), reported...)
+	}
+	stop = func() {
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	}
+	return results, stop
+}
+
+// eventually waits for n uploads to be reported, then checks the content of remotePath.
+func eventually(t *testing.T, srv *drivetest.Server, results func() []UploadResult, n int, remotePath string, data string) {
+	require.Eventually(t, func() bool {
+		return len(results()) >= n
+	}, 5*time.Second, 10*time.Millisecond, remotePath)
+	assert.Equal(t, data, string(srv.ReadAll(remotePath)))
+}
+
+func testWatch(t *testing.T, poll bool) {
+	fs, srv := newTestFs(t)
+	local := t.TempDir()
+	writeFile(t, filepath.Join(local, "old.txt"), "old")
+	opts := WatchOptions{Debounce: 100 * time.Millisecond, Poll: poll, PollInterval: 20 * time.Millisecond}
+	if !poll && runtime.GOOS == "linux" {
+		// changes must be seen by inotify
+		opts.PollInterval = time.Hour
+	}
+
+	results, stop := startWatch(t, fs, local, opts)
+	eventually(t, srv, results, 1, "/drop/old.txt", "old")
+
+	// a file still being written is uploaded once complete
+	f, err := os.Create(filepath.Join(local, "scan.pdf"))
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := f.WriteString("page ")
+		require.NoError(t, err)
+		time.Sleep(30 * time.Millisecond)
+	}
+	require.NoError(t, f.Close())
+	writeFile(t, filepath.Join(local, "photos", "1.jpg"), "photo")
+	eventually(t, srv, results, 3, "/drop/scan.pdf", "page page page page page ")
+	assert.Equal(t, []byte("photo"), srv.ReadAll("/drop/photos/1.jpg"))
+	time.Sleep(200 * time.Millisecond)
+	stop()
+	assert.Len(t, results(), 3, "each file is uploaded once")
+	assert.FileExists(t, filepath.Join(local, DefaultQueueName))
+	assert.Nil(t, srv.Lookup("/drop/"+DefaultQueueName))
+
+	// files changed while stopped are uploaded on restart, the others are not uploaded again
+	writeFile(t, filepath.Join(local, "old.txt"), "modified")
+	results, stop = startWatch(t, fs, local, opts)
+	eventually(t, srv, results, 1, "/drop/old.txt", "modified")
+	time.Sleep(200 * time.Millisecond)
+	stop()
+	require.Len(t, results(), 1)
+	assert.Equal(t, "old.txt", results()[0].Path)
+}
+
+func TestWatch(t *testing.T) {
+	testWatch(t, false)
+}
+
+func TestWatchPolling(t *testing.T) {
+	testWatch(t, true)
+}
+
+func TestWatchRetry(t *testing.T) {
+	fs, srv := newTestFs(t)
+	local := t.TempDir()
+	srv.Fail("/v2/file/create_with_proof", 400, "InvalidParameter")
+	writeFile(t, filepath.Join(local, "a.txt"), "hello")
+
+	results, stop := startWatch(t, fs, local, WatchOptions{Debounce: 10 * time.Millisecond, RetryDelay: 50 * time.Millisecond})
+	eventually(t, srv, results, 2, "/drop/a.txt", "hello")
+	stop()
+	reported := results()
+	require.Len(t, reported, 2)
+	assert.Error(t, reported[0].Err)
+	assert.Equal(t, 1, reported[0].Attempt)
+	assert.NoError(t, reported[1].Err)
+	assert.Equal(t, 2, reported[1].Attempt)
+}
+
+func TestWatchWithoutNotifier(t *testing.T) {
+	startNotifier = func() (notifier, error) {
+		return nil, errors.New("no inotify")
+	}
+	defer func() { startNotifier = newNotifier }()
+
+	fs, srv := newTestFs(t)
+	local := t.TempDir()
+	writeFile(t, filepath.Join(local, "a.txt"), "hello")
+
+	// the default intervals: the folder is polled, the watcher keeps running
+	results, stop := startWatch(t, fs, local, WatchOptions{})
+	eventually(t, srv, results, 1, "/drop/a.txt", "hello")
+	time.Sleep(1500 * time.Millisecond)
+	stop()
+	assert.Len(t, results(), 1)
+}
+
+func TestWatchScanErrors(t *testing.T) {
+	local := t.TempDir()
+	writeFile(t, filepath.Join(local, "a.txt"), "a")
+	writeFile(t, filepath.Join(local, "locked", "b.txt"), "b")
+	writeFile(t, filepath.Join(local, "gone", "c.txt"), "c")
+	var failRoot bool
+	readDir = func(name string) ([]os.FileInfo, error) {
+		switch {
+		case failRoot || filepath.Base(name) == "locked":
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+		case filepath.Base(name) == "gone":
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		return ioutil.ReadDir(name)
+	}
+	defer func() { readDir = ioutil.ReadDir }()
+
+	w := &watcher{
+		tree:      newTree(nil, local, "/drop"),
+		queuePath: filepath.Join(local, DefaultQueueName),
+		queue: &queue{Pending: map[string]*queueItem{}, Done: map[string]*doneItem{
+			"locked/b.txt": {Size: 1},
+			"gone/c.txt":   {Size: 1},
+		}},
+	}
+	w.local = local
+
+	// the subfolders which vanish or can't be read are skipped
+	require.NoError(t, w.scan(""))
+	assert.Contains(t, w.queue.Pending, "a.txt")
+	assert.Contains(t, w.queue.Done, "locked/b.txt", "the files of an unreadable folder are kept")
+	assert.NotContains(t, w.queue.Done, "gone/c.txt")
+	require.NoError(t, w.scan("locked"))
+
+	failRoot = true
+	assert.ErrorIs(t, w.scan(""), os.ErrPermission)
+}
@@ -0,0 +1,481 @@
+// Package webdav serves an aliyun drive over WebDAV.
+//
+// FileSystem implements golang.org/x/net/webdav.FileSystem on top of a drive.Fs:
+// PROPFIND is answered with Get/ListAll, GET (with ranges) with Open, PUT with CreateFile,
+// MOVE with Move, COPY with the server side Copy, DELETE with Remove and MKCOL with CreateFolder.
+package webdav
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/iofs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+// FileSystem implements webdav.FileSystem, nodes are cached by a drive.PathFs.
+type FileSystem struct {
+	fs *drive.PathFs
+}
+
+var _ webdav.FileSystem = (*FileSystem)(nil)
+
+func NewFileSystem(fs drive.Fs, cacheTTL time.Duration) *FileSystem {
+	return &FileSystem{fs: drive.NewPathFs(fs, cacheTTL)}
+}
+
+// osError maps drive errors to the os errors expected by webdav.Handler.
+func osError(op string, name string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		err = os.ErrNotExist
+	case errors.Is(err, drive.ErrorAlreadyExisted):
+		err = os.ErrExist
+	case errors.Is(err, os.ErrPermission):
+		err = os.ErrPermission
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func (fsys *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if _, err := fsys.fs.Stat(ctx, name); err == nil {
+		return osError("mkdir", name, os.ErrExist)
+	}
+
+	parent, err := fsys.fs.Stat(ctx, path.Dir(path.Clean("/"+name)))
+	if err != nil {
+		return osError("mkdir", name, err)
+	}
+
+	if !parent.IsDirectory() {
+		return osError("mkdir", name, os.ErrNotExist)
+	}
+
+	_, err = fsys.fs.MkdirAll(ctx, name)
+	return osError("mkdir", name, err)
+}
+
+func (fsys *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return fsys.create(ctx, name, flag)
+	}
+
+	node, err := fsys.fs.Stat(ctx, name)
+	if err != nil {
+		return nil, osError("open", name, err)
+	}
+
+	return &file{Reader: drive.NewReader(ctx, fsys.fs.Fs(), node), ctx: ctx, fs: fsys, node: node, name: name}, nil
+}
+
+func (fsys *FileSystem) create(ctx context.Context, name string, flag int) (webdav.File, error) {
+	node, err := fsys.fs.Stat(ctx, name)
+	switch {
+	case err == nil && node.IsDirectory():
+		return nil, osError("open", name, errors.New("is a directory"))
+	case err == nil && flag&os.O_EXCL != 0:
+		return nil, osError("open", name, os.ErrExist)
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, osError("open", name, err)
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, osError("open", name, err)
+	}
+
+	parent, err := fsys.fs.Stat(ctx, path.Dir(path.Clean("/"+name)))
+	if err != nil || !parent.IsDirectory() {
+		return nil, osError("open", name, os.ErrNotExist)
+	}
+
+	// the drive needs the size and sha1 before uploading, spool the content first
+	tmp, err := ioutil.TempFile("", "aliyundrive-webdav-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &writer{ctx: ctx, fs: fsys, name: name, tmp: tmp}, nil
+}
+
+func (fsys *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	return osError("remove", name, fsys.fs.Remove(ctx, name))
+}
+
+func (fsys *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return osError("rename", oldName, fsys.fs.Rename(ctx, oldName, newName))
+}
+
+func (fsys *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	node, err := fsys.fs.Stat(ctx, name)
+	if err != nil {
+		return nil, osError("stat", name, err)
+	}
+
+	return fileInfo{iofs.FileInfo(node)}, nil
+}
+
+// fileInfo adds the ETag and the content type of a node to iofs.FileInfo.
+type fileInfo struct {
+	os.FileInfo
+}
+
+func (fi fileInfo) node() *drive.Node {
+	return fi.Sys().(*drive.Node)
+}
+
+// ETag returns the sha1 of the content, which is stable across renames and moves.
+func (fi fileInfo) ETag(ctx context.Context) (string, error) {
+	node := fi.node()
+	if node.Hash == "" {
+		return "", webdav.ErrNotImplemented
+	}
+
+	return strconv.Quote(strings.ToLower(node.Hash)), nil
+}
+
+func (fi fileInfo) ContentType(ctx context.Context) (string, error) {
+	if ctype := mime.TypeByExtension(path.Ext(fi.Name())); ctype != "" {
+		return ctype, nil
+	}
+
+	return "", webdav.ErrNotImplemented
+}
+
+// file is opened for reading, the content is downloaded lazily from the current offset.
+type file struct {
+	*drive.Reader
+	ctx    context.Context
+	fs     *FileSystem
+	node   *drive.Node
+	name   string
+	listed bool
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{iofs.FileInfo(f.node)}, nil
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.node.IsDirectory() {
+		return nil, osError("readdir", f.name, errors.New("not a directory"))
+	}
+
+	if f.listed {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+
+	nodes, err := f.fs.fs.ReadDir(f.ctx, f.name)
+	if err != nil {
+		return nil, osError("readdir", f.name, err)
+	}
+
+	// the whole listing is returned at once, ListAll has already fetched all the pages
+	f.listed = true
+	infos := make([]os.FileInfo, len(nodes))
+	for i := range nodes {
+		infos[i] = fileInfo{iofs.FileInfo(&nodes[i])}
+	}
+	return infos, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.node.IsDirectory() {
+		return 0, osError("read", f.name, errors.New("is a directory"))
+	}
+
+	n, err := f.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = osError("read", f.name, err)
+	}
+	return n, err
+}
+
+func (f *file) Write([]byte) (int, error) {
+	return 0, osError("write", f.name, os.ErrPermission)
+}
+
+// writer spools the content to a temporary file, which is uploaded on Close.
+type writer struct {
+	ctx  context.Context
+	fs   *FileSystem
+	name string
+	tmp  *os.File
+	size int64
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.tmp.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *writer) Close() error {
+	defer os.Remove(w.tmp.Name())
+	defer w.tmp.Close()
+
+	if _, err := w.tmp.Seek(0, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err := w.fs.fs.Create(w.ctx, w.name, w.tmp, w.size)
+	return osError("write", w.name, err)
+}
+
+func (w *writer) Read([]byte) (int, error) {
+	return 0, osError("read", w.name, os.ErrPermission)
+}
+
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	// webdav.Handler seeks to find the size of what it has written
+	return w.tmp.Seek(offset, whence)
+}
+
+func (w *writer) Readdir(int) ([]os.FileInfo, error) {
+	return nil, osError("readdir", w.name, errors.New("not a directory"))
+}
+
+func (w *writer) Stat() (os.FileInfo, error) {
+	return w.tmp.Stat()
+}
+
+// Handler is a webdav.Handler serving a FileSystem.
+//
+// COPY is done on the server side by drive.Fs.Copy instead of downloading and
+// uploading again, the locks of the destination are checked as by webdav.Handler.
+type Handler struct {
+	webdav.Handler
+	fs *FileSystem
+}
+
+// NewHandler creates a Handler serving fsys under prefix, with an in memory lock system.
+func NewHandler(fsys *FileSystem, prefix string) *Handler {
+	return &Handler{
+		Handler: webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: fsys,
+			LockSystem: webdav.NewMemLS(),
+		},
+		fs: fsys,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "COPY" {
+		status, err := h.handleCopy(r)
+		if status != 0 {
+			w.WriteHeader(status)
+			if status >= 400 {
+				_, _ = w.Write([]byte(http.StatusText(status)))
+			}
+		}
+		if h.Logger != nil {
+			h.Logger(r, err)
+		}
+		return
+	}
+
+	h.Handler.ServeHTTP(w, r)
+}
+
+func (h *Handler) stripPrefix(p string) (string, bool) {
+	if h.Prefix == "" {
+		return path.Clean("/" + p), true
+	}
+
+	if r := strings.TrimPrefix(p, h.Prefix); len(r) < len(p) {
+		return path.Clean("/" + r), true
+	}
+	return "", false
+}
+
+func (h *Handler) handleCopy(r *http.Request) (int, error) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || r.Header.Get("Destination") == "" {
+		return http.StatusBadRequest, errors.New("invalid destination")
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return http.StatusBadGateway, errors.New("invalid destination")
+	}
+
+	src, ok := h.stripPrefix(r.URL.Path)
+	if !ok {
+		return http.StatusNotFound, errors.New("prefix mismatch")
+	}
+	dst, ok := h.stripPrefix(u.Path)
+	if !ok {
+		return http.StatusBadGateway, errors.New("invalid destination")
+	}
+	if isAncestor(src, dst) || isAncestor(dst, src) {
+		return http.StatusForbidden, errors.New("destination equals source or is an ancestor or descendant of it")
+	}
+
+	release, status, err := h.confirmLocks(r, dst)
+	if err != nil {
+		return status, err
+	}
+	defer release()
+
+	ctx := r.Context()
+	srcNode, err := h.fs.fs.Stat(ctx, src)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	parent, err := h.fs.fs.Stat(ctx, path.Dir(dst))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusConflict, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	case !parent.IsDirectory():
+		return http.StatusConflict, errors.Errorf(`"%s" is not a folder`, path.Dir(dst))
+	}
+
+	created := true
+	_, err = h.fs.fs.Stat(ctx, dst)
+	switch {
+	case err == nil:
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, os.ErrExist
+		}
+		if err := h.fs.RemoveAll(ctx, dst); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		created = false
+	case !errors.Is(err, os.ErrNotExist):
+		return http.StatusInternalServerError, err
+	}
+
+	if srcNode.IsDirectory() && r.Header.Get("Depth") == "0" {
+		// only the collection itself, without its members
+		err = h.fs.Mkdir(ctx, dst, 0)
+	} else {
+		_, err = h.fs.fs.Copy(ctx, src, dst)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if created {
+		return http.StatusCreated, nil
+	}
+	return http.StatusNoContent, nil
+}
+
+// isAncestor reports whether p is a or below a.
+func isAncestor(a string, p string) bool {
+	return a == p || a == "/" || strings.HasPrefix(p, a+"/")
+}
+
+// confirmLocks checks that the request can write dst, as webdav.Handler does: dst is locked for
+// the request if it has no If header, else one of the lists of the If header must hold its locks.
+func (h *Handler) confirmLocks(r *http.Request, dst string) (release func(), status int, err error) {
+	header := r.Header.Get("If")
+	if header == "" {
+		now := time.Now()
+		token, err := h.LockSystem.Create(now, webdav.LockDetails{Root: dst, Duration: -1, ZeroDepth: true})
+		if err == webdav.ErrLocked {
+			return nil, webdav.StatusLocked, err
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return func() { _ = h.LockSystem.Unlock(now, token) }, 0, nil
+	}
+
+	lists, ok := parseIf(header)
+	if !ok {
+		return nil, http.StatusBadRequest, errors.New("invalid If header")
+	}
+	for _, l := range lists {
+		src := ""
+		if l.resource != "" {
+			u, err := url.Parse(l.resource)
+			if err != nil || (u.Host != "" && u.Host != r.Host) {
+				continue
+			}
+			if src, ok = h.stripPrefix(u.Path); !ok {
+				continue
+			}
+		}
+		release, err := h.LockSystem.Confirm(time.Now(), src, dst, l.conditions...)
+		if err == webdav.ErrConfirmationFailed {
+			continue
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return release, 0, nil
+	}
+	return nil, http.StatusPreconditionFailed, webdav.ErrLocked
+}
+
+// ifList is a list of conditions of an If header, tagged with the resource it applies to.
+type ifList struct {
+	resource   string
+	conditions []webdav.Condition
+}
+
+// parseIf parses an If header, see http://www.webdav.org/specs/rfc4918.html#HEADER_If.
+func parseIf(s string) ([]ifList, bool) {
+	var lists []ifList
+	resource := ""
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		switch s[0] {
+		case '<':
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				return nil, false
+			}
+			resource, s = s[1:end], s[end+1:]
+		case '(':
+			l := ifList{resource: resource}
+			for s = strings.TrimSpace(s[1:]); !strings.HasPrefix(s, ")"); s = strings.TrimSpace(s) {
+				var c webdav.Condition
+				if strings.HasPrefix(s, "Not") {
+					c.Not, s = true, strings.TrimSpace(s[3:])
+				}
+				if s == "" {
+					return nil, false
+				}
+				closing := map[byte]byte{'<': '>', '[': ']'}[s[0]]
+				end := strings.IndexByte(s, closing)
+				if closing == 0 || end < 0 {
+					return nil, false
+				}
+				if s[0] == '<' {
+					c.Token = s[1:end]
+				} else {
+					c.ETag = s[1:end]
+				}
+				l.conditions = append(l.conditions, c)
+				s = s[end+1:]
+			}
+			if len(l.conditions) == 0 {
+				return nil, false
+			}
+			lists = append(lists, l)
+			s = s[1:]
+		default:
+			return nil, false
+		}
+	}
+	return lists, len(lists) > 0
+}
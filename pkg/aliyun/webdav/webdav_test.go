@@ -0,0 +1,147 @@
+package webdav
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/K265/aliyundrive-go/internal/drivetest"
+	"github.com/K265/aliyundrive-go/internal/httpauth"
+	"github.com/K265/aliyundrive-go/pkg/aliyun/drive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestHandler(t *testing.T) {
+	srv := drivetest.NewServer()
+	defer srv.Close()
+	fs, err := drive.NewFs(context.Background(), &drive.Config{RefreshToken: "token", DeviceId: "device", HttpClient: srv.Client()})
+	require.NoError(t, err)
+
+	dav := httptest.NewServer(httpauth.BasicAuth(NewHandler(NewFileSystem(fs, time.Minute), "/dav"), "aliyundrive", "user", "secret"))
+	defer dav.Close()
+
+	do := func(method string, p string, body string, headers ...string) (*http.Response, string) {
+		req, err := http.NewRequest(method, dav.URL+p, strings.NewReader(body))
+		require.NoError(t, err)
+		req.SetBasicAuth("user", "secret")
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(b)
+	}
+
+	res, _ := http.Get(dav.URL + "/dav/")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res, _ = do("MKCOL", "/dav/docs", "")
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	res, _ = do("MKCOL", "/dav/missing/docs", "")
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+
+	res, _ = do("PUT", "/dav/docs/a.txt", "hello world")
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, []byte("hello world"), srv.ReadAll("/docs/a.txt"))
+
+	res, body := do("GET", "/dav/docs/a.txt", "", "Range", "bytes=6-")
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "world", body)
+	assert.Equal(t, `"`+strings.ToLower(srv.Lookup("/docs/a.txt").Hash())+`"`, res.Header.Get("ETag"))
+
+	res, body = do("PROPFIND", "/dav/docs", "", "Depth", "1")
+	assert.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	assert.Contains(t, body, "/dav/docs/a.txt")
+	assert.Contains(t, body, "<D:getcontentlength>11</D:getcontentlength>")
+
+	res, _ = do("COPY", "/dav/docs", "", "Destination", dav.URL+"/dav/copy")
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, []byte("hello world"), srv.ReadAll("/copy/a.txt"))
+	res, _ = do("COPY", "/dav/docs", "", "Destination", dav.URL+"/dav/copy", "Overwrite", "F")
+	assert.Equal(t, http.StatusPreconditionFailed, res.StatusCode)
+
+	res, _ = do("MOVE", "/dav/copy/a.txt", "", "Destination", dav.URL+"/dav/b.txt")
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Nil(t, srv.Lookup("/copy/a.txt"))
+	assert.Equal(t, []byte("hello world"), srv.ReadAll("/b.txt"))
+
+	res, _ = do("DELETE", "/dav/docs", "")
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Nil(t, srv.Lookup("/docs"))
+	res, _ = do("GET", "/dav/docs/a.txt", "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestCopy(t *testing.T) {
+	srv := drivetest.NewServer()
+	defer srv.Close()
+	fs, err := drive.NewFs(context.Background(), &drive.Config{RefreshToken: "token", DeviceId: "device", HttpClient: srv.Client()})
+	require.NoError(t, err)
+	dav := httptest.NewServer(NewHandler(NewFileSystem(fs, 0), ""))
+	defer dav.Close()
+
+	do := func(method string, p string, body string, headers ...string) *http.Response {
+		req, err := http.NewRequest(method, dav.URL+p, strings.NewReader(body))
+		require.NoError(t, err)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	a := srv.Mkdir(drivetest.RootId, "a")
+	srv.Put(srv.Mkdir(a, "b"), "c.txt", []byte("c"))
+	srv.Put(drivetest.RootId, "file", []byte("file"))
+
+	// the source is kept
+	assert.Equal(t, http.StatusForbidden, do("COPY", "/a/b", "", "Destination", "/a", "Overwrite", "T").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("COPY", "/a", "", "Destination", "/a/b/d").StatusCode)
+	assert.Equal(t, []byte("c"), srv.ReadAll("/a/b/c.txt"))
+	assert.Equal(t, http.StatusConflict, do("COPY", "/a", "", "Destination", "/file/a").StatusCode)
+	assert.Equal(t, http.StatusConflict, do("COPY", "/a", "", "Destination", "/missing/a").StatusCode)
+
+	srv.Fail("/adrive/v3/file/list", 500, "InternalError")
+	assert.Equal(t, http.StatusInternalServerError, do("COPY", "/a", "", "Destination", "/copy").StatusCode)
+	assert.Nil(t, srv.Lookup("/copy"))
+
+	// a locked destination is only written by the lock holder
+	lockInfo := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	res := do("LOCK", "/copy", lockInfo, "Timeout", "Second-60")
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	token := res.Header.Get("Lock-Token")
+	require.NotEmpty(t, token)
+	assert.Equal(t, http.StatusLocked, do("COPY", "/a", "", "Destination", "/copy").StatusCode)
+	assert.Equal(t, http.StatusPreconditionFailed, do("COPY", "/a", "", "Destination", "/copy", "If", "(<opaquelocktoken:other>)").StatusCode)
+	assert.Equal(t, http.StatusCreated, do("COPY", "/a", "", "Destination", "/copy", "If", "("+token+")").StatusCode)
+	assert.Equal(t, []byte("c"), srv.ReadAll("/copy/b/c.txt"))
+}
+
+func TestParseIf(t *testing.T) {
+	lists, ok := parseIf(`(<urn:a> ["etag"]) (Not <urn:b>)`)
+	require.True(t, ok)
+	require.Len(t, lists, 2)
+	assert.Equal(t, []webdav.Condition{{Token: "urn:a"}, {ETag: `"etag"`}}, lists[0].conditions)
+	assert.Equal(t, []webdav.Condition{{Not: true, Token: "urn:b"}}, lists[1].conditions)
+
+	lists, ok = parseIf(`<http://host/a> (<urn:a>) <http://host/b> (<urn:b>)`)
+	require.True(t, ok)
+	assert.Equal(t, "http://host/a", lists[0].resource)
+	assert.Equal(t, "http://host/b", lists[1].resource)
+
+	for _, invalid := range []string{"", "()", "(<urn:a>", "urn:a", "(Not)", "(<urn:a>) x"} {
+		_, ok := parseIf(invalid)
+		assert.False(t, ok, invalid)
+	}
+}